@@ -5,4 +5,7 @@ import "github.com/pion/webrtc/v4"
 type IceServersConfig struct {
 	IceServers   []webrtc.ICEServer
 	DoThroughput bool
+	// IPFamilies lists the address families to test separately, empty
+	// tests without limiting the family
+	IPFamilies []string
 }
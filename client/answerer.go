@@ -0,0 +1,294 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/signaling"
+	"github.com/nimbleape/iceperf-agent/stats"
+	"github.com/pion/stun/v2"
+	"github.com/pion/webrtc/v4"
+)
+
+// answererTestTimeout is how long a test, or a run without any messages, is
+// kept open without the offering agent finishing it
+const answererTestTimeout = 5 * time.Minute
+
+// RemoteAnswerer answers the tests of offering agents in other locations,
+// so the relayed path runs between the agents. It accepts the runs
+// announced in the room set by distributed.room, and answers the tests of
+// each in the run's own room.
+type RemoteAnswerer struct {
+	config *config.Config
+	logger *slog.Logger
+}
+
+// answererRun is a run the answerer accepted
+type answererRun struct {
+	config   *config.Config
+	peer     *signaling.Peer
+	logger   *slog.Logger
+	accepted map[signaling.ICEServer]bool
+	// acceptedURLs are the accepted ICE servers whatever their provider
+	acceptedURLs map[string]bool
+	tests        map[string]*answererTest
+}
+
+type answererTest struct {
+	cp      *ConnectionPair
+	started time.Time
+}
+
+func NewRemoteAnswerer(cc *config.Config) *RemoteAnswerer {
+	return &RemoteAnswerer{
+		config: cc,
+		logger: cc.Logger.With("peer", "Answerer"),
+	}
+}
+
+func (a *RemoteAnswerer) join(room string) *signaling.Peer {
+	d := a.config.Distributed
+	return signaling.NewPeer(d.URL(), d.Token, room, signaling.RoleAnswerer)
+}
+
+// Serve accepts runs and answers their tests until ctx is done
+func (a *RemoteAnswerer) Serve(ctx context.Context) error {
+	lobby := a.join(a.config.Distributed.Room)
+	if err := lobby.Sync(ctx); err != nil {
+		a.logger.Error("Error joining the signaling room", "err", err)
+	}
+	a.logger.Info("Waiting for runs", "room", a.config.Distributed.Room)
+	// runs already accepted, by when they were, in case the offerer
+	// announces one again
+	runs := make(map[string]time.Time)
+	for ctx.Err() == nil {
+		msgs, err := lobby.Receive(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				a.logger.Error("Error polling the signaling server", "err", err)
+				sleep(ctx, time.Second)
+			}
+			continue
+		}
+		for _, m := range msgs {
+			if m.Type != signaling.TypeRun || m.Run == nil {
+				continue
+			}
+			accepted := acceptICEServers(m.Run.ICEServers, a.config.Distributed.Providers)
+			if _, ok := runs[m.Run.ID]; !ok {
+				a.logger.Info("Accepting run", "testRunId", m.Run.ID, "offered", len(m.Run.ICEServers), "accepted", len(accepted))
+				runs[m.Run.ID] = time.Now()
+				if len(accepted) > 0 {
+					go a.serveRun(ctx, m.Run.ID, accepted)
+				}
+			}
+			reply := signaling.Message{Type: signaling.TypeAccept, Run: &signaling.Run{ID: m.Run.ID, ICEServers: accepted}}
+			if err := lobby.Send(ctx, reply); err != nil {
+				a.logger.Error("Error accepting run", "testRunId", m.Run.ID, "err", err)
+			}
+		}
+		for id, accepted := range runs {
+			if time.Since(accepted) > 24*time.Hour {
+				delete(runs, id)
+			}
+		}
+	}
+	return nil
+}
+
+// serveRun answers the tests of a run until the offering agent ends it, or
+// it has been idle for answererTestTimeout
+func (a *RemoteAnswerer) serveRun(ctx context.Context, id string, accepted []signaling.ICEServer) {
+	run := &answererRun{
+		config:       a.config,
+		peer:         a.join(id),
+		logger:       a.logger.With("testRunId", id),
+		accepted:     make(map[signaling.ICEServer]bool),
+		acceptedURLs: make(map[string]bool),
+		tests:        make(map[string]*answererTest),
+	}
+	for _, is := range accepted {
+		run.accepted[is] = true
+		run.acceptedURLs[signaling.NormalizeURL(is.URL)] = true
+	}
+	defer run.expire(time.Now().Add(time.Hour))
+
+	lastMessage := time.Now()
+	for ctx.Err() == nil && time.Since(lastMessage) < answererTestTimeout {
+		msgs, err := run.peer.Receive(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				run.logger.Error("Error polling the signaling server", "err", err)
+				sleep(ctx, time.Second)
+			}
+			continue
+		}
+		if len(msgs) > 0 {
+			lastMessage = time.Now()
+		}
+		for _, m := range msgs {
+			if m.Type == signaling.TypeEnd {
+				run.logger.Info("Run ended")
+				return
+			}
+			run.handle(m)
+		}
+		run.expire(time.Now().Add(-answererTestTimeout))
+	}
+}
+
+// acceptICEServers returns the ICE servers offered by the given providers,
+// or all of them if no providers are given
+func acceptICEServers(offered []signaling.ICEServer, providers []string) []signaling.ICEServer {
+	accepted := []signaling.ICEServer{}
+	for _, is := range offered {
+		if len(providers) == 0 || contains(providers, is.Provider) {
+			accepted = append(accepted, is)
+		}
+	}
+	return accepted
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *answererRun) handle(m signaling.Message) {
+	switch m.Type {
+	case signaling.TypeOffer:
+		if _, ok := a.tests[m.Test]; ok {
+			return
+		}
+		t, err := a.answer(m)
+		if err != nil {
+			a.logger.Error("Error answering offer", "test", m.Test, "err", err)
+			go newRemotePeer(a.peer, m.Test, a.logger).send(signaling.Message{Type: signaling.TypeError, Error: err.Error()})
+			return
+		}
+		a.tests[m.Test] = t
+	case signaling.TypeCandidate:
+		t, ok := a.tests[m.Test]
+		if !ok || m.Candidate == nil {
+			return
+		}
+		if err := t.cp.AnswerPC.AddICECandidate(*m.Candidate); err != nil {
+			t.cp.LogAnswerer.Error("Error adding the remote agent's candidate", "err", err)
+		}
+	case signaling.TypeBye:
+		t, ok := a.tests[m.Test]
+		if !ok {
+			return
+		}
+		delete(a.tests, m.Test)
+		go t.finish()
+	}
+}
+
+// answer sets up the answering peer for an offer and sends the answer back
+func (a *answererRun) answer(m signaling.Message) (*answererTest, error) {
+	if m.SDP == nil || m.Setup == nil {
+		return nil, errors.New("offer without a description or setup")
+	}
+	if !a.accepted[signaling.ICEServer{Provider: m.Setup.Provider, URL: m.Setup.URL}] {
+		return nil, errors.New("offer for an ICE server that wasn't accepted")
+	}
+	// the answerer's own ICE servers must have been accepted too, or an
+	// offer could point it at any server
+	for _, is := range m.Setup.ICEServers {
+		for _, u := range is.URLs {
+			if !a.acceptedURLs[signaling.NormalizeURL(u)] {
+				return nil, errors.New("offer with answerer ICE servers that weren't accepted")
+			}
+		}
+	}
+	iceServerInfo, err := stun.ParseURI(m.Setup.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := a.logger.With("test", m.Test, "provider", m.Setup.Provider, "url", m.Setup.URL)
+	s := stats.NewStats(m.Test, time.Now())
+	s.SetNode(a.config.NodeID)
+	remote := newRemotePeer(a.peer, m.Test, logger)
+	cp := &ConnectionPair{
+		config:           a.config,
+		LogOfferer:       logger,
+		LogAnswerer:      logger,
+		iceServerInfo:    iceServerInfo,
+		provider:         m.Setup.Provider,
+		stats:            s,
+		doThroughputTest: m.Setup.Throughput,
+		remote:           remote,
+	}
+
+	conf := webrtc.Configuration{
+		ICEServers:   m.Setup.ICEServers,
+		SDPSemantics: webrtc.SDPSemanticsUnifiedPlanWithFallback,
+	}
+	if m.Setup.Relay {
+		conf.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+	}
+	cp.createAnswerer(conf)
+
+	started := time.Now()
+	cp.AnswerPC.OnICECandidate(func(i *webrtc.ICECandidate) {
+		if i != nil && answererSends(i, iceServerInfo) {
+			s.SetAnswererTimeToReceiveCandidate(float64(time.Since(started).Milliseconds()))
+			logger.Info("Answerer received candidate, sent over to the remote agent",
+				"timeSinceStartMs", time.Since(started).Milliseconds(),
+				"candidateType", i.Typ)
+			remote.sendCandidate(i.ToJSON())
+		}
+	})
+	cp.AnswerPC.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		logger.Info("Peer Connection State has changed", "peerConnState", state.String(),
+			"timeSinceStartMs", time.Since(started).Milliseconds())
+	})
+
+	if err := cp.AnswerPC.SetRemoteDescription(*m.SDP); err != nil {
+		cp.AnswerPC.Close()
+		return nil, err
+	}
+	answer, err := cp.AnswerPC.CreateAnswer(nil)
+	if err != nil {
+		cp.AnswerPC.Close()
+		return nil, err
+	}
+	if err := cp.AnswerPC.SetLocalDescription(answer); err != nil {
+		cp.AnswerPC.Close()
+		return nil, err
+	}
+	go remote.sendDescription(signaling.Message{Type: signaling.TypeAnswer, SDP: &answer})
+
+	return &answererTest{cp: cp, started: started}, nil
+}
+
+// finish closes the answering peer and sends what it measured back to the
+// offering agent
+func (t *answererTest) finish() {
+	if err := t.cp.AnswerPC.Close(); err != nil {
+		t.cp.LogAnswerer.Error("cannot close AnswerPC", "err", err)
+	}
+	// let the throughput ticker see the closed connection and stop
+	time.Sleep(200 * time.Millisecond)
+	t.cp.remote.send(signaling.Message{Type: signaling.TypeStats, Stats: t.cp.stats})
+}
+
+// expire closes the tests started before t that the offering agent never
+// finished
+func (a *answererRun) expire(t time.Time) {
+	for id, test := range a.tests {
+		if test.started.Before(t) {
+			delete(a.tests, id)
+			test.cp.AnswerPC.Close()
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/signaling"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestAcceptICEServers(t *testing.T) {
+	offered := []signaling.ICEServer{
+		{Provider: "metered", URL: "turn:a.example.com:3478?transport=udp"},
+		{Provider: "cloudflare", URL: "turn:b.example.com:3478?transport=udp"},
+	}
+	assert.Equal(t, offered, acceptICEServers(offered, nil))
+	assert.Equal(t, offered[1:], acceptICEServers(offered, []string{"cloudflare"}))
+	assert.Equal(t, []signaling.ICEServer{}, acceptICEServers(offered, []string{"twilio"}))
+}
+
+func TestRemoteAnswererAcceptsRuns(t *testing.T) {
+	srv := signaling.NewServer("", "s3cret", slog.Default())
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	cc := &config.Config{
+		NodeID: "singapore",
+		Logger: slog.Default(),
+		Distributed: config.DistributedConfig{
+			Role:         config.RoleAnswerer,
+			SignalingURL: ts.URL,
+			Token:        "s3cret",
+			Room:         "london-singapore",
+			Providers:    []string{"cloudflare"},
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewRemoteAnswerer(cc).Serve(ctx)
+
+	run := &signaling.Run{ID: "run1", ICEServers: []signaling.ICEServer{
+		{Provider: "metered", URL: "turn:a.example.com:3478?transport=udp"},
+		{Provider: "cloudflare", URL: "turn:b.example.com:3478?transport=udp"},
+	}}
+	lobby := signaling.NewPeer(ts.URL, "s3cret", "london-singapore", signaling.RoleOfferer)
+
+	// the answerer skips runs announced before it joined, so announce
+	// again until it replies
+	var accepted *signaling.Run
+	for i := 0; i < 20 && accepted == nil; i++ {
+		assert.NoError(t, lobby.Send(ctx, signaling.Message{Type: signaling.TypeRun, Run: run}))
+		pollCtx, pollCancel := context.WithTimeout(ctx, 250*time.Millisecond)
+		msgs, _ := lobby.Receive(pollCtx)
+		pollCancel()
+		for _, m := range msgs {
+			if m.Type == signaling.TypeAccept {
+				accepted = m.Run
+			}
+		}
+	}
+	assert.NotZero(t, accepted)
+	assert.Equal(t, "run1", accepted.ID)
+	assert.Equal(t, run.ICEServers[1:], accepted.ICEServers)
+
+	// an offer for an ICE server that wasn't accepted is refused
+	peer := signaling.NewPeer(ts.URL, "s3cret", "run1", signaling.RoleOfferer)
+	assert.NoError(t, peer.Send(ctx, signaling.Message{Type: signaling.TypeOffer, Test: "t1",
+		SDP: &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "v=0"}, Setup: &signaling.Setup{Provider: "metered", URL: run.ICEServers[0].URL}}))
+	msgs, err := peer.Receive(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(msgs))
+	assert.Equal(t, signaling.TypeError, msgs[0].Type)
+	assert.Equal(t, "t1", msgs[0].Test)
+
+	// and so is one that would have the answerer use an ICE server that
+	// wasn't accepted
+	assert.NoError(t, peer.Send(ctx, signaling.Message{Type: signaling.TypeOffer, Test: "t2",
+		SDP: &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "v=0"}, Setup: &signaling.Setup{
+			Provider:   "cloudflare",
+			URL:        run.ICEServers[1].URL,
+			ICEServers: []webrtc.ICEServer{{URLs: []string{run.ICEServers[0].URL}}},
+		}}))
+	msgs, err = peer.Receive(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(msgs))
+	assert.Equal(t, signaling.TypeError, msgs[0].Type)
+	assert.Equal(t, "t2", msgs[0].Test)
+	assert.Equal(t, "offer with answerer ICE servers that weren't accepted", msgs[0].Error)
+}
@@ -1,19 +1,23 @@
 package client
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
-	"net/http"
 	"time"
 
 	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/signaling"
 	"github.com/nimbleape/iceperf-agent/stats"
+	"github.com/nimbleape/iceperf-agent/telemetry"
 	"github.com/nimbleape/iceperf-agent/util"
 	"github.com/pion/stun/v2"
 	"github.com/pion/webrtc/v4"
 	"github.com/rs/xid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	// "github.com/prometheus/client_golang/prometheus"
 	// log "github.com/sirupsen/logrus"
 )
@@ -39,16 +43,36 @@ type Client struct {
 	provider          string
 	Stats             *stats.Stats
 	config            *config.Config
+	span              trace.Span
+	phases            *phaseSpans
+	remote            *remotePeer
 }
 
-func NewClient(config *config.Config, iceServerInfo *stun.URI, provider string, testRunId xid.ID, testRunStartedAt time.Time, doThroughputTest bool, close chan struct{}) (c *Client, err error) {
-	return newClient(config, iceServerInfo, provider, testRunId, testRunStartedAt, doThroughputTest, close)
+func NewClient(ctx context.Context, config *config.Config, iceServerInfo *stun.URI, provider string, testRunId xid.ID, testRunStartedAt time.Time, doThroughputTest bool, close chan struct{}) (c *Client, err error) {
+	return newClient(ctx, config, iceServerInfo, provider, testRunId, testRunStartedAt, doThroughputTest, close, nil)
 }
 
-func newClient(cc *config.Config, iceServerInfo *stun.URI, provider string, testRunId xid.ID, testRunStartedAt time.Time, doThroughputTest bool, close chan struct{}) (*Client, error) {
+// NewRemoteClient is like NewClient, but the answerer runs in another agent
+// that is reached through peer, see RemoteAnswerer
+func NewRemoteClient(ctx context.Context, config *config.Config, iceServerInfo *stun.URI, provider string, testRunId xid.ID, testRunStartedAt time.Time, doThroughputTest bool, close chan struct{}, peer *signaling.Peer) (c *Client, err error) {
+	remote := newRemotePeer(peer, xid.New().String(), config.Logger)
+	return newClient(ctx, config, iceServerInfo, provider, testRunId, testRunStartedAt, doThroughputTest, close, remote)
+}
+
+func newClient(ctx context.Context, cc *config.Config, iceServerInfo *stun.URI, provider string, testRunId xid.ID, testRunStartedAt time.Time, doThroughputTest bool, close chan struct{}, remote *remotePeer) (*Client, error) {
+
+	ctx, span := telemetry.Tracer().Start(ctx, "iceServerTest", trace.WithAttributes(
+		attribute.String("iceperf.provider", provider),
+		attribute.String("iceperf.scheme", iceServerInfo.Scheme.String()),
+		attribute.String("iceperf.protocol", iceServerInfo.Proto.String()),
+		attribute.String("iceperf.host", iceServerInfo.Host),
+		attribute.Int("iceperf.port", iceServerInfo.Port),
+	))
+	phases := newPhaseSpans(ctx)
 
 	// Start timers
 	startTime = time.Now()
+	phases.start("gather")
 
 	stats := stats.NewStats(testRunId.String(), testRunStartedAt)
 
@@ -56,9 +80,10 @@ func newClient(cc *config.Config, iceServerInfo *stun.URI, provider string, test
 	stats.SetScheme(iceServerInfo.Scheme.String())
 	stats.SetProtocol(iceServerInfo.Proto.String())
 	stats.SetPort(fmt.Sprintf("%d", iceServerInfo.Port))
+	stats.SetURL(iceServerInfo.String())
 	stats.SetNode(cc.NodeID)
 
-	connectionPair, err := newConnectionPair(cc, iceServerInfo, provider, stats, doThroughputTest, close)
+	connectionPair, err := newConnectionPair(cc, iceServerInfo, provider, stats, doThroughputTest, close, phases, remote)
 
 	if doThroughputTest {
 		bufferedAmountLowThreshold = 4 * 1024 * 1024 // 4 Mib
@@ -66,6 +91,7 @@ func newClient(cc *config.Config, iceServerInfo *stun.URI, provider string, test
 	}
 
 	if err != nil {
+		span.End()
 		return nil, err
 	}
 
@@ -78,9 +104,24 @@ func newClient(cc *config.Config, iceServerInfo *stun.URI, provider string, test
 		provider:          provider,
 		Stats:             stats,
 		config:            cc,
+		span:              span,
+		phases:            phases,
+		remote:            remote,
 	}
 
-	if cc.OnICECandidate != nil {
+	if remote != nil {
+		// the answerer is in another agent, candidates go through signaling
+		c.ConnectionPair.OfferPC.OnICECandidate(func(i *webrtc.ICECandidate) {
+			if i != nil && offererSends(i) {
+				stats.SetOffererTimeToReceiveCandidate(float64(time.Since(startTime).Milliseconds()))
+				phases.end("gather")
+				c.ConnectionPair.LogOfferer.Info("Offerer received candidate, sent over to the remote agent",
+					"timeSinceStartMs", time.Since(startTime).Milliseconds(),
+					"candidateType", i.Typ)
+				remote.sendCandidate(i.ToJSON())
+			}
+		})
+	} else if cc.OnICECandidate != nil {
 		c.ConnectionPair.AnswerPC.OnICECandidate(cc.OnICECandidate)
 		c.ConnectionPair.OfferPC.OnICECandidate(cc.OnICECandidate)
 	} else {
@@ -90,8 +131,10 @@ func newClient(cc *config.Config, iceServerInfo *stun.URI, provider string, test
 		//if the test is a STUN test, then allow host, srflx and relay candidates
 		c.ConnectionPair.AnswerPC.OnICECandidate(func(i *webrtc.ICECandidate) {
 			if i != nil {
-				if i.Typ == webrtc.ICECandidateTypeSrflx || i.Typ == webrtc.ICECandidateTypeRelay || (i.Typ == webrtc.ICECandidateTypeHost && (iceServerInfo.Scheme == stun.SchemeTypeSTUN || iceServerInfo.Scheme == stun.SchemeTypeSTUNS)) {
-					stats.SetAnswererTimeToReceiveCandidate(float64(time.Since(startTime).Milliseconds()))
+				if answererSends(i, iceServerInfo) {
+					if !c.ConnectionPair.restarted() {
+						stats.SetAnswererTimeToReceiveCandidate(float64(time.Since(startTime).Milliseconds()))
+					}
 					timeAnswererReceivedCandidate = time.Now()
 					c.ConnectionPair.LogAnswerer.Info("Answerer received candidate, sent over to other PC", "eventTime", timeAnswererReceivedCandidate,
 						"timeSinceStartMs", time.Since(startTime).Milliseconds(),
@@ -107,9 +150,12 @@ func newClient(cc *config.Config, iceServerInfo *stun.URI, provider string, test
 		// send it to the other peer
 		c.ConnectionPair.OfferPC.OnICECandidate(func(i *webrtc.ICECandidate) {
 			if i != nil {
-				if i.Typ == webrtc.ICECandidateTypeSrflx || i.Typ == webrtc.ICECandidateTypeRelay {
-					stats.SetOffererTimeToReceiveCandidate(float64(time.Since(startTime).Milliseconds()))
+				if offererSends(i) {
+					if !c.ConnectionPair.restarted() {
+						stats.SetOffererTimeToReceiveCandidate(float64(time.Since(startTime).Milliseconds()))
+					}
 					timeOffererReceivedCandidate = time.Now()
+					phases.end("gather")
 					c.ConnectionPair.LogOfferer.Info("Offerer received candidate, sent over to other PC", "eventTime", timeOffererReceivedCandidate,
 						"timeSinceStartMs", time.Since(startTime).Milliseconds(),
 						"candidateType", i.Typ,
@@ -123,7 +169,9 @@ func newClient(cc *config.Config, iceServerInfo *stun.URI, provider string, test
 
 	if cc.OnConnectionStateChange != nil {
 		c.ConnectionPair.OfferPC.OnConnectionStateChange(cc.OnConnectionStateChange)
-		c.ConnectionPair.AnswerPC.OnConnectionStateChange(cc.OnConnectionStateChange)
+		if remote == nil {
+			c.ConnectionPair.AnswerPC.OnConnectionStateChange(cc.OnConnectionStateChange)
+		}
 	} else {
 		// Set the handler for Peer connection state
 		// This will notify you when the peer has connected/disconnected
@@ -134,10 +182,19 @@ func newClient(cc *config.Config, iceServerInfo *stun.URI, provider string, test
 			switch s {
 			case webrtc.PeerConnectionStateConnecting:
 				timeOffererConnecting = time.Now()
+				phases.start("ice.connecting")
 				c.ConnectionPair.LogOfferer.Info("Offerer connecting", "eventTime", timeOffererConnecting,
 					"timeSinceStartMs", time.Since(startTime).Milliseconds())
 			case webrtc.PeerConnectionStateConnected:
+				if stats.Connected {
+					// back up after a disconnection or ICE restart in a soak test
+					c.ConnectionPair.LogOfferer.Info("Offerer reconnected", "eventTime", time.Now(), "timeSinceStartMs", time.Since(startTime).Milliseconds())
+					break
+				}
 				timeOffererConnected = time.Now()
+				phases.end("ice.connecting")
+				phases.start("connected")
+				phases.start("datachannel.open")
 				c.ConnectionPair.LogOfferer.Info("Offerer connected", "eventTime", timeOffererConnected, "timeSinceStartMs", time.Since(startTime).Milliseconds())
 				//go and get the details about the ice pair
 				//stats := c.ConnectionPair.OfferPC.GetStats()
@@ -165,6 +222,10 @@ func newClient(cc *config.Config, iceServerInfo *stun.URI, provider string, test
 				// Use webrtc.PeerConnectionStateDisconnected if you are interested in detecting faster timeout.
 				// Note that the PeerConnection may come back from PeerConnectionStateDisconnected.
 				c.ConnectionPair.LogOfferer.Error("Offerer connection failed", "eventTime", time.Now(), "timeSinceStartMs", time.Since(startTime).Milliseconds())
+				if c.ConnectionPair.soak != nil {
+					// the soak test restarts ICE instead of ending
+					break
+				}
 				close <- struct{}{}
 				c.OffererConnected <- false
 			case webrtc.PeerConnectionStateClosed:
@@ -174,6 +235,10 @@ func newClient(cc *config.Config, iceServerInfo *stun.URI, provider string, test
 			}
 		})
 
+		if remote != nil {
+			return c, nil
+		}
+
 		// Set the handler for Peer connection state
 		// This will notify you when the peer has connected/disconnected
 		c.ConnectionPair.AnswerPC.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
@@ -209,6 +274,11 @@ func (c *Client) Run() {
 }
 
 func (c *Client) run() {
+	if c.remote != nil {
+		c.runRemote()
+		return
+	}
+
 	offer, err := c.ConnectionPair.OfferPC.CreateOffer(nil)
 	util.Check(err)
 	util.Check(c.ConnectionPair.OfferPC.SetLocalDescription(offer))
@@ -233,6 +303,13 @@ func (c *Client) run() {
 
 func (c *Client) Stop() error {
 	c.Logger.Info("Stopping client...")
+	c.phases.end("connected")
+	c.phases.start("stop")
+	defer c.phases.end("stop")
+
+	if c.ConnectionPair.soak != nil {
+		c.ConnectionPair.soak.finish()
+	}
 
 	if c.ConnectionPair.OfferDC != nil {
 		c.ConnectionPair.OfferDC.Close()
@@ -240,57 +317,45 @@ func (c *Client) Stop() error {
 
 	time.Sleep(1 * time.Second)
 
+	if c.remote != nil {
+		c.finishRemote()
+	}
+
 	if err := c.ConnectionPair.OfferPC.Close(); err != nil {
 		c.Logger.Error("cannot close c.ConnectionPair.OfferPC", "error", err)
 		return err
 	}
 
+	if c.ConnectionPair.AnswerPC == nil {
+		return nil
+	}
 	if err := c.ConnectionPair.AnswerPC.Close(); err != nil {
 		c.Logger.Error("cannot close c.ConnectionPair.AnswerPC", "error", err)
 		return err
 	}
 
-	if c.config.Logging.API.Enabled {
-		// Convert data to JSON
-		c.Stats.CreateLabels()
-		jsonData, err := json.Marshal(c.Stats)
-		if err != nil {
-			fmt.Println("Error marshalling JSON:", err)
-			return err
-		}
-
-		// Define the API endpoint
-		apiEndpoint := c.config.Logging.API.URI
-
-		// Create a new HTTP request
-		req, err := http.NewRequest("POST", apiEndpoint, bytes.NewBuffer(jsonData))
-		if err != nil {
-			fmt.Println("Error creating request:", err)
-			return err
-		}
-
-		// Set the appropriate headers
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Add("Authorization", "Bearer "+c.config.Logging.API.ApiKey)
-
-		// Send the request using the HTTP client
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Println("Error sending request:", err)
-			return err
-		}
-		defer resp.Body.Close()
+	return nil
+}
 
-		// Check the response
-		if resp.StatusCode == http.StatusCreated {
-			fmt.Println("Data sent successfully!")
-		} else {
-			fmt.Printf("Failed to send data. Status code: %d\n", resp.StatusCode)
-		}
+// EndTrace annotates the test span with the final stats and ends it. It is
+// called once the data channel close handlers have filled in the byte totals.
+func (c *Client) EndTrace() {
+	c.phases.endAll()
+	c.span.SetAttributes(telemetry.StatsAttributes(c.Stats)...)
+	if !c.Stats.Connected {
+		c.span.SetStatus(codes.Error, "not connected")
 	}
-	j, _ := c.Stats.ToJSON()
-	c.Logger.Info(j, "individual_test_completed", "true")
+	c.span.End()
+}
 
-	return nil
+// offererSends reports whether the offerer passes a candidate to the answerer.
+// Only server reflexive and relay candidates are, to test the ICE server.
+func offererSends(i *webrtc.ICECandidate) bool {
+	return i.Typ == webrtc.ICECandidateTypeSrflx || i.Typ == webrtc.ICECandidateTypeRelay
+}
+
+// answererSends reports whether the answerer passes a candidate to the
+// offerer. Host candidates are passed too when testing a STUN server.
+func answererSends(i *webrtc.ICECandidate, iceServerInfo *stun.URI) bool {
+	return i.Typ == webrtc.ICECandidateTypeSrflx || i.Typ == webrtc.ICECandidateTypeRelay || (i.Typ == webrtc.ICECandidateTypeHost && (iceServerInfo.Scheme == stun.SchemeTypeSTUN || iceServerInfo.Scheme == stun.SchemeTypeSTUNS))
 }
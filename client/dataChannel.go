@@ -1,11 +1,14 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"log/slog"
+	"sync/atomic"
 	"time"
 
 	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/signaling"
 	"github.com/nimbleape/iceperf-agent/stats"
 	"github.com/nimbleape/iceperf-agent/util"
 	"github.com/pion/stun/v2"
@@ -27,19 +30,25 @@ type ConnectionPair struct {
 	LogOfferer              *slog.Logger
 	LogAnswerer             *slog.Logger
 	config                  *config.Config
-	sentInitialMessageViaDC time.Time
+	sentInitialMessageViaDC atomic.Int64
 	iceServerInfo           *stun.URI
 	provider                string
 	stats                   *stats.Stats
 	doThroughputTest        bool
 	closeChan               chan struct{}
+	phases                  *phaseSpans
+	// remote is the other agent in a distributed test, which runs the
+	// peer missing from this pair
+	remote *remotePeer
+	// soak keeps the pair up for a soak test, nil otherwise
+	soak *soakTest
 }
 
-func NewConnectionPair(config *config.Config, iceServerInfo *stun.URI, provider string, stats *stats.Stats, doThroughputTest bool, closeChan chan struct{}) (c *ConnectionPair, err error) {
-	return newConnectionPair(config, iceServerInfo, provider, stats, doThroughputTest, closeChan)
+func NewConnectionPair(ctx context.Context, config *config.Config, iceServerInfo *stun.URI, provider string, stats *stats.Stats, doThroughputTest bool, closeChan chan struct{}) (c *ConnectionPair, err error) {
+	return newConnectionPair(config, iceServerInfo, provider, stats, doThroughputTest, closeChan, newPhaseSpans(ctx), nil)
 }
 
-func newConnectionPair(cc *config.Config, iceServerInfo *stun.URI, provider string, stats *stats.Stats, doThroughputTest bool, closeChan chan struct{}) (*ConnectionPair, error) {
+func newConnectionPair(cc *config.Config, iceServerInfo *stun.URI, provider string, stats *stats.Stats, doThroughputTest bool, closeChan chan struct{}, phases *phaseSpans, remote *remotePeer) (*ConnectionPair, error) {
 	logOfferer := cc.Logger.With("peer", "Offerer")
 	logAnswerer := cc.Logger.With("peer", "Answerer")
 
@@ -52,6 +61,11 @@ func newConnectionPair(cc *config.Config, iceServerInfo *stun.URI, provider stri
 		stats:            stats,
 		doThroughputTest: doThroughputTest,
 		closeChan:        closeChan,
+		phases:           phases,
+		remote:           remote,
+	}
+	if cc.SoakTest && remote == nil {
+		cp.soak = newSoakTest(cp, cc.Soak)
 	}
 
 	config := webrtc.Configuration{}
@@ -65,21 +79,68 @@ func newConnectionPair(cc *config.Config, iceServerInfo *stun.URI, provider stri
 
 	//we only want offerer to force turn (if we are)
 	cp.createOfferer(config)
+	if cp.soak != nil {
+		cp.OfferPC.OnICEConnectionStateChange(cp.soak.onICEState)
+	}
 
-	// think we want to leave the answerer without any ice servers so we only get the host candidates.... I think
-	// to get the tests working I'm passing the turn server into both....
-	// but I don't think that should be required
-	cp.createAnswerer(webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs: []string{"stun:stun.l.google.com:19302"},
-			},
-		},
-	})
+	answerer := answererConfiguration(cc.AnswererFor(provider), config.ICEServers)
+	if cc.AnswererOverride != nil {
+		answerer = *cc.AnswererOverride
+	}
+	if remote != nil {
+		// the answering agent builds its peer from the setup sent with the offer
+		remote.setup = &signaling.Setup{
+			Provider:   provider,
+			URL:        iceServerInfo.String(),
+			ICEServers: answerer.ICEServers,
+			Relay:      answerer.ICETransportPolicy == webrtc.ICETransportPolicyRelay,
+			Throughput: doThroughputTest,
+		}
+	} else {
+		cp.createAnswerer(answerer)
+	}
 
 	return cp, nil
 }
 
+// answererConfiguration builds the answerer's configuration: the ICE servers
+// under test, none for host candidates only, or the ones from the config
+func answererConfiguration(a config.AnswererConfig, tested []webrtc.ICEServer) webrtc.Configuration {
+	c := webrtc.Configuration{
+		SDPSemantics: webrtc.SDPSemanticsUnifiedPlanWithFallback,
+	}
+	switch a.Mode {
+	case config.AnswererNone:
+	case config.AnswererCustom:
+		for _, is := range a.ICEServers {
+			c.ICEServers = append(c.ICEServers, webrtc.ICEServer{
+				URLs:           is.URLs,
+				Username:       is.Username,
+				Credential:     is.Credential,
+				CredentialType: webrtc.ICECredentialTypePassword,
+			})
+		}
+	default:
+		c.ICEServers = tested
+	}
+	if a.TransportPolicy == "relay" {
+		c.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+	}
+	return c
+}
+
+// markInitialMessageSent records when the offerer sent its first packet,
+// unless it already has. The answerer reads it from another goroutine, so it
+// is kept in Unix nanoseconds in an atomic.
+func (cp *ConnectionPair) markInitialMessageSent() {
+	cp.sentInitialMessageViaDC.CompareAndSwap(0, time.Now().UnixNano())
+}
+
+// sinceInitialMessage is how long ago the offerer sent its first packet
+func (cp *ConnectionPair) sinceInitialMessage() time.Duration {
+	return time.Since(time.Unix(0, cp.sentInitialMessageViaDC.Load()))
+}
+
 func (cp *ConnectionPair) setRemoteDescription(pc *webrtc.PeerConnection, sdp []byte) {
 	var desc webrtc.SessionDescription
 	err := json.Unmarshal(sdp, &desc)
@@ -94,6 +155,12 @@ func (cp *ConnectionPair) createOfferer(config webrtc.Configuration) {
 	// Create a new PeerConnection
 	settingEngine := webrtc.SettingEngine{}
 	settingEngine.SetICETimeouts(5*time.Second, 10*time.Second, 2*time.Second)
+	if types := networkTypes(cp.config.IPFamily); types != nil {
+		settingEngine.SetNetworkTypes(types)
+	}
+	if cp.soak != nil {
+		settingEngine.LoggerFactory = cp.soak.loggerFactory("offerer")
+	}
 	api := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
 
 	pc, err := api.NewPeerConnection(config)
@@ -129,6 +196,13 @@ func (cp *ConnectionPair) createOfferer(config webrtc.Configuration) {
 
 		// Register channel opening handling
 		dc.OnOpen(func() {
+			cp.phases.end("datachannel.open")
+			if cp.soak != nil {
+				cp.phases.start("soak")
+				cp.soak.run(pc, dc)
+				return
+			}
+			cp.phases.start("throughput")
 
 			stats := pc.GetStats()
 			iceTransportStats := stats["iceTransport"].(webrtc.TransportStats)
@@ -142,7 +216,7 @@ func (cp *ConnectionPair) createOfferer(config webrtc.Configuration) {
 
 			for {
 				if !hasSentData {
-					cp.sentInitialMessageViaDC = time.Now()
+					cp.markInitialMessageSent()
 					hasSentData = true
 				}
 				err2 := dc.Send(buf)
@@ -175,7 +249,36 @@ func (cp *ConnectionPair) createOfferer(config webrtc.Configuration) {
 			}
 		})
 
+		if cp.soak != nil {
+			dc.OnMessage(func(dcMsg webrtc.DataChannelMessage) {
+				cp.soak.onEcho(dcMsg.Data)
+			})
+		}
+
+		if cp.remote != nil {
+			// the answering agent's clock can't be compared with ours, so
+			// it echoes the first packet and half the round trip is used
+			hasReceivedEcho := false
+			dc.OnMessage(func(dcMsg webrtc.DataChannelMessage) {
+				if hasReceivedEcho {
+					return
+				}
+				hasReceivedEcho = true
+				latency := float64(cp.sinceInitialMessage().Microseconds()) / 2000
+				cp.stats.SetLatencyFirstPacket(latency)
+				cp.LogOfferer.Info("Received echo of first Packet", "latencyFirstPacketInMs", latency)
+				if !cp.doThroughputTest {
+					select {
+					case cp.closeChan <- struct{}{}:
+					default:
+					}
+				}
+			})
+		}
+
 		dc.OnClose(func() {
+			cp.phases.end("throughput")
+			cp.phases.end("soak")
 
 			dcBytesSentTotal, _, iceTransportSentBytesTotal, iceTransportReceivedBytesTotal, _ := getBytesStats(pc, dc)
 
@@ -196,7 +299,11 @@ func (cp *ConnectionPair) createAnswerer(config webrtc.Configuration) {
 	// settingEngine.SetICETimeouts(5, 5, 2)
 	// api := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
 	// Create a new PeerConnection
-	pc, err := webrtc.NewPeerConnection(config)
+	settingEngine := webrtc.SettingEngine{}
+	if cp.soak != nil {
+		settingEngine.LoggerFactory = cp.soak.loggerFactory("answerer")
+	}
+	pc, err := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine)).NewPeerConnection(config)
 	util.Check(err)
 
 	if cp.iceServerInfo.Scheme == stun.SchemeTypeTURN || cp.iceServerInfo.Scheme == stun.SchemeTypeTURNS {
@@ -218,6 +325,10 @@ func (cp *ConnectionPair) createAnswerer(config webrtc.Configuration) {
 
 				cp.LogAnswerer.Info("OnOpen: Start receiving data", "dataChannelLabel", dc.Label(),
 					"dataChannelId", dc.ID())
+				if cp.soak != nil {
+					// the offerer samples the soak test
+					return
+				}
 
 				since := time.Now()
 
@@ -261,12 +372,27 @@ func (cp *ConnectionPair) createAnswerer(config webrtc.Configuration) {
 			// Register the OnMessage to handle incoming messages
 			dc.OnMessage(func(dcMsg webrtc.DataChannelMessage) {
 
+				if !hasReceivedData && cp.remote != nil {
+					if err := dc.Send([]byte{0}); err != nil {
+						cp.LogAnswerer.Error("Error echoing first Packet", "err", err)
+					}
+					hasReceivedData = true
+					return
+				}
 				if !hasReceivedData {
-					cp.stats.SetLatencyFirstPacket(float64(time.Since(cp.sentInitialMessageViaDC).Milliseconds()))
-					cp.LogAnswerer.Info("Received first Packet", "latencyFirstPacketInMs", time.Since(cp.sentInitialMessageViaDC).Milliseconds())
+					latency := cp.sinceInitialMessage()
+					cp.stats.SetLatencyFirstPacket(float64(latency.Milliseconds()))
+					cp.LogAnswerer.Info("Received first Packet", "latencyFirstPacketInMs", latency.Milliseconds())
 					hasReceivedData = true
 				}
-				if !cp.doThroughputTest {
+				if cp.soak != nil {
+					// the offerer times the echoes of its keepalives
+					if err := dc.Send(dcMsg.Data); err != nil {
+						cp.LogAnswerer.Debug("Error echoing keepalive", "err", err)
+					}
+					return
+				}
+				if !cp.doThroughputTest && cp.remote == nil {
 					cp.LogAnswerer.Info("Sending to close")
 					cp.closeChan <- struct{}{}
 				}
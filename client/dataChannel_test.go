@@ -0,0 +1,29 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestAnswererConfiguration(t *testing.T) {
+	tested := []webrtc.ICEServer{{URLs: []string{"turn:turn.example.com:3478?transport=udp"}}}
+
+	c := answererConfiguration(config.AnswererConfig{}, tested)
+	assert.Equal(t, tested, c.ICEServers)
+	assert.Equal(t, webrtc.ICETransportPolicy(0), c.ICETransportPolicy)
+
+	c = answererConfiguration(config.AnswererConfig{Mode: config.AnswererNone}, tested)
+	assert.Equal(t, 0, len(c.ICEServers))
+
+	c = answererConfiguration(config.AnswererConfig{
+		Mode:            config.AnswererCustom,
+		ICEServers:      []config.ICEServerConfig{{URLs: []string{"turn:other.example.com:3478"}, Username: "u", Credential: "p"}},
+		TransportPolicy: "relay",
+	}, tested)
+	assert.Equal(t, "turn:other.example.com:3478", c.ICEServers[0].URLs[0])
+	assert.Equal(t, "p", c.ICEServers[0].Credential.(string))
+	assert.Equal(t, webrtc.ICETransportPolicyRelay, c.ICETransportPolicy)
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// lookupSRV is replaced in tests
+var lookupSRV = net.LookupSRV
+
+// hostList returns the single host followed by the list of hosts, skipping
+// empty and repeated ones
+func hostList(host string, hosts []string) []string {
+	var list []string
+	seen := map[string]bool{}
+	for _, h := range append([]string{host}, hosts...) {
+		if h == "" || seen[h] {
+			continue
+		}
+		seen[h] = true
+		list = append(list, h)
+	}
+	return list
+}
+
+// isSRVName reports whether host is an SRV record name like
+// _turn._udp.example.com rather than a host
+func isSRVName(host string) bool {
+	return strings.HasPrefix(host, "_")
+}
+
+func stunURL(host string, port int, proto string, rfc7094 bool) string {
+	query := ""
+	if !rfc7094 {
+		query = fmt.Sprintf("?transport=%s", proto)
+	}
+	stunProto := "stun"
+	if proto == "tls" {
+		stunProto = "stuns"
+	}
+	return fmt.Sprintf("%s:%s:%d%s", stunProto, host, port, query)
+}
+
+func turnURL(host string, port int, proto string) string {
+	turnProto := "turn"
+	l4proto := proto
+	if proto == "tls" {
+		turnProto = "turns"
+		l4proto = "tcp"
+	}
+	if proto == "dtls" {
+		turnProto = "turns"
+		l4proto = "udp"
+	}
+	return fmt.Sprintf("%s:%s:%d?transport=%s", turnProto, host, port, l4proto)
+}
+
+// srvURLs resolves an SRV record name such as _turn._udp.example.com or
+// _turns._tcp.example.com into one ICE server URL per target. The service
+// and protocol labels give the scheme and transport, the records give the
+// hosts and ports.
+func srvURLs(name string, rfc7094 bool) ([]string, error) {
+	labels := strings.SplitN(name, ".", 3)
+	if len(labels) < 3 {
+		return nil, fmt.Errorf("invalid SRV name %s, expected e.g. _turn._udp.example.com", name)
+	}
+	service, proto := labels[0], labels[1]
+
+	transport := ""
+	switch service + "." + proto {
+	case "_stun._udp", "_turn._udp":
+		transport = "udp"
+	case "_stun._tcp", "_turn._tcp":
+		transport = "tcp"
+	case "_stuns._tcp", "_turns._tcp":
+		transport = "tls"
+	case "_turns._udp":
+		transport = "dtls"
+	default:
+		return nil, fmt.Errorf("unsupported SRV name %s, use _stun, _stuns, _turn or _turns with _udp or _tcp", name)
+	}
+
+	_, records, err := lookupSRV("", "", name)
+	if err != nil {
+		return nil, fmt.Errorf("looking up SRV records for %s: %w", name, err)
+	}
+
+	var urls []string
+	for _, r := range records {
+		host := strings.TrimSuffix(r.Target, ".")
+		if strings.HasPrefix(service, "_stun") {
+			urls = append(urls, stunURL(host, int(r.Port), transport, rfc7094))
+		} else {
+			urls = append(urls, turnURL(host, int(r.Port), transport))
+		}
+	}
+	return urls, nil
+}
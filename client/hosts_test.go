@@ -0,0 +1,50 @@
+package client
+
+import (
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/config"
+)
+
+func TestGenericIceServersFromHostLists(t *testing.T) {
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		assert.Equal(t, "_turns._tcp.fleet.example.com", name)
+		return "", []*net.SRV{
+			{Target: "turn-a.fleet.example.com.", Port: 443},
+			{Target: "turn-b.fleet.example.com.", Port: 5349},
+		}, nil
+	}
+	defer func() { lookupSRV = net.LookupSRV }()
+
+	is, err := formGenericIceServers(&config.ICEConfig{
+		StunEnabled: true,
+		StunHost:    "stun.example.com",
+		StunHosts:   []string{"stun.example.com", "stun2.example.com"},
+		StunPorts:   map[string][]int{"udp": {3478}},
+		TurnEnabled: true,
+		TurnHosts:   []string{"turn1.example.com", "_turns._tcp.fleet.example.com"},
+		TurnPorts:   map[string][]int{"udp": {3478}},
+	})
+	assert.NoError(t, err)
+
+	var urls []string
+	for _, s := range is.IceServers {
+		urls = append(urls, s.URLs[0])
+	}
+	sort.Strings(urls)
+	assert.Equal(t, []string{
+		"stun:stun.example.com:3478?transport=udp",
+		"stun:stun2.example.com:3478?transport=udp",
+		"turn:turn1.example.com:3478?transport=udp",
+		"turns:turn-a.fleet.example.com:443?transport=tcp",
+		"turns:turn-b.fleet.example.com:5349?transport=tcp",
+	}, urls)
+}
+
+func TestSRVURLsRejectsUnknownService(t *testing.T) {
+	_, err := srvURLs("_sip._udp.example.com", false)
+	assert.Error(t, err)
+}
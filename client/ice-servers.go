@@ -1,8 +1,9 @@
 package client
 
 import (
-	"fmt"
+	"context"
 	"log/slog"
+	"time"
 
 	"github.com/nimbleape/iceperf-agent/adapters"
 	"github.com/nimbleape/iceperf-agent/adapters/api"
@@ -15,63 +16,69 @@ import (
 	"github.com/nimbleape/iceperf-agent/adapters/twilio"
 	"github.com/nimbleape/iceperf-agent/adapters/xirsys"
 	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/telemetry"
 	"github.com/pion/webrtc/v4"
 	"github.com/rs/xid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	// log "github.com/sirupsen/logrus"
 )
 
 func formGenericIceServers(config *config.ICEConfig) (adapters.IceServersConfig, error) {
 	iceServers := []webrtc.ICEServer{}
+
+	username, password := config.Username, config.Password
+	if config.SharedSecret != "" {
+		username, password = turnRESTCredentials(config.SharedSecret, config.UsernameSuffix,
+			time.Duration(config.TTL)*time.Second, time.Now())
+	}
+	newServer := func(url string) webrtc.ICEServer {
+		return webrtc.ICEServer{
+			URLs:           []string{url},
+			Username:       username,
+			Credential:     password,
+			CredentialType: webrtc.ICECredentialTypePassword,
+		}
+	}
+
 	if config.StunEnabled {
-		for proto, ports := range config.StunPorts {
-			query := ""
-			if !config.StunUseRFC7094URI {
-				query = fmt.Sprintf("?transport=%s", proto)
+		for _, host := range hostList(config.StunHost, config.StunHosts) {
+			if isSRVName(host) {
+				urls, err := srvURLs(host, config.StunUseRFC7094URI)
+				if err != nil {
+					return adapters.IceServersConfig{}, err
+				}
+				for _, url := range urls {
+					iceServers = append(iceServers, newServer(url))
+				}
+				continue
 			}
-			for _, port := range ports {
-				stunProto := "stun"
-				if proto == "tls" {
-					stunProto = "stuns"
+			for proto, ports := range config.StunPorts {
+				for _, port := range ports {
+					iceServers = append(iceServers, newServer(stunURL(host, port, proto, config.StunUseRFC7094URI)))
 				}
-				url := fmt.Sprintf("%s:%s:%d%s", stunProto, config.StunHost, port, query)
-
-				iceServers = append(iceServers,
-					webrtc.ICEServer{
-						URLs:           []string{url},
-						Username:       config.Username,
-						Credential:     config.Password,
-						CredentialType: webrtc.ICECredentialTypePassword,
-					})
-
 			}
 		}
 	}
 	if config.TurnEnabled {
-		for proto, ports := range config.TurnPorts {
-			for _, port := range ports {
-				turnProto := "turn"
-				l4proto := proto
-				if proto == "tls" {
-					turnProto = "turns"
-					l4proto = "tcp"
+		for _, host := range hostList(config.TurnHost, config.TurnHosts) {
+			if isSRVName(host) {
+				urls, err := srvURLs(host, false)
+				if err != nil {
+					return adapters.IceServersConfig{}, err
 				}
-				if proto == "dtls" {
-					turnProto = "turns"
-					l4proto = "udp"
+				for _, url := range urls {
+					iceServers = append(iceServers, newServer(url))
+				}
+				continue
+			}
+			for proto, ports := range config.TurnPorts {
+				for _, port := range ports {
+					iceServers = append(iceServers, newServer(turnURL(host, port, proto)))
 				}
-				url := fmt.Sprintf("%s:%s:%d?transport=%s",
-					turnProto, config.TurnHost, port, l4proto)
-
-				iceServers = append(iceServers,
-					webrtc.ICEServer{
-						URLs:           []string{url},
-						Username:       config.Username,
-						Credential:     config.Password,
-						CredentialType: webrtc.ICECredentialTypePassword,
-					})
 			}
 		}
-
 	}
 	c := adapters.IceServersConfig{
 		IceServers:   iceServers,
@@ -86,15 +93,29 @@ type IceServersConfig struct {
 	DoThroughput bool
 }
 
-func GetIceServers(config *config.Config, logger *slog.Logger, testRunId xid.ID) (map[string]adapters.IceServersConfig, string, error) {
+func GetIceServers(ctx context.Context, config *config.Config, logger *slog.Logger, testRunId xid.ID) (map[string]adapters.IceServersConfig, string, error) {
 
 	//check if the API is set and is enabled
 	if apiConfig, ok := config.ICEConfig["api"]; ok && apiConfig.Enabled {
+		_, span := telemetry.Tracer().Start(ctx, "getIceServers", trace.WithAttributes(attribute.String("iceperf.provider", "api")))
+		defer span.End()
 		md := api.Driver{
 			Config: &apiConfig,
 			Logger: logger,
 		}
 		iceServers, node, err := md.GetIceServers(testRunId)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		// the API doesn't know about ip_families, so take them from the
+		// config of the provider with the same name if there is one
+		for key, is := range iceServers {
+			if conf, ok := config.ICEConfig[key]; ok {
+				is.IPFamilies = conf.IPFamilies
+				iceServers[key] = is
+			}
+		}
 		return iceServers, node, err
 	}
 
@@ -102,147 +123,159 @@ func GetIceServers(config *config.Config, logger *slog.Logger, testRunId xid.ID)
 
 	//loop through
 	for key, conf := range config.ICEConfig {
-		switch key {
-		case "api":
+		if key == "api" {
 			continue
-		case "elixir":
-			if !conf.Enabled {
-				continue
-			}
-			md := elixir.Driver{
-				Config: &conf,
-				Logger: logger,
-			}
-			is, err := md.GetIceServers()
-			if err != nil {
-				logger.Error("Error getting elixir ice servers")
-				return nil, "", err
-			}
-			logger.Info("elixir IceServers", "is", is)
-			iceServers[key] = is
-		case "google":
-			if !conf.Enabled {
-				continue
-			}
-			md := google.Driver{
-				Config: &conf,
-				Logger: logger,
-			}
-			is, err := md.GetIceServers()
-			if err != nil {
-				logger.Error("Error getting google ice servers")
-				return nil, "", err
-			}
-			logger.Info("google IceServers", "is", is)
-
-			iceServers[key] = is
-		case "metered":
-			if !conf.Enabled {
-				continue
-			}
-			md := metered.Driver{
-				Config: &conf,
-				Logger: logger,
-			}
-			is, err := md.GetIceServers()
-			if err != nil {
-				logger.Error("Error getting metered ice servers")
-				return nil, "", err
-			}
-			logger.Info("metered IceServers", "is", is)
-
-			iceServers[key] = is
-		case "stunner":
-			if !conf.Enabled {
-				continue
-			}
-			md := stunner.Driver{
-				Config: &conf,
-				Logger: logger,
-			}
-			is, err := md.GetIceServers()
-			if err != nil {
-				logger.Error("Error getting elixir ice servers")
-				return nil, "", err
-			}
-			logger.Info("STUNner IceServers", "is", is)
-			iceServers[key] = is
-		case "twilio":
-			if !conf.Enabled {
-				continue
-			}
-			td := twilio.Driver{
-				Config: &conf,
-				Logger: logger,
-			}
-			is, err := td.GetIceServers()
-			if err != nil {
-				logger.Error("Error getting twilio ice servers")
-				return nil, "", err
-			}
-			logger.Info("twilio IceServers", "is", is)
-
-			iceServers[key] = is
-		case "xirsys":
-			if !conf.Enabled {
-				continue
-			}
-			xd := xirsys.Driver{
-				Config: &conf,
-				Logger: logger,
-			}
-			is, err := xd.GetIceServers()
-			if err != nil {
-				logger.Error("Error getting xirsys ice servers")
-				return nil, "", err
-			}
-			logger.Info("xirsys IceServers", "is", is)
-
-			iceServers[key] = is
-		case "cloudflare":
-			if !conf.Enabled {
-				continue
-			}
-			cd := cloudflare.Driver{
-				Config: &conf,
-				Logger: logger,
-			}
-			is, err := cd.GetIceServers()
-			if err != nil {
-				logger.Error("Error getting cloudflare ice servers")
-				return nil, "", err
-			}
-			logger.Info("cloudflare IceServers", "is", is)
-
-			iceServers[key] = is
-		case "expressturn":
-			if !conf.Enabled {
-				continue
-			}
-			ed := expressturn.Driver{
-				Config: &conf,
-				Logger: logger,
-			}
-			is, err := ed.GetIceServers()
-			if err != nil {
-				logger.Error("Error getting expressturn ice servers")
-
-				return nil, "", err
-			}
-			logger.Info("expressturn IceServers", "is", is)
-
-			iceServers[key] = is
-		default:
-			is, err := formGenericIceServers(&conf)
-			if err != nil {
-				logger.Error("Error getting generic ice servers")
-				return nil, "", err
-			}
-			logger.Info("default IceServers", "key", key, "is", is)
-
+		}
+		_, span := telemetry.Tracer().Start(ctx, "getIceServers", trace.WithAttributes(attribute.String("iceperf.provider", key)))
+		is, enabled, err := getProviderIceServers(key, conf, logger)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			span.End()
+			return nil, "", err
+		}
+		span.SetAttributes(attribute.Int("iceperf.ice_servers", len(is.IceServers)))
+		span.End()
+		if enabled {
+			is.IPFamilies = conf.IPFamilies
 			iceServers[key] = is
 		}
 	}
 
 	return iceServers, "", nil
 }
+
+// getProviderIceServers asks the driver for the given provider key for its ICE
+// servers. Keys without a dedicated driver are treated as generic providers.
+func getProviderIceServers(key string, conf config.ICEConfig, logger *slog.Logger) (adapters.IceServersConfig, bool, error) {
+	switch key {
+	case "elixir":
+		if !conf.Enabled {
+			return adapters.IceServersConfig{}, false, nil
+		}
+		md := elixir.Driver{
+			Config: &conf,
+			Logger: logger,
+		}
+		is, err := md.GetIceServers()
+		if err != nil {
+			logger.Error("Error getting elixir ice servers")
+			return is, true, err
+		}
+		logger.Info("elixir IceServers", "is", is)
+		return is, true, nil
+	case "google":
+		if !conf.Enabled {
+			return adapters.IceServersConfig{}, false, nil
+		}
+		md := google.Driver{
+			Config: &conf,
+			Logger: logger,
+		}
+		is, err := md.GetIceServers()
+		if err != nil {
+			logger.Error("Error getting google ice servers")
+			return is, true, err
+		}
+		logger.Info("google IceServers", "is", is)
+		return is, true, nil
+	case "metered":
+		if !conf.Enabled {
+			return adapters.IceServersConfig{}, false, nil
+		}
+		md := metered.Driver{
+			Config: &conf,
+			Logger: logger,
+		}
+		is, err := md.GetIceServers()
+		if err != nil {
+			logger.Error("Error getting metered ice servers")
+			return is, true, err
+		}
+		logger.Info("metered IceServers", "is", is)
+		return is, true, nil
+	case "stunner":
+		if !conf.Enabled {
+			return adapters.IceServersConfig{}, false, nil
+		}
+		md := stunner.Driver{
+			Config: &conf,
+			Logger: logger,
+		}
+		is, err := md.GetIceServers()
+		if err != nil {
+			logger.Error("Error getting elixir ice servers")
+			return is, true, err
+		}
+		logger.Info("STUNner IceServers", "is", is)
+		return is, true, nil
+	case "twilio":
+		if !conf.Enabled {
+			return adapters.IceServersConfig{}, false, nil
+		}
+		td := twilio.Driver{
+			Config: &conf,
+			Logger: logger,
+		}
+		is, err := td.GetIceServers()
+		if err != nil {
+			logger.Error("Error getting twilio ice servers")
+			return is, true, err
+		}
+		logger.Info("twilio IceServers", "is", is)
+		return is, true, nil
+	case "xirsys":
+		if !conf.Enabled {
+			return adapters.IceServersConfig{}, false, nil
+		}
+		xd := xirsys.Driver{
+			Config: &conf,
+			Logger: logger,
+		}
+		is, err := xd.GetIceServers()
+		if err != nil {
+			logger.Error("Error getting xirsys ice servers")
+			return is, true, err
+		}
+		logger.Info("xirsys IceServers", "is", is)
+		return is, true, nil
+	case "cloudflare":
+		if !conf.Enabled {
+			return adapters.IceServersConfig{}, false, nil
+		}
+		cd := cloudflare.Driver{
+			Config: &conf,
+			Logger: logger,
+		}
+		is, err := cd.GetIceServers()
+		if err != nil {
+			logger.Error("Error getting cloudflare ice servers")
+			return is, true, err
+		}
+		logger.Info("cloudflare IceServers", "is", is)
+		return is, true, nil
+	case "expressturn":
+		if !conf.Enabled {
+			return adapters.IceServersConfig{}, false, nil
+		}
+		ed := expressturn.Driver{
+			Config: &conf,
+			Logger: logger,
+		}
+		is, err := ed.GetIceServers()
+		if err != nil {
+			logger.Error("Error getting expressturn ice servers")
+			return is, true, err
+		}
+		logger.Info("expressturn IceServers", "is", is)
+		return is, true, nil
+	default:
+		is, err := formGenericIceServers(&conf)
+		if err != nil {
+			logger.Error("Error getting generic ice servers")
+			return is, true, err
+		}
+		logger.Info("default IceServers", "key", key, "is", is)
+		return is, true, nil
+	}
+}
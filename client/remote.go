@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nimbleape/iceperf-agent/signaling"
+	"github.com/nimbleape/iceperf-agent/stats"
+	"github.com/pion/webrtc/v4"
+)
+
+// remoteStatsTimeout is how long the offerer waits for the answering agent's
+// side of the results once a test is over
+const remoteStatsTimeout = 5 * time.Second
+
+// remotePeer is the other agent of a distributed test, reached through a
+// signaling room. Candidates gathered before the offer or answer has been
+// sent are held back, so the other agent always gets the description first.
+type remotePeer struct {
+	peer   *signaling.Peer
+	test   string
+	logger *slog.Logger
+	// setup is sent with the offer
+	setup *signaling.Setup
+
+	mu      sync.Mutex
+	ready   bool
+	pending []webrtc.ICECandidateInit
+
+	stats  chan *stats.Stats
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newRemotePeer(peer *signaling.Peer, test string, logger *slog.Logger) *remotePeer {
+	return &remotePeer{
+		peer:   peer,
+		test:   test,
+		logger: logger,
+		stats:  make(chan *stats.Stats, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func (r *remotePeer) send(m signaling.Message) {
+	m.Test = r.test
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := r.peer.Send(ctx, m); err != nil {
+		r.logger.Error("Error sending to the signaling server", "type", m.Type, "err", err)
+	}
+}
+
+// sendDescription sends the offer or answer, then the candidates gathered
+// while it was being created
+func (r *remotePeer) sendDescription(m signaling.Message) {
+	r.send(m)
+
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = nil
+	r.ready = true
+	r.mu.Unlock()
+
+	for _, c := range pending {
+		r.send(signaling.Message{Type: signaling.TypeCandidate, Candidate: &c})
+	}
+}
+
+func (r *remotePeer) sendCandidate(c webrtc.ICECandidateInit) {
+	r.mu.Lock()
+	if !r.ready {
+		r.pending = append(r.pending, c)
+		r.mu.Unlock()
+		return
+	}
+	r.mu.Unlock()
+	go r.send(signaling.Message{Type: signaling.TypeCandidate, Candidate: &c})
+}
+
+// runRemote sends the offer to the answering agent, and applies its answer
+// and candidates until the test is finished
+func (c *Client) runRemote() {
+	offer, err := c.ConnectionPair.OfferPC.CreateOffer(nil)
+	if err != nil {
+		c.Logger.Error("Error creating offer", "err", err)
+		return
+	}
+	if err := c.ConnectionPair.OfferPC.SetLocalDescription(offer); err != nil {
+		c.Logger.Error("Error setting local description", "err", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.remote.cancel = cancel
+	go c.listenRemote(ctx)
+
+	c.remote.sendDescription(signaling.Message{Type: signaling.TypeOffer, SDP: &offer, Setup: c.remote.setup})
+}
+
+func (c *Client) listenRemote(ctx context.Context) {
+	r := c.remote
+	defer close(r.done)
+	for ctx.Err() == nil {
+		msgs, err := r.peer.Receive(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				r.logger.Error("Error polling the signaling server", "err", err)
+				sleep(ctx, time.Second)
+			}
+			continue
+		}
+		for _, m := range msgs {
+			if m.Test != r.test {
+				continue
+			}
+			switch m.Type {
+			case signaling.TypeAnswer:
+				if m.SDP == nil {
+					continue
+				}
+				if err := c.ConnectionPair.OfferPC.SetRemoteDescription(*m.SDP); err != nil {
+					r.logger.Error("Error setting the remote agent's answer", "err", err)
+				}
+			case signaling.TypeCandidate:
+				if m.Candidate == nil {
+					continue
+				}
+				if err := c.ConnectionPair.OfferPC.AddICECandidate(*m.Candidate); err != nil {
+					r.logger.Error("Error adding the remote agent's candidate", "err", err)
+				}
+			case signaling.TypeStats:
+				select {
+				case r.stats <- m.Stats:
+				default:
+				}
+			case signaling.TypeError:
+				r.logger.Error("Remote agent couldn't answer", "err", m.Error)
+			}
+		}
+	}
+}
+
+// finishRemote ends the test in the answering agent and adds its side of
+// the results to the stats
+func (c *Client) finishRemote() {
+	r := c.remote
+	if r.cancel == nil {
+		return
+	}
+	r.send(signaling.Message{Type: signaling.TypeBye})
+
+	select {
+	case s := <-r.stats:
+		if s != nil {
+			mergeAnswererStats(c.Stats, s)
+		}
+	case <-time.After(remoteStatsTimeout):
+		r.logger.Warn("No results from the remote agent")
+	}
+	r.cancel()
+	<-r.done
+}
+
+// mergeAnswererStats copies what the answering agent measured into the
+// offerer's stats
+func mergeAnswererStats(s, a *stats.Stats) {
+	s.SetAnswererNode(a.Node)
+	s.SetAnswererTimeToReceiveCandidate(a.AnswererTimeToReceiveCandidate)
+	s.SetAnswererDcBytesReceivedTotal(a.AnswererDcBytesReceivedTotal)
+	s.SetAnswererIceTransportBytesReceivedTotal(a.AnswererIceTransportBytesReceivedTotal)
+	s.SetAnswererIceTransportBytesSentTotal(a.AnswererIceTransportBytesSentTotal)
+	for t, v := range a.Throughput {
+		s.AddThroughput(t, v, a.InstantThroughput[t])
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/webrtc/v4"
+)
+
+// lookupIPAddr is replaced in tests
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// Resolution is the outcome of looking up an ICE server's host
+type Resolution struct {
+	Host      string
+	Addresses []net.IP
+	// Duration is how long the lookup took, zero for IP addresses
+	Duration time.Duration
+	Err      error
+}
+
+// Resolve looks up the IPv4 and IPv6 addresses of host, timing the lookup
+func Resolve(ctx context.Context, host string) Resolution {
+	if ip := net.ParseIP(host); ip != nil {
+		return Resolution{Host: host, Addresses: []net.IP{ip}}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	addrs, err := lookupIPAddr(ctx, host)
+	r := Resolution{Host: host, Duration: time.Since(start), Err: err}
+	for _, a := range addrs {
+		r.Addresses = append(r.Addresses, a.IP)
+	}
+	return r
+}
+
+// AddressURL returns the ICE server URL with its host replaced by addr, so
+// pion connects to that address instead of the one it would pick
+func AddressURL(u *stun.URI, addr net.IP) string {
+	au := *u
+	au.Host = addr.String()
+	return au.String()
+}
+
+// IPFamily returns ipv4 or ipv6
+func IPFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// networkTypes returns the pion network types of an address family, or nil
+// to let pion use all of them
+func networkTypes(family string) []webrtc.NetworkType {
+	switch family {
+	case "ipv4":
+		return []webrtc.NetworkType{webrtc.NetworkTypeUDP4, webrtc.NetworkTypeTCP4}
+	case "ipv6":
+		return []webrtc.NetworkType{webrtc.NetworkTypeUDP6, webrtc.NetworkTypeTCP6}
+	}
+	return nil
+}
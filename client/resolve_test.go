@@ -0,0 +1,34 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/pion/stun/v2"
+)
+
+func TestResolve(t *testing.T) {
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		assert.Equal(t, "turn.example.com", host)
+		return []net.IPAddr{{IP: net.ParseIP("192.0.2.1")}, {IP: net.ParseIP("2001:db8::1")}}, nil
+	}
+	defer func() { lookupIPAddr = net.DefaultResolver.LookupIPAddr }()
+
+	res := Resolve(context.Background(), "turn.example.com")
+	assert.NoError(t, res.Err)
+	assert.Equal(t, 2, len(res.Addresses))
+	assert.Equal(t, "ipv4", IPFamily(res.Addresses[0]))
+	assert.Equal(t, "ipv6", IPFamily(res.Addresses[1]))
+
+	res = Resolve(context.Background(), "198.51.100.7")
+	assert.Equal(t, "198.51.100.7", res.Addresses[0].String())
+}
+
+func TestAddressURL(t *testing.T) {
+	u, err := stun.ParseURI("turn:turn.example.com:3478?transport=tcp")
+	assert.NoError(t, err)
+	assert.Equal(t, "turn:192.0.2.1:3478?transport=tcp", AddressURL(u, net.ParseIP("192.0.2.1")))
+	assert.Equal(t, "turn:[2001:db8::1]:3478?transport=tcp", AddressURL(u, net.ParseIP("2001:db8::1")))
+}
@@ -0,0 +1,360 @@
+package client
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/stats"
+	"github.com/pion/logging"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// keepaliveTimeout is how long a keepalive can go without its echo
+	// before it is counted as lost
+	keepaliveTimeout = 10 * time.Second
+	// maxICERestarts is how many ICE restarts in a row can fail to
+	// reconnect before the soak test gives up
+	maxICERestarts = 3
+)
+
+// soakTest keeps the connection of a TURN test up for a soak test. The
+// offerer sends a numbered keepalive that the answerer echoes, and every
+// interval the round trips and the offerer's ICE transport throughput are
+// sampled. Disconnections, ICE restarts and the TURN client's refresh
+// failures are recorded as they happen, all in stats.Soak.
+type soakTest struct {
+	cp        *ConnectionPair
+	record    *stats.Soak
+	logger    *slog.Logger
+	interval  time.Duration
+	keepalive time.Duration
+	started   time.Time
+
+	mu     sync.Mutex
+	opened time.Time
+	seq    uint64
+	// sent holds the keepalives waiting for their echo
+	sent       map[uint64]time.Time
+	sentTotal  int
+	lost       int
+	rtts       []float64
+	lastSample time.Time
+	bytesSent  uint64
+	bytesRecv  uint64
+	// down is when the connection was lost, zero while it is up
+	down       time.Time
+	restarting bool
+	restarts   int
+	// failedRestarts counts the ICE restarts since the connection was last up
+	failedRestarts int
+}
+
+func newSoakTest(cp *ConnectionPair, sc config.SoakConfig) *soakTest {
+	return &soakTest{
+		cp:        cp,
+		record:    cp.stats.StartSoak(),
+		logger:    cp.LogOfferer,
+		interval:  time.Duration(sc.Interval) * time.Second,
+		keepalive: time.Duration(sc.Keepalive) * time.Second,
+		started:   time.Now(),
+		sent:      make(map[uint64]time.Time),
+	}
+}
+
+// restarted reports whether ICE was restarted in a soak test, after which
+// new candidates don't count towards the test's timings
+func (cp *ConnectionPair) restarted() bool {
+	if cp.soak == nil {
+		return false
+	}
+	cp.soak.mu.Lock()
+	defer cp.soak.mu.Unlock()
+	return cp.soak.restarts > 0
+}
+
+func (s *soakTest) since() int64 {
+	return time.Since(s.started).Milliseconds()
+}
+
+func (s *soakTest) event(kind, detail string) {
+	s.record.AddEvent(stats.SoakEvent{Time: s.since(), Type: kind, Detail: detail})
+}
+
+// run sends keepalives and takes samples until the data channel is closed.
+// It is called once the offerer's data channel is open.
+func (s *soakTest) run(pc *webrtc.PeerConnection, dc *webrtc.DataChannel) {
+	s.mu.Lock()
+	s.opened = time.Now()
+	s.lastSample = s.opened
+	s.mu.Unlock()
+
+	s.logger.Info("OnOpen: Start sending keepalives for the soak test", "dataChannelLabel", dc.Label(),
+		"keepaliveSeconds", s.keepalive.Seconds(), "intervalSeconds", s.interval.Seconds())
+
+	keepalive := time.NewTicker(s.keepalive)
+	defer keepalive.Stop()
+	sample := time.NewTicker(s.interval)
+	defer sample.Stop()
+
+	// the data channel stays open through disconnections and ICE restarts,
+	// and is closed when the test is stopped
+	closed := func() bool {
+		state := dc.ReadyState()
+		return state == webrtc.DataChannelStateClosing || state == webrtc.DataChannelStateClosed
+	}
+
+	s.sendKeepalive(dc)
+	for {
+		select {
+		case <-keepalive.C:
+			if closed() {
+				return
+			}
+			s.sendKeepalive(dc)
+		case <-sample.C:
+			if closed() {
+				return
+			}
+			s.sample(pc)
+		}
+	}
+}
+
+func (s *soakTest) sendKeepalive(dc *webrtc.DataChannel) {
+	s.mu.Lock()
+	s.seq++
+	seq := s.seq
+	s.sent[seq] = time.Now()
+	s.sentTotal++
+	s.mu.Unlock()
+	s.cp.markInitialMessageSent()
+
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, seq)
+	if err := dc.Send(buf); err != nil {
+		s.logger.Debug("Error sending keepalive", "err", err)
+	}
+}
+
+// onEcho times the answerer's echo of a keepalive
+func (s *soakTest) onEcho(data []byte) {
+	if len(data) != 8 {
+		return
+	}
+	seq := binary.BigEndian.Uint64(data)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sent, ok := s.sent[seq]; ok {
+		delete(s.sent, seq)
+		s.rtts = append(s.rtts, float64(time.Since(sent).Microseconds())/1000)
+	}
+}
+
+// expire counts the keepalives sent before t that were never echoed as
+// lost. Callers must hold s.mu.
+func (s *soakTest) expire(t time.Time) {
+	for seq, sent := range s.sent {
+		if sent.Before(t) {
+			delete(s.sent, seq)
+			s.lost++
+		}
+	}
+}
+
+func (s *soakTest) sample(pc *webrtc.PeerConnection) {
+	var bytesSent, bytesRecv uint64
+	if transport, ok := pc.GetStats()["iceTransport"].(webrtc.TransportStats); ok {
+		bytesSent, bytesRecv = transport.BytesSent, transport.BytesReceived
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	seconds := now.Sub(s.lastSample).Seconds()
+	sample := stats.SoakSample{
+		Time:  now.Sub(s.started).Milliseconds(),
+		RTT:   stats.Mean(s.rtts),
+		State: pc.ICEConnectionState().String(),
+	}
+	// the counters start again with the transport after an ICE restart
+	if seconds > 0 && bytesSent >= s.bytesSent && bytesRecv >= s.bytesRecv {
+		sample.SentKbps = 8 * float64(bytesSent-s.bytesSent) / 1000 / seconds
+		sample.ReceivedKbps = 8 * float64(bytesRecv-s.bytesRecv) / 1000 / seconds
+	}
+	s.rtts = nil
+	s.lastSample = now
+	s.bytesSent, s.bytesRecv = bytesSent, bytesRecv
+	s.expire(now.Add(-keepaliveTimeout))
+	s.mu.Unlock()
+
+	s.record.AddSample(sample)
+	s.logger.Info("Soak sample", "rttMs", sample.RTT,
+		"sentKbps", sample.SentKbps, "receivedKbps", sample.ReceivedKbps, "iceConnState", sample.State)
+}
+
+// onICEState records the offerer losing and getting back its connection,
+// and restarts ICE when it fails
+func (s *soakTest) onICEState(state webrtc.ICEConnectionState) {
+	switch state {
+	case webrtc.ICEConnectionStateDisconnected:
+		s.markDown()
+		s.event(stats.SoakDisconnected, "")
+		s.logger.Warn("Soak test disconnected", "timeSinceStartMs", s.since())
+	case webrtc.ICEConnectionStateFailed:
+		s.markDown()
+		s.event(stats.SoakFailed, "")
+		s.logger.Warn("Soak test connection failed", "timeSinceStartMs", s.since())
+		go s.restartICE()
+	case webrtc.ICEConnectionStateConnected:
+		s.mu.Lock()
+		down := s.down
+		s.down = time.Time{}
+		s.failedRestarts = 0
+		s.mu.Unlock()
+		if !down.IsZero() {
+			s.event(stats.SoakReconnected, fmt.Sprintf("down for %dms", time.Since(down).Milliseconds()))
+			s.logger.Info("Soak test reconnected", "downMs", time.Since(down).Milliseconds())
+		}
+	}
+}
+
+func (s *soakTest) markDown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down.IsZero() {
+		s.down = time.Now()
+	}
+}
+
+func (s *soakTest) restartICE() {
+	s.mu.Lock()
+	if s.restarting {
+		s.mu.Unlock()
+		return
+	}
+	if s.failedRestarts >= maxICERestarts {
+		s.mu.Unlock()
+		s.logger.Error("Soak test couldn't reconnect, giving up", "iceRestarts", maxICERestarts)
+		select {
+		case s.cp.closeChan <- struct{}{}:
+		default:
+		}
+		return
+	}
+	s.restarting = true
+	s.restarts++
+	s.failedRestarts++
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.restarting = false
+		s.mu.Unlock()
+	}()
+
+	s.event(stats.SoakICERestart, "")
+	s.logger.Warn("Restarting ICE", "timeSinceStartMs", s.since())
+	if err := s.cp.restartICE(); err != nil {
+		s.logger.Error("Error restarting ICE", "err", err)
+	}
+}
+
+// restartICE renegotiates the pair with new ICE credentials, so both peers
+// gather new candidates and allocations
+func (cp *ConnectionPair) restartICE() error {
+	offer, err := cp.OfferPC.CreateOffer(&webrtc.OfferOptions{ICERestart: true})
+	if err != nil {
+		return err
+	}
+	if err := cp.OfferPC.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	if err := cp.AnswerPC.SetRemoteDescription(offer); err != nil {
+		return err
+	}
+	answer, err := cp.AnswerPC.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	if err := cp.AnswerPC.SetLocalDescription(answer); err != nil {
+		return err
+	}
+	return cp.OfferPC.SetRemoteDescription(answer)
+}
+
+// finish counts the keepalives and sets how long the connection was kept up
+func (s *soakTest) finish() {
+	s.mu.Lock()
+	s.expire(time.Now().Add(-keepaliveTimeout))
+	// keepalives still within the timeout may yet have been echoed
+	sent := s.sentTotal - len(s.sent)
+	lost := s.lost
+	var duration int64
+	if !s.opened.IsZero() {
+		duration = time.Since(s.opened).Milliseconds()
+	}
+	s.mu.Unlock()
+
+	s.record.AddKeepalives(sent, lost)
+	s.record.Finish(duration)
+}
+
+// loggerFactory passes pion's logs of a peer on to its default loggers, and
+// records the TURN client's refresh failures
+func (s *soakTest) loggerFactory(peer string) logging.LoggerFactory {
+	return &soakLoggerFactory{base: logging.NewDefaultLoggerFactory(), soak: s, peer: peer}
+}
+
+type soakLoggerFactory struct {
+	base logging.LoggerFactory
+	soak *soakTest
+	peer string
+}
+
+func (f *soakLoggerFactory) NewLogger(scope string) logging.LeveledLogger {
+	return &soakLogger{LeveledLogger: f.base.NewLogger(scope), soak: f.soak, peer: f.peer}
+}
+
+type soakLogger struct {
+	logging.LeveledLogger
+	soak *soakTest
+	peer string
+}
+
+func (l *soakLogger) Warn(msg string) {
+	l.LeveledLogger.Warn(msg)
+	l.check(msg)
+}
+
+func (l *soakLogger) Warnf(format string, args ...interface{}) {
+	l.LeveledLogger.Warnf(format, args...)
+	l.check(fmt.Sprintf(format, args...))
+}
+
+func (l *soakLogger) Error(msg string) {
+	l.LeveledLogger.Error(msg)
+	l.check(msg)
+}
+
+func (l *soakLogger) Errorf(format string, args ...interface{}) {
+	l.LeveledLogger.Errorf(format, args...)
+	l.check(fmt.Sprintf(format, args...))
+}
+
+func (l *soakLogger) check(msg string) {
+	if isRefreshFailure(msg) {
+		l.soak.event(stats.SoakRefreshFailure, l.peer+": "+msg)
+		l.soak.logger.Warn("TURN refresh failed", "peer", l.peer, "err", msg)
+	}
+}
+
+// isRefreshFailure matches what the TURN client logs when refreshing an
+// allocation or its permissions fails
+func isRefreshFailure(msg string) bool {
+	msg = strings.ToLower(msg)
+	return strings.Contains(msg, "refresh") && (strings.Contains(msg, "fail") || strings.Contains(msg, "no nonce"))
+}
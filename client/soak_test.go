@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/stats"
+	"github.com/pion/stun/v2"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestIsRefreshFailure(t *testing.T) {
+	// as logged by pion/turn's client
+	assert.True(t, isRefreshFailure("Failed to refresh allocation: failed to refresh allocation: all retransmissions failed"))
+	assert.True(t, isRefreshFailure("Failed to refresh permissions: transaction closed"))
+	assert.True(t, isRefreshFailure("Fail to refresh permissions: 403"))
+	assert.True(t, isRefreshFailure("Refresh allocation: 438 but no nonce."))
+	assert.False(t, isRefreshFailure("Refresh permissions successful"))
+	assert.False(t, isRefreshFailure("Failed to read from relay conn"))
+}
+
+// echoed is how many keepalives have been echoed so far
+func (s *soakTest) echoed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sentTotal - len(s.sent) - s.lost
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestSoakLoopback runs a short soak test between two peers on this host,
+// without a TURN server: the scheme only selects the soak handlers
+func TestSoakLoopback(t *testing.T) {
+	iceServerInfo, err := stun.ParseURI("turn:127.0.0.1:3478?transport=udp")
+	assert.NoError(t, err)
+	cc := &config.Config{
+		Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		SoakTest: true,
+		Soak:     config.SoakConfig{Enabled: true, Interval: 1, Keepalive: 1},
+	}
+	closeChan := make(chan struct{}, 1)
+	st := stats.NewStats("soak", time.Now())
+	cp, err := newConnectionPair(cc, iceServerInfo, "loopback", st, false, closeChan, newPhaseSpans(context.Background()), nil)
+	assert.NoError(t, err)
+	s := cp.soak
+	s.keepalive = 20 * time.Millisecond
+	s.interval = 100 * time.Millisecond
+
+	cp.OfferPC.OnICECandidate(func(i *webrtc.ICECandidate) {
+		if i != nil {
+			cp.AnswerPC.AddICECandidate(i.ToJSON())
+		}
+	})
+	cp.AnswerPC.OnICECandidate(func(i *webrtc.ICECandidate) {
+		if i != nil {
+			cp.OfferPC.AddICECandidate(i.ToJSON())
+		}
+	})
+	offer, err := cp.OfferPC.CreateOffer(nil)
+	assert.NoError(t, err)
+	gathered := webrtc.GatheringCompletePromise(cp.OfferPC)
+	assert.NoError(t, cp.OfferPC.SetLocalDescription(offer))
+	<-gathered
+	assert.NoError(t, cp.AnswerPC.SetRemoteDescription(*cp.OfferPC.LocalDescription()))
+	answer, err := cp.AnswerPC.CreateAnswer(nil)
+	assert.NoError(t, err)
+	assert.NoError(t, cp.AnswerPC.SetLocalDescription(answer))
+	assert.NoError(t, cp.OfferPC.SetRemoteDescription(answer))
+
+	// the answerer echoes the keepalives, timed at every sample
+	waitFor(t, "keepalives to be echoed", func() bool { return s.echoed() >= 5 })
+	waitFor(t, "a sample", func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.lastSample.After(s.opened)
+	})
+
+	// the connection comes back after an ICE restart
+	s.restartICE()
+	restarted := s.echoed()
+	waitFor(t, "keepalives after the ICE restart", func() bool { return s.echoed() > restarted+5 })
+	assert.True(t, cp.restarted())
+
+	// and the test gives up once restarts keep failing to reconnect
+	s.mu.Lock()
+	s.failedRestarts = maxICERestarts
+	s.mu.Unlock()
+	s.restartICE()
+	select {
+	case <-closeChan:
+	case <-time.After(time.Second):
+		t.Fatal("soak test didn't give up after failed ICE restarts")
+	}
+
+	// a keepalive that is never echoed is lost
+	s.mu.Lock()
+	s.seq++
+	s.sent[s.seq] = time.Now().Add(-2 * keepaliveTimeout)
+	s.sentTotal++
+	s.mu.Unlock()
+
+	cp.OfferDC.Close()
+	// let the keepalive loop see the data channel closed
+	time.Sleep(4 * s.interval)
+	s.finish()
+	cp.OfferPC.Close()
+	cp.AnswerPC.Close()
+
+	soak := st.Soak
+	assert.True(t, soak.Duration > 0)
+	assert.True(t, soak.KeepalivesSent > 10)
+	assert.Equal(t, 1, soak.KeepalivesLost)
+	assert.Equal(t, 1, soak.ICERestarts)
+	assert.True(t, len(soak.Samples) >= 1)
+	assert.True(t, soak.Samples[0].RTT > 0)
+	assert.Equal(t, stats.SoakICERestart, soak.Events[0].Type)
+}
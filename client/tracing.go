@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nimbleape/iceperf-agent/telemetry"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// phaseSpans tracks the child spans for each phase of an ICE server test.
+// Phases are started and ended from pion callbacks so access is locked.
+type phaseSpans struct {
+	mu    sync.Mutex
+	ctx   context.Context
+	spans map[string]trace.Span
+	ended map[string]bool
+}
+
+func newPhaseSpans(ctx context.Context) *phaseSpans {
+	return &phaseSpans{
+		ctx:   ctx,
+		spans: make(map[string]trace.Span),
+		ended: make(map[string]bool),
+	}
+}
+
+// start opens the phase span unless it has already been started
+func (p *phaseSpans) start(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.spans[name]; ok {
+		return
+	}
+	_, span := telemetry.Tracer().Start(p.ctx, name)
+	p.spans[name] = span
+}
+
+// end closes the phase span if it is open
+func (p *phaseSpans) end(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if span, ok := p.spans[name]; ok && !p.ended[name] {
+		span.End()
+		p.ended[name] = true
+	}
+}
+
+// endAll closes any phases still open, e.g. when the test timed out
+func (p *phaseSpans) endAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, span := range p.spans {
+		if !p.ended[name] {
+			span.End()
+			p.ended[name] = true
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strconv"
+	"time"
+)
+
+// defaultCredentialTTL is how long minted TURN credentials are valid for
+// when no ttl is configured
+const defaultCredentialTTL = 24 * time.Hour
+
+// turnRESTCredentials mints time-limited TURN credentials with the TURN REST
+// API scheme: the username is the expiry as a unix timestamp, followed by
+// :suffix if there is one, and the password is the base64 HMAC-SHA1 of the
+// username keyed with the shared secret.
+func turnRESTCredentials(secret, suffix string, ttl time.Duration, now time.Time) (username, password string) {
+	if ttl <= 0 {
+		ttl = defaultCredentialTTL
+	}
+	username = strconv.FormatInt(now.Add(ttl).Unix(), 10)
+	if suffix != "" {
+		username += ":" + suffix
+	}
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	password = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return username, password
+}
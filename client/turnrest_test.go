@@ -0,0 +1,39 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/config"
+)
+
+func TestTurnRESTCredentials(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	username, password := turnRESTCredentials("north", "alice", 0, now)
+	assert.Equal(t, "1700086400:alice", username)
+	assert.Equal(t, "SXua5ne/+mDhiHTp0pQJzRO4ESg=", password)
+
+	username, _ = turnRESTCredentials("north", "", time.Hour, now)
+	assert.Equal(t, "1700003600", username)
+}
+
+func TestGenericIceServersWithSharedSecret(t *testing.T) {
+	is, err := formGenericIceServers(&config.ICEConfig{
+		TurnEnabled:    true,
+		TurnHost:       "turn.example.com",
+		TurnPorts:      map[string][]int{"udp": {3478}},
+		Username:       "static",
+		Password:       "static",
+		SharedSecret:   "north",
+		UsernameSuffix: "iceperf",
+		TTL:            600,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(is.IceServers))
+	assert.Equal(t, "turn:turn.example.com:3478?transport=udp", is.IceServers[0].URLs[0])
+	assert.True(t, is.IceServers[0].Username != "static")
+	assert.Contains(t, is.IceServers[0].Username, ":iceperf")
+	assert.NotEqual(t, "static", is.IceServers[0].Credential)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/nimbleape/iceperf-agent/compare"
+	"github.com/nimbleape/iceperf-agent/stats"
+	"github.com/rodaine/table"
+	"github.com/urfave/cli/v2"
+)
+
+var compareCommand = &cli.Command{
+	Name:      "compare",
+	Usage:     "Compare two runs or result files and report regressions",
+	ArgsUsage: "<before> <after>",
+	Description: "Each argument is a results file (a JSON array of stats, a run document, " +
+		"or run documents as JSON lines) or a test run ID from the local result history.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "db",
+			Usage: "History database used to look up run IDs, defaults to history.path from the config",
+		},
+		&cli.Float64Flag{
+			Name:  "threshold",
+			Usage: "Smallest change in percent that is reported as a regression",
+			Value: 10,
+		},
+		&cli.Float64Flag{
+			Name:  "alpha",
+			Usage: "Significance level for Welch's t-test when both sides have at least two samples",
+			Value: 0.05,
+		},
+		&cli.BoolFlag{
+			Name:  "fail-on-regression",
+			Usage: "Exit with a non-zero status if any regression is found",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Output JSON instead of a table",
+		},
+	},
+	Action: runCompare,
+}
+
+// loadResults reads results from a file, or from the history store if arg
+// isn't a file
+func loadResults(c *cli.Context, arg string) ([]*stats.Stats, error) {
+	if _, err := os.Stat(arg); err == nil {
+		return compare.LoadFile(arg)
+	}
+
+	store, err := historyStore(c)
+	if err != nil {
+		return nil, err
+	}
+	run, err := store.Run(arg)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a results file or a run in %s: %w", arg, store.Path, err)
+	}
+	return run.Results, nil
+}
+
+func runCompare(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return cli.Exit("compare needs exactly two runs or result files", 1)
+	}
+
+	before, err := loadResults(c, c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	after, err := loadResults(c, c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+
+	deltas := compare.Compare(before, after, compare.Options{
+		ThresholdPercent: c.Float64("threshold"),
+		Alpha:            c.Float64("alpha"),
+	})
+	regressions := compare.Regressions(deltas)
+
+	if c.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(deltas); err != nil {
+			return err
+		}
+	} else {
+		printDeltas(deltas)
+		fmt.Printf("\n%d regressions\n", len(regressions))
+	}
+
+	if c.Bool("fail-on-regression") && len(regressions) > 0 {
+		return cli.Exit("", 2)
+	}
+	return nil
+}
+
+func printDeltas(deltas []*compare.Delta) {
+	headerFmt := color.New(color.FgGreen, color.Underline).SprintfFunc()
+	columnFmt := color.New(color.FgYellow).SprintfFunc()
+	regressionFmt := color.New(color.FgRed).SprintFunc()
+
+	tbl := table.New("Provider", "Scheme", "Protocol", "URL", "Family", "Metric", "Before", "After", "Change", "p-value", "Samples", "Status")
+	tbl.WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt)
+
+	for _, d := range deltas {
+		pValue := "-"
+		if d.PValue >= 0 {
+			pValue = fmt.Sprintf("%.3f", d.PValue)
+		}
+		status := d.Status
+		if status == compare.StatusRegression {
+			status = regressionFmt(status)
+		}
+		tbl.AddRow(d.Provider, d.Scheme, d.Protocol, d.URL, d.AddressFamily, d.Metric,
+			fmt.Sprintf("%.2f", d.Before), fmt.Sprintf("%.2f", d.After), fmt.Sprintf("%+.1f%%", d.ChangePercent),
+			pValue, fmt.Sprintf("%d/%d", d.BeforeSamples, d.AfterSamples), status)
+	}
+
+	tbl.Print()
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/rodaine/table"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+var configCommand = &cli.Command{
+	Name:  "config",
+	Usage: "Inspect the agent's config",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "print",
+			Usage: "Print the config file, or with --effective every setting after merging all sources",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "effective",
+					Usage: "Merge the defaults, config file, API, environment and flags, and show where each setting came from",
+				},
+				&cli.BoolFlag{
+					Name:  "show-secrets",
+					Usage: "Show passwords, API keys and headers instead of hiding them",
+				},
+			},
+			Action: runConfigPrint,
+		},
+		{
+			Name:   "validate",
+			Usage:  "Check the effective config for unknown settings, missing provider settings and invalid ports",
+			Action: runConfigValidate,
+		},
+		{
+			Name:  "schema",
+			Usage: "Print the JSON Schema of the config file, for editor autocompletion",
+			Action: func(c *cli.Context) error {
+				schema, err := config.SchemaJSON()
+				if err != nil {
+					return err
+				}
+				_, err = os.Stdout.Write(schema)
+				return err
+			},
+		},
+	},
+}
+
+func runConfigValidate(c *cli.Context) error {
+	conf, _, err := loadConfig(c)
+	if err == nil {
+		err = conf.Validate()
+	}
+	if err != nil {
+		fmt.Println("Config is invalid:")
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Println("  " + line)
+		}
+		return cli.Exit("", 1)
+	}
+	if conf.ApiError != nil {
+		fmt.Println("Warning: checked without the API settings, they couldn't be fetched:", conf.ApiError)
+	}
+	fmt.Println("Config is valid")
+	return nil
+}
+
+func runConfigPrint(c *cli.Context) error {
+	if !c.Bool("effective") {
+		body := ""
+		if configFile := c.String("config"); configFile != "" {
+			content, err := os.ReadFile(configFile)
+			if err != nil {
+				return err
+			}
+			body = string(content)
+		}
+		file, err := config.FileLayer(body)
+		if err != nil {
+			return err
+		}
+		if !c.Bool("show-secrets") {
+			redact(file.Values, "")
+		}
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		return enc.Encode(file.Values)
+	}
+
+	conf, sources, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	if conf.ApiError != nil {
+		fmt.Fprintln(os.Stderr, "Warning: the API settings couldn't be fetched:", conf.ApiError)
+	}
+
+	values := config.FlattenAll(conf)
+	paths := make([]string, 0, len(values))
+	for p := range values {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	headerFmt := color.New(color.FgGreen, color.Underline).SprintfFunc()
+	columnFmt := color.New(color.FgYellow).SprintfFunc()
+
+	tbl := table.New("Setting", "Value", "Source")
+	tbl.WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt)
+
+	for _, p := range paths {
+		value := values[p]
+		if value != "" && config.IsSecret(p, values) && !c.Bool("show-secrets") {
+			value = "********"
+		}
+		tbl.AddRow(p, value, sources.Lookup(p))
+	}
+
+	tbl.Print()
+	fmt.Println()
+	fmt.Println("Sources, from lowest to highest precedence: default, file, api, env, flag")
+	return nil
+}
+
+// redact hides the secrets in a config tree
+func redact(values map[string]any, prefix string) {
+	// the settings next to a secret, like a sink's type, can make it one
+	siblings := make(map[string]string)
+	for k, v := range values {
+		if n, ok := v.(*yaml.Node); ok && n.Kind == yaml.ScalarNode {
+			siblings[join(prefix, k)] = n.Value
+		}
+	}
+
+	for k, v := range values {
+		path := join(prefix, k)
+		switch v := v.(type) {
+		case map[string]any:
+			redact(v, path)
+		case *yaml.Node:
+			if v.Kind == yaml.ScalarNode {
+				if config.IsSecret(path, siblings) {
+					values[k] = "********"
+				}
+			} else {
+				redactNode(v, path)
+			}
+		}
+	}
+}
+
+// redactNode hides the secrets in the lists of a config tree, which are
+// left as YAML nodes
+func redactNode(n *yaml.Node, path string) {
+	switch n.Kind {
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			redactNode(c, join(path, strconv.Itoa(i)))
+		}
+	case yaml.MappingNode:
+		siblings := make(map[string]string)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if v := n.Content[i+1]; v.Kind == yaml.ScalarNode {
+				siblings[join(path, n.Content[i].Value)] = v.Value
+			}
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			p := join(path, n.Content[i].Value)
+			if v := n.Content[i+1]; v.Kind == yaml.ScalarNode {
+				if config.IsSecret(p, siblings) {
+					v.SetString("********")
+				}
+			} else {
+				redactNode(v, p)
+			}
+		}
+	case yaml.ScalarNode:
+		if config.IsSecret(path, nil) {
+			n.SetString("********")
+		}
+	}
+}
+
+func join(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/signaling"
+)
+
+// acceptTimeout is how long the offerer waits for the answering agent to
+// accept a run
+const acceptTimeout = 30 * time.Second
+
+// agreeRun announces the run in the agents' room with the ICE servers of
+// the plan, and waits for the answering agent to accept it. It returns the
+// tests of the ICE servers the answerer agreed to, and the peer for the
+// run's own room.
+func agreeRun(ctx context.Context, cc *config.Config, runID string, plan []testCase) ([]testCase, *signaling.Peer, error) {
+	d := cc.Distributed
+	lobby := signaling.NewPeer(d.URL(), d.Token, d.Room, signaling.RoleOfferer)
+	if err := lobby.Sync(ctx); err != nil {
+		return nil, nil, err
+	}
+	run := &signaling.Run{ID: runID, ICEServers: planICEServers(plan, cc)}
+	if err := lobby.Send(ctx, signaling.Message{Type: signaling.TypeRun, Run: run}); err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, acceptTimeout)
+	defer cancel()
+	for {
+		msgs, err := lobby.Receive(ctx)
+		if ctx.Err() != nil {
+			return nil, nil, errors.New("no answering agent accepted the run")
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, m := range msgs {
+			if m.Type == signaling.TypeAccept && m.Run != nil && m.Run.ID == runID {
+				return agreedTests(plan, m.Run.ICEServers, cc), signaling.NewPeer(d.URL(), d.Token, runID, signaling.RoleOfferer), nil
+			}
+		}
+	}
+}
+
+// planICEServers lists the ICE servers used by the plan once each, in the
+// order they are first used
+func planICEServers(plan []testCase, cc *config.Config) []signaling.ICEServer {
+	var servers []signaling.ICEServer
+	seen := make(map[signaling.ICEServer]bool)
+	for _, tc := range plan {
+		for _, is := range testICEServers(tc, cc) {
+			if !seen[is] {
+				seen[is] = true
+				servers = append(servers, is)
+			}
+		}
+	}
+	return servers
+}
+
+// agreedTests returns the tests of the plan whose ICE servers were all
+// accepted
+func agreedTests(plan []testCase, accepted []signaling.ICEServer, cc *config.Config) []testCase {
+	ok := make(map[signaling.ICEServer]bool)
+	for _, is := range accepted {
+		ok[is] = true
+	}
+	var tests []testCase
+	for _, tc := range plan {
+		agreed := true
+		for _, is := range testICEServers(tc, cc) {
+			agreed = agreed && ok[is]
+		}
+		if agreed {
+			tests = append(tests, tc)
+		}
+	}
+	return tests
+}
+
+// testICEServers returns the ICE servers a test uses: the one under test
+// and the answerer's, which the answering agent is sent with each offer
+func testICEServers(tc testCase, cc *config.Config) []signaling.ICEServer {
+	servers := []signaling.ICEServer{{Provider: tc.provider, URL: testURL(tc)}}
+	add := func(provider string, urls []string) {
+		for _, u := range urls {
+			servers = append(servers, signaling.ICEServer{Provider: provider, URL: signaling.NormalizeURL(u)})
+		}
+	}
+	if tc.answerer != nil {
+		add(tc.answererProvider, tc.answerer.URLs)
+		return servers
+	}
+	a := cc.AnswererFor(tc.provider)
+	switch a.Mode {
+	case config.AnswererNone:
+	case config.AnswererCustom:
+		for _, is := range a.ICEServers {
+			add(tc.provider, is.URLs)
+		}
+	default:
+		add(tc.provider, tc.iceServer.URLs[1:])
+	}
+	return servers
+}
+
+// testURL is the URL of the ICE server a test uses, as sent to the
+// answerer with each offer
+func testURL(tc testCase) string {
+	return signaling.NormalizeURL(tc.iceServer.URLs[0])
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/history"
+	"github.com/rodaine/table"
+	"github.com/urfave/cli/v2"
+)
+
+var historyCommand = &cli.Command{
+	Name:  "history",
+	Usage: "Show per provider and transport trends from the local result history",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "db",
+			Usage: "History database, defaults to history.path from the config",
+		},
+		&cli.IntFlag{
+			Name:    "last",
+			Aliases: []string{"n"},
+			Usage:   "Only include the last N runs",
+		},
+		&cli.DurationFlag{
+			Name:  "since",
+			Usage: "Only include runs from this long ago, e.g. 24h",
+		},
+		&cli.StringFlag{
+			Name:    "provider",
+			Aliases: []string{"p"},
+			Usage:   "Only include this provider",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "Output JSON instead of a table",
+		},
+	},
+	Action: runHistory,
+}
+
+// loadConfigFile reads the config file passed with --config and the
+// environment, without fetching any settings from the API
+func loadConfigFile(c *cli.Context) (*config.Config, error) {
+	configBody := ""
+	if configFile := c.String("config"); configFile != "" {
+		content, err := os.ReadFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+		configBody = string(content)
+	}
+	file, err := config.FileLayer(configBody)
+	if err != nil {
+		return nil, err
+	}
+	env, err := config.EnvLayer(os.Environ())
+	if err != nil {
+		return nil, err
+	}
+	conf, _, err := config.Build(file, env)
+	return conf, err
+}
+
+func historyStore(c *cli.Context) (*history.Store, error) {
+	path := c.String("db")
+	if path == "" {
+		conf, err := loadConfigFile(c)
+		if err != nil {
+			return nil, err
+		}
+		path = conf.History.Path
+	}
+	return history.New(path), nil
+}
+
+func runHistory(c *cli.Context) error {
+	store, err := historyStore(c)
+	if err != nil {
+		return err
+	}
+
+	q := history.Query{
+		LastRuns: c.Int("last"),
+		Provider: c.String("provider"),
+	}
+	if since := c.Duration("since"); since > 0 {
+		q.Since = time.Now().Add(-since)
+	}
+
+	runs, err := store.Runs(q)
+	if err != nil {
+		return err
+	}
+	trends := history.Trends(runs)
+
+	if c.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(trends)
+	}
+
+	if len(runs) == 0 {
+		fmt.Println("No runs found in", store.Path)
+		return nil
+	}
+	fmt.Printf("%d runs from %s to %s\n\n", len(runs),
+		runs[0].StartedAt.Format(time.RFC3339), runs[len(runs)-1].StartedAt.Format(time.RFC3339))
+
+	headerFmt := color.New(color.FgGreen, color.Underline).SprintfFunc()
+	columnFmt := color.New(color.FgYellow).SprintfFunc()
+
+	tbl := table.New("Provider", "Scheme", "Protocol", "URL", "Family", "Tests", "Success Rate", "Median Time to Connected", "p95 Time to Connected", "Median Time to candidate", "Median Max Throughput", "Median TURN Transfer Latency")
+	tbl.WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt)
+
+	for _, t := range trends {
+		tbl.AddRow(t.Provider, t.Scheme, t.Protocol, t.URL, t.AddressFamily, t.Tests, fmt.Sprintf("%.1f%%", t.SuccessRate*100),
+			t.MedianTimeToConnected, t.P95TimeToConnected, t.MedianTimeToCandidate, t.MedianThroughputMax, t.MedianLatencyFirstPkt)
+	}
+
+	tbl.Print()
+	return nil
+}
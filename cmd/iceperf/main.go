@@ -4,19 +4,31 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"os"
 	"time"
 
 	"github.com/nimbleape/iceperf-agent/client"
 	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/dashboard"
+	"github.com/nimbleape/iceperf-agent/environment"
+	"github.com/nimbleape/iceperf-agent/history"
+	"github.com/nimbleape/iceperf-agent/nat"
+	"github.com/nimbleape/iceperf-agent/reporter"
+	"github.com/nimbleape/iceperf-agent/scheduler"
+	"github.com/nimbleape/iceperf-agent/signaling"
 	"github.com/nimbleape/iceperf-agent/stats"
+	"github.com/nimbleape/iceperf-agent/telemetry"
 	"github.com/nimbleape/iceperf-agent/version"
 	"github.com/pion/stun/v2"
 	"github.com/pion/webrtc/v4"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	// "github.com/prometheus/client_golang/prometheus/push"
 
@@ -29,9 +41,6 @@ import (
 
 	// "github.com/grafana/loki-client-go/loki"
 	loki "github.com/magnetde/slog-loki"
-
-	"github.com/fatih/color"
-	"github.com/rodaine/table"
 )
 
 func main() {
@@ -64,6 +73,12 @@ func main() {
 			},
 		},
 		Action: runService,
+		Commands: []*cli.Command{
+			historyCommand,
+			compareCommand,
+			configCommand,
+			signalCommand,
+		},
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -77,6 +92,10 @@ func runService(ctx *cli.Context) error {
 		fmt.Println("Error loading config")
 		return err
 	}
+	if err := config.Validate(); err != nil {
+		fmt.Println("Invalid config")
+		return err
+	}
 
 	lvl := new(slog.LevelVar)
 	lvl.Set(slog.LevelError)
@@ -186,31 +205,104 @@ func runService(ctx *cli.Context) error {
 	}
 	slog.SetDefault(logg)
 
+	if config.ApiError != nil {
+		logg.Warn("Error fetching settings from the API, continuing without them", "uri", config.Api.URI, "err", config.ApiError)
+	}
+
+	shutdownTelemetry, err := telemetry.Setup(ctx.Context, &config.Logging.OTel, config.NodeID)
+	if err != nil {
+		logg.Error("Error setting up OpenTelemetry", "err", err)
+		return err
+	}
+	defer func() {
+		if err := shutdownTelemetry(context.Background()); err != nil {
+			logg.Error("Error shutting down OpenTelemetry", "err", err)
+		}
+	}()
+
+	rep, err := reporter.New(ctx.Context, config, logg)
+	if err != nil {
+		logg.Error("Error setting up result sinks", "err", err)
+		return err
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		rep.Close(flushCtx)
+	}()
+
+	if config.Dashboard.Enabled {
+		listen := config.Dashboard.Listen
+		if listen == "" {
+			listen = "127.0.0.1:8080"
+		}
+		dash := dashboard.New(listen, config.Dashboard.MaxRuns, logg)
+		if config.History.Enabled {
+			runs, err := history.New(config.History.Path).Runs(history.Query{LastRuns: config.Dashboard.MaxRuns})
+			if err != nil {
+				logg.Error("Error loading history for the dashboard", "err", err)
+			}
+			dash.Seed(runs)
+		}
+		dash.Start()
+		rep.Add(dash)
+	}
+
+	if config.Distributed.Listen != "" {
+		if config.Distributed.Token == "" {
+			logg.Warn("No distributed.token set, anyone who can reach the signaling server can use it", "listen", config.Distributed.Listen)
+		}
+		signalServer := signaling.NewServer(config.Distributed.Listen, config.Distributed.Token, logg)
+		if err := signalServer.Start(); err != nil {
+			logg.Error("Error starting the signaling server", "err", err)
+			return err
+		}
+		defer signalServer.Close(context.Background())
+	}
+
+	if config.Distributed.Role == signaling.RoleAnswerer {
+		// the offering agent runs the tests and reports the results
+		config.Logger = logg
+		return client.NewRemoteAnswerer(config).Serve(ctx.Context)
+	}
+
 	if config.Timer.Enabled {
-		ticker := time.NewTicker(time.Duration(config.Timer.Interval) * time.Minute)
-		runTest(logg, config)
-		for {
-			<-ticker.C
-			runTest(logg, config)
+		jobs, err := scheduler.Jobs(&config.Timer)
+		if err != nil {
+			logg.Error("Error parsing timer schedules", "err", err)
+			return err
 		}
+		live := newLiveConfig(ctx, config, logg)
+		sched := scheduler.New(jobs, time.Duration(config.Timer.Jitter)*time.Second, func(runCtx context.Context, job scheduler.Job) {
+			runTest(runCtx, logg.With("schedule", job.Name), job.Apply(live.ForRun()), rep)
+		}, logg)
+		live.sched = sched
+		go live.Watch(ctx.Context)
+
+		sched.Start(ctx.Context)
 	} else {
-		runTest(logg, config)
+		runTest(ctx.Context, logg, config, rep)
 	}
 
 	return nil
 }
 
-func runTest(logg *slog.Logger, config *config.Config) error {
+func runTest(ctx context.Context, logg *slog.Logger, config *config.Config, rep *reporter.Reporter) error {
 	// logg.SetFormatter(&log.JSONFormatter{PrettyPrint: true})
 
 	testRunId := xid.New()
 	testRunStartedAt := time.Now()
 
+	ctx, span := telemetry.Tracer().Start(ctx, "runTest", trace.WithAttributes(
+		attribute.String("iceperf.test_run_id", testRunId.String()),
+	))
+	defer span.End()
+
 	logger := logg.With("testRunId", testRunId)
 
 	// TODO we will make a new client for each ICE Server URL from each provider
 	// get ICE servers and loop them
-	ICEServers, node, err := client.GetIceServers(config, logger, testRunId)
+	ICEServers, node, err := client.GetIceServers(ctx, config, logger, testRunId)
 	if err != nil {
 		logger.Error("Error getting ICE servers", "err", err)
 		//this should be a fatal
@@ -219,6 +311,27 @@ func runTest(logg *slog.Logger, config *config.Config) error {
 	if node != "" {
 		config.NodeID = node
 	}
+	span.SetAttributes(attribute.String("iceperf.node", config.NodeID))
+
+	run := stats.NewRun(testRunId.String(), testRunStartedAt)
+	run.Node = config.NodeID
+	for _, err := range environment.Collect(ctx, config.Environment, run.Environment) {
+		logger.Warn("Error collecting environment info", "err", err)
+	}
+
+	if config.NAT.Enabled {
+		res, err := nat.Probe(ctx, config.NAT.Server, time.Duration(config.NAT.Timeout)*time.Second)
+		if err != nil {
+			logger.Warn("Error probing NAT", "server", res.Server, "err", err)
+			res.Error = err.Error()
+		} else {
+			logger.Info("NAT", "publicIP", res.PublicIP, "mapping", res.Mapping, "filtering", res.Filtering)
+		}
+		run.Environment.NAT = res
+		if run.Environment.PublicIP == "" {
+			run.Environment.PublicIP = res.PublicIP
+		}
+	}
 
 	config.Registry = prometheus.NewRegistry()
 	// pusher := push.New(config.Logging.Loki.URL, "grafanacloud-nimbleape-prom").Gatherer(config.Registry)
@@ -251,72 +364,155 @@ func runTest(logg *slog.Logger, config *config.Config) error {
 	// }
 	// end TEST
 
-	var results []*stats.Stats
+	var resolve func(host string) client.Resolution
+	if config.DNS.AllAddresses {
+		resolve = func(host string) client.Resolution {
+			res := client.Resolve(ctx, host)
+			if res.Err != nil {
+				logger.Warn("Error resolving ICE server host", "host", host, "err", res.Err)
+			}
+			return res
+		}
+	}
+	plan := buildTestPlan(ICEServers, config.Samples, config.Interleave, resolve, config.RelayMatrix)
+	logger.Info("Test plan", "tests", len(plan), "samples", config.Samples, "interleave", config.Interleave)
 
-	for provider, iss := range ICEServers {
-		providerLogger := logger.With("Provider", provider)
+	var peer *signaling.Peer
+	if config.Distributed.Role == signaling.RoleOfferer {
+		// without an answering agent there is nothing to test or report
+		agreed, p, err := agreeRun(ctx, config, testRunId.String(), plan)
+		if err != nil {
+			logger.Error("Error agreeing the ICE servers to test with the answering agent", "url", config.Distributed.URL(), "err", err)
+			return err
+		}
+		plan, peer = agreed, p
+		logger.Info("Answering agent accepted the run", "tests", len(plan))
+	}
 
-		providerLogger.Info("Provider Starting")
+	for _, tc := range plan {
+		is := tc.iceServer
+		providerLogger := logger.With("Provider", tc.provider)
 
-		for _, is := range iss.IceServers {
+		providerLogger.Info("URL is", "url", is, "sample", tc.sample)
 
-			providerLogger.Info("URL is", "url", is)
+		iceServerInfo, err := stun.ParseURI(is.URLs[0])
 
-			iceServerInfo, err := stun.ParseURI(is.URLs[0])
+		if err != nil {
+			providerLogger.Error("Error parsing ICE Server URL", "err", err)
+			continue
+		}
 
-			if err != nil {
-				providerLogger.Error("Error parsing ICE Server URL", "err", err)
-				continue
+		runId := xid.New()
+
+		iceServerLogger := providerLogger.With("iceServerTestRunId", runId,
+			"schemeAndProtocol", iceServerInfo.Scheme.String()+"-"+iceServerInfo.Proto.String(),
+		)
+
+		iceServerLogger.Info("Starting New Client", "iceServerHost", iceServerInfo.Host,
+			"iceServerProtocol", iceServerInfo.Proto.String(),
+			"iceServerPort", iceServerInfo.Port,
+			"iceServerScheme", iceServerInfo.Scheme.String(),
+		)
+		config.Logger = iceServerLogger
+
+		config.WebRTCConfig.ICEServers = []webrtc.ICEServer{is}
+		config.IPFamily = tc.family
+		config.AnswererOverride = nil
+		if tc.answerer != nil {
+			config.AnswererOverride = &webrtc.Configuration{
+				ICEServers:         []webrtc.ICEServer{*tc.answerer},
+				ICETransportPolicy: webrtc.ICETransportPolicyRelay,
 			}
-
-			runId := xid.New()
-
-			iceServerLogger := providerLogger.With("iceServerTestRunId", runId,
-				"schemeAndProtocol", iceServerInfo.Scheme.String()+"-"+iceServerInfo.Proto.String(),
-			)
-
-			iceServerLogger.Info("Starting New Client", "iceServerHost", iceServerInfo.Host,
-				"iceServerProtocol", iceServerInfo.Proto.String(),
-				"iceServerPort", iceServerInfo.Port,
-				"iceServerScheme", iceServerInfo.Scheme.String(),
-			)
-			config.Logger = iceServerLogger
-
-			config.WebRTCConfig.ICEServers = []webrtc.ICEServer{is}
-			//if the ice server is a stun then set the
-			testDuration := 20 * time.Second
-			if iceServerInfo.Scheme == stun.SchemeTypeSTUN || iceServerInfo.Scheme == stun.SchemeTypeSTUNS {
-				config.WebRTCConfig.ICETransportPolicy = webrtc.ICETransportPolicyAll
-				testDuration = 2 * time.Second
-			} else {
-				config.WebRTCConfig.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+		}
+		//if the ice server is a stun then set the
+		testDuration := 20 * time.Second
+		doThroughput := tc.doThroughput
+		config.SoakTest = false
+		if iceServerInfo.Scheme == stun.SchemeTypeSTUN || iceServerInfo.Scheme == stun.SchemeTypeSTUNS {
+			config.WebRTCConfig.ICETransportPolicy = webrtc.ICETransportPolicyAll
+			testDuration = 2 * time.Second
+		} else {
+			config.WebRTCConfig.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+			if peer == nil && config.Soak.Applies(tc.provider) {
+				// keepalives only, the throughput test would swamp them
+				config.SoakTest = true
+				testDuration = time.Duration(config.Soak.Duration) * time.Second
+				doThroughput = false
 			}
+		}
 
-			timer := time.NewTimer(testDuration)
-			close := make(chan struct{})
+		timer := time.NewTimer(testDuration)
+		close := make(chan struct{})
 
-			c, err := client.NewClient(config, iceServerInfo, provider, testRunId, testRunStartedAt, iss.DoThroughput, close)
-			if err != nil {
-				return err
+		var c *client.Client
+		if peer != nil {
+			c, err = client.NewRemoteClient(ctx, config, iceServerInfo, tc.provider, testRunId, testRunStartedAt, doThroughput, close, peer)
+		} else {
+			c, err = client.NewClient(ctx, config, iceServerInfo, tc.provider, testRunId, testRunStartedAt, doThroughput, close)
+		}
+		if err != nil {
+			return err
+		}
+		if config.Samples > 1 {
+			c.Stats.SetSample(tc.sample)
+		}
+		// the URL of a test per address holds the address, so the host is
+		// taken from its resolution
+		host := iceServerInfo.Host
+		if tc.resolution != nil {
+			host = tc.resolution.Host
+			c.Stats.SetDNSLookup(tc.resolution.Duration, tc.resolution.Err)
+			if tc.address != nil {
+				c.Stats.SetResolvedIP(tc.address.String())
 			}
-
-			iceServerLogger.Info("Calling Run()")
-			c.Run()
-			iceServerLogger.Info("Called Run(), waiting for timer", "seconds", testDuration.Seconds())
-			select {
-			case <-close:
-				timer.Stop()
-			case <-timer.C:
+		}
+		c.Stats.SetHost(host)
+		if tc.answerer != nil {
+			if au, err := stun.ParseURI(tc.answerer.URLs[0]); err == nil {
+				c.Stats.SetAnswerer(tc.answererProvider, au.Scheme.String(), au.Proto.String(), au.String())
 			}
-			iceServerLogger.Info("Calling Stop()")
-			c.Stop()
-			<-time.After(1 * time.Second)
-			iceServerLogger.Info("Finished")
-			results = append(results, c.Stats)
 		}
-		providerLogger.Info("Provider Finished")
+		if tc.family != "" {
+			c.Stats.SetAddressFamily(tc.family)
+		} else if tc.address != nil {
+			c.Stats.SetAddressFamily(client.IPFamily(tc.address))
+		}
+
+		iceServerLogger.Info("Calling Run()")
+		c.Run()
+		iceServerLogger.Info("Called Run(), waiting for timer", "seconds", testDuration.Seconds())
+		select {
+		case <-close:
+			timer.Stop()
+		case <-timer.C:
+		}
+		iceServerLogger.Info("Calling Stop()")
+		c.Stop()
+		<-time.After(1 * time.Second)
+		c.EndTrace()
+		telemetry.RecordStats(ctx, c.Stats)
+		iceServerLogger.Info("Finished")
+		run.AddResult(c.Stats)
+		rep.AddResult(ctx, run, c.Stats)
 	}
 
+	if peer != nil {
+		if err := peer.Send(ctx, signaling.Message{Type: signaling.TypeEnd}); err != nil {
+			logger.Error("Error ending the run with the answering agent", "err", err)
+		}
+	}
+
+	for _, s := range run.FlagAddressMismatches() {
+		logger.Warn("Address failed while another address of the same host connected",
+			"provider", s.Provider, "host", s.Host, "address", s.ResolvedIP,
+			"family", client.IPFamily(net.ParseIP(s.ResolvedIP)), "url", s.URL)
+	}
+	if config.Samples > 1 {
+		run.Aggregate()
+	}
+	run.Finish()
+	rep.Finish(ctx, run)
+
 	logger.Info("Finished Test Run")
 
 	// c, err := client.NewClient(config)
@@ -394,64 +590,61 @@ func runTest(logg *slog.Logger, config *config.Config) error {
 	// 	logger.Info("Wrote stats to prom")
 
 	// }
-	// if !config.Logging.Loki.Enabled && !config.Logging.API.Enabled {
-	headerFmt := color.New(color.FgGreen, color.Underline).SprintfFunc()
-	columnFmt := color.New(color.FgYellow).SprintfFunc()
-
-	tbl := table.New("Provider", "Scheme", "Protocol", "Time to candidate", "Time to Connected State", "Max Throughput", "TURN Transfer Latency")
-	tbl.WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt)
-
-	for _, st := range results {
-		tbl.AddRow(st.Provider, st.Scheme, st.Protocol, st.OffererTimeToReceiveCandidate, st.TimeToConnectedState, st.ThroughputMax, st.LatencyFirstPacket)
-	}
-
-	tbl.Print()
-	//}
 	return nil
 }
 
 func getConfig(c *cli.Context) (*config.Config, error) {
+	conf, _, err := loadConfig(c)
+	return conf, err
+}
+
+// loadConfig builds the config from the defaults, the config file, the API,
+// the environment and the CLI flags, and reports where each setting came from
+func loadConfig(c *cli.Context) (*config.Config, config.Sources, error) {
 	configBody := ""
 	configFile := c.String("config")
 	if configFile != "" {
 		content, err := os.ReadFile(configFile)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		configBody = string(content)
 	}
 
-	conf, err := config.NewConfig(configBody)
+	file, err := config.FileLayer(configBody)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	env, err := config.EnvLayer(os.Environ())
+	if err != nil {
+		return nil, nil, err
 	}
 
+	return config.Load(file, env, flagsLayer(c))
+}
+
+// flagsLayer holds the settings given as CLI flags
+func flagsLayer(c *cli.Context) config.Layer {
+	api := map[string]any{}
 	//if we got passed in the api host and the api key then overwrite the config
 	//same for timer mode
 	if c.String("api-uri") != "" {
-		conf.Api.Enabled = true
-		conf.Api.URI = c.String("api-uri")
+		api["enabled"] = true
+		api["uri"] = c.String("api-uri")
 	}
-
 	if c.String("api-key") != "" {
-		conf.Api.Enabled = true
-		conf.Api.ApiKey = c.String("api-key")
+		api["enabled"] = true
+		api["api_key"] = c.String("api-key")
 	}
 
-	if conf.Api.Enabled && conf.Api.URI == "" {
-		conf.Api.URI = "https://api.iceperf.com/api/settings"
+	values := map[string]any{}
+	if len(api) > 0 {
+		values["api"] = api
 	}
-
 	if c.Bool("timer") {
-		conf.Timer.Enabled = true
-		conf.Timer.Interval = 60
-	}
-
-	if conf.Api.Enabled && conf.Api.ApiKey != "" && conf.Api.URI != "" {
-		conf.UpdateConfigFromApi()
+		values["timer"] = map[string]any{"enabled": true}
 	}
-
-	return conf, nil
+	return config.Layer{Source: config.SourceFlag, Values: values}
 }
 
 // func setLogLevel(logger *log.Logger, level string) {
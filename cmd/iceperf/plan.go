@@ -0,0 +1,201 @@
+package main
+
+import (
+	"net"
+	"sort"
+
+	"github.com/nimbleape/iceperf-agent/adapters"
+	"github.com/nimbleape/iceperf-agent/client"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/pion/stun/v2"
+	"github.com/pion/webrtc/v4"
+)
+
+// testCase is a single ICE server test within a run
+type testCase struct {
+	provider     string
+	iceServer    webrtc.ICEServer
+	doThroughput bool
+	// sample counts from 1 up to the configured number of samples
+	sample int
+	// resolution is the lookup of the ICE server's host, nil when hosts
+	// aren't resolved by the agent
+	resolution *client.Resolution
+	// address is the address tested when the ICE server URL was expanded
+	// into one test per address, or the first address resolved otherwise
+	address net.IP
+	// family limits the test to ipv4 or ipv6, empty for both
+	family string
+	// answerer is the TURN server the answerer relays through in a
+	// relay-to-relay test, from answererProvider
+	answerer         *webrtc.ICEServer
+	answererProvider string
+}
+
+// filterFamily returns the addresses of the given family, or all of them
+// when family is empty
+func filterFamily(addresses []net.IP, family string) []net.IP {
+	if family == "" {
+		return addresses
+	}
+	var filtered []net.IP
+	for _, a := range addresses {
+		if client.IPFamily(a) == family {
+			filtered = append(filtered, a)
+		}
+	}
+	return filtered
+}
+
+// buildTestPlan lists the tests for a run in the order they are executed.
+// Each ICE server is tested samples times, either back to back or, when
+// interleave is set, one round of every ICE server at a time so that no
+// provider is always tested at the same point in the run.
+//
+// With resolve set, the host of every ICE server is looked up once and
+// STUN and TURN URLs are expanded into a test per address. STUNS and TURNS
+// URLs keep the host name, as the server certificate is checked against it.
+// Providers with ip_families are tested once per family, on the addresses of
+// that family.
+//
+// The relay-to-relay tests of the relay matrix follow the other tests.
+func buildTestPlan(iceServers map[string]adapters.IceServersConfig, samples int, interleave bool, resolve func(host string) client.Resolution, matrix config.RelayMatrixConfig) []testCase {
+	if samples < 1 {
+		samples = 1
+	}
+
+	providers := make([]string, 0, len(iceServers))
+	for provider := range iceServers {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+
+	resolved := map[string]*client.Resolution{}
+	var servers []testCase
+	for _, provider := range providers {
+		iss := iceServers[provider]
+		families := iss.IPFamilies
+		if len(families) == 0 {
+			families = []string{""}
+		}
+		for _, is := range iss.IceServers {
+			for _, family := range families {
+				tc := testCase{
+					provider:     provider,
+					iceServer:    is,
+					doThroughput: iss.DoThroughput,
+					family:       family,
+				}
+				u, err := stun.ParseURI(is.URLs[0])
+				if resolve == nil || err != nil {
+					servers = append(servers, tc)
+					continue
+				}
+
+				res, ok := resolved[u.Host]
+				if !ok {
+					r := resolve(u.Host)
+					res = &r
+					resolved[u.Host] = res
+				}
+				tc.resolution = res
+				addresses := filterFamily(res.Addresses, family)
+				if len(addresses) == 0 || u.IsSecure() || net.ParseIP(u.Host) != nil {
+					if len(addresses) > 0 {
+						tc.address = addresses[0]
+					}
+					servers = append(servers, tc)
+					continue
+				}
+				for _, addr := range addresses {
+					atc := tc
+					atc.iceServer.URLs = []string{client.AddressURL(u, addr)}
+					atc.address = addr
+					servers = append(servers, atc)
+				}
+			}
+		}
+	}
+
+	servers = append(servers, relayMatrixTests(iceServers, matrix)...)
+
+	plan := make([]testCase, 0, len(servers)*samples)
+	if interleave {
+		for sample := 1; sample <= samples; sample++ {
+			for _, tc := range servers {
+				tc.sample = sample
+				plan = append(plan, tc)
+			}
+		}
+	} else {
+		for _, tc := range servers {
+			for sample := 1; sample <= samples; sample++ {
+				tc.sample = sample
+				plan = append(plan, tc)
+			}
+		}
+	}
+	return plan
+}
+
+// relayMatrixTests pairs every TURN server of each offering provider with
+// the answering provider's first TURN server of the same scheme and
+// protocol, or with itself when both are the same provider
+func relayMatrixTests(iceServers map[string]adapters.IceServersConfig, matrix config.RelayMatrixConfig) []testCase {
+	pairs := matrix.Pairs
+	if matrix.Same {
+		var same []config.RelayPair
+		for provider := range iceServers {
+			same = append(same, config.RelayPair{Offerer: provider, Answerer: provider})
+		}
+		sort.Slice(same, func(i, j int) bool { return same[i].Offerer < same[j].Offerer })
+		pairs = append(same, pairs...)
+	}
+
+	var tests []testCase
+	for _, pair := range pairs {
+		offerer, ok := iceServers[pair.Offerer]
+		if !ok {
+			continue
+		}
+		answerer, ok := iceServers[pair.Answerer]
+		if !ok {
+			continue
+		}
+		for _, is := range offerer.IceServers {
+			u, err := stun.ParseURI(is.URLs[0])
+			if err != nil || (u.Scheme != stun.SchemeTypeTURN && u.Scheme != stun.SchemeTypeTURNS) {
+				continue
+			}
+			tc := testCase{
+				provider:         pair.Offerer,
+				iceServer:        is,
+				doThroughput:     offerer.DoThroughput,
+				answererProvider: pair.Answerer,
+			}
+			if pair.Offerer == pair.Answerer {
+				is := is
+				tc.answerer = &is
+			} else {
+				tc.answerer = matchingServer(answerer.IceServers, u)
+			}
+			if tc.answerer != nil {
+				tests = append(tests, tc)
+			}
+		}
+	}
+	return tests
+}
+
+// matchingServer returns the first ICE server with the same scheme and
+// protocol as u
+func matchingServer(servers []webrtc.ICEServer, u *stun.URI) *webrtc.ICEServer {
+	for _, is := range servers {
+		au, err := stun.ParseURI(is.URLs[0])
+		if err == nil && au.Scheme == u.Scheme && au.Proto == u.Proto {
+			is := is
+			return &is
+		}
+	}
+	return nil
+}
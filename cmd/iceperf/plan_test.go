@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/adapters"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/signaling"
+	"github.com/pion/webrtc/v4"
+)
+
+func servers(urls ...string) []webrtc.ICEServer {
+	var is []webrtc.ICEServer
+	for _, u := range urls {
+		is = append(is, webrtc.ICEServer{URLs: []string{u}})
+	}
+	return is
+}
+
+func TestRelayMatrixTests(t *testing.T) {
+	iceServers := map[string]adapters.IceServersConfig{
+		"metered": {IceServers: servers(
+			"stun:stun.metered.example:3478",
+			"turn:turn.metered.example:3478?transport=udp",
+			"turns:turn.metered.example:443?transport=tcp",
+		)},
+		"cloudflare": {IceServers: servers(
+			"turn:turn.cloudflare.example:3478?transport=udp",
+		)},
+	}
+
+	tests := relayMatrixTests(iceServers, config.RelayMatrixConfig{
+		Same:  true,
+		Pairs: []config.RelayPair{{Offerer: "metered", Answerer: "cloudflare"}},
+	})
+
+	var got [][3]string
+	for _, tc := range tests {
+		got = append(got, [3]string{tc.provider, tc.iceServer.URLs[0], tc.answererProvider + " " + tc.answerer.URLs[0]})
+	}
+	assert.Equal(t, [][3]string{
+		{"cloudflare", "turn:turn.cloudflare.example:3478?transport=udp", "cloudflare turn:turn.cloudflare.example:3478?transport=udp"},
+		{"metered", "turn:turn.metered.example:3478?transport=udp", "metered turn:turn.metered.example:3478?transport=udp"},
+		{"metered", "turns:turn.metered.example:443?transport=tcp", "metered turns:turn.metered.example:443?transport=tcp"},
+		// cloudflare has no turns over tcp to pair with
+		{"metered", "turn:turn.metered.example:3478?transport=udp", "cloudflare turn:turn.cloudflare.example:3478?transport=udp"},
+	}, got)
+}
+
+func TestBuildTestPlanSamples(t *testing.T) {
+	iceServers := map[string]adapters.IceServersConfig{
+		"a": {IceServers: servers("turn:a.example:3478?transport=udp", "turn:a.example:3478?transport=tcp")},
+	}
+
+	plan := buildTestPlan(iceServers, 2, true, nil, config.RelayMatrixConfig{})
+	assert.Equal(t, 4, len(plan))
+	assert.Equal(t, "turn:a.example:3478?transport=tcp", plan[1].iceServer.URLs[0])
+	assert.Equal(t, 1, plan[1].sample)
+	assert.Equal(t, 2, plan[2].sample)
+}
+
+func TestAgreedTests(t *testing.T) {
+	s := servers(
+		"turn:turn.metered.example:3478?transport=udp",
+		"turn:turn.cloudflare.example:3478?transport=udp",
+	)
+	plan := []testCase{
+		{provider: "metered", iceServer: s[0], sample: 1},
+		{provider: "cloudflare", iceServer: s[1], sample: 1},
+		{provider: "metered", iceServer: s[0], sample: 2},
+	}
+
+	cc := &config.Config{}
+	offered := planICEServers(plan, cc)
+	assert.Equal(t, 2, len(offered))
+	assert.Equal(t, "metered", offered[0].Provider)
+	assert.Equal(t, "turn:turn.metered.example:3478?transport=udp", offered[0].URL)
+
+	agreed := agreedTests(plan, offered[:1], cc)
+	assert.Equal(t, 2, len(agreed))
+	assert.Equal(t, 2, agreed[1].sample)
+	assert.Equal(t, 0, len(agreedTests(plan, nil, cc)))
+
+	// the answerer's own ICE servers are offered, and must be accepted too
+	cc.Answerer = config.AnswererConfig{Mode: config.AnswererCustom, ICEServers: []config.ICEServerConfig{
+		{URLs: []string{"turn:answerer.example:3478?transport=udp"}},
+	}}
+	offered = planICEServers(plan, cc)
+	assert.Equal(t, 4, len(offered))
+	assert.Equal(t, signaling.ICEServer{Provider: "metered", URL: "turn:answerer.example:3478?transport=udp"}, offered[1])
+	assert.Equal(t, 0, len(agreedTests(plan, offered[:1], cc)))
+	assert.Equal(t, 2, len(agreedTests(plan, offered[:2], cc)))
+}
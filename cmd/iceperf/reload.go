@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/scheduler"
+	"github.com/urfave/cli/v2"
+)
+
+// configPollInterval is how often the config file is checked for changes
+const configPollInterval = 5 * time.Second
+
+// restartOnly are the settings that are only read at startup
+var restartOnly = []string{"logging.", "sinks", "notifications.", "history.", "dashboard.", "timer.enabled"}
+
+// liveConfig holds the config used for the next run and reloads it when the
+// config file changes, on SIGHUP, and from the API before each run
+type liveConfig struct {
+	cli    *cli.Context
+	logger *slog.Logger
+	// sched is updated when the timer settings change
+	sched *scheduler.Scheduler
+
+	mu      sync.Mutex
+	loaded  *config.Config
+	path    string
+	modTime time.Time
+}
+
+func newLiveConfig(c *cli.Context, conf *config.Config, logger *slog.Logger) *liveConfig {
+	l := &liveConfig{
+		cli:    c,
+		logger: logger.With("component", "config"),
+		loaded: conf,
+		path:   c.String("config"),
+	}
+	l.modTime = l.fileModTime()
+	return l
+}
+
+// ForRun returns a copy of the current config for a single run. Runs change
+// some settings as they go, so each gets its own copy and a reload never
+// affects a run in progress.
+func (l *liveConfig) ForRun() *config.Config {
+	if l.current().Api.Enabled {
+		l.Reload("api")
+	}
+	cc := *l.current()
+	return &cc
+}
+
+func (l *liveConfig) current() *config.Config {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.loaded
+}
+
+// Reload loads the config again, keeping the current one if the new one
+// can't be loaded or isn't valid
+func (l *liveConfig) Reload(reason string) {
+	logger := l.logger.With("reason", reason)
+
+	l.mu.Lock()
+	l.modTime = l.fileModTime()
+	l.mu.Unlock()
+
+	// loading can fetch the API settings, so it's done without holding the
+	// lock a run needs to get its config
+	conf, err := getConfig(l.cli)
+	if err != nil {
+		logger.Error("Error reloading config, keeping the current config", "err", err)
+		return
+	}
+	// the API settings would be dropped rather than reloaded
+	if conf.ApiError != nil {
+		logger.Warn("The API settings couldn't be fetched, keeping the current config", "uri", conf.Api.URI, "err", conf.ApiError)
+		return
+	}
+	if err := conf.Validate(); err != nil {
+		logger.Error("Reloaded config is invalid, keeping the current config", "err", err)
+		return
+	}
+	jobs, err := scheduler.Jobs(&conf.Timer)
+	if err != nil {
+		logger.Error("Reloaded config is invalid, keeping the current config", "err", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	changes := config.Diff(l.loaded, conf)
+	timerChanged := false
+	for _, ch := range changes {
+		if needsRestart(ch.Path) {
+			logger.Warn("Config changed, restart the agent to apply it", "change", ch.String())
+		} else {
+			logger.Info("Config changed", "change", ch.String())
+		}
+		if strings.HasPrefix(ch.Path, "timer.") && !needsRestart(ch.Path) {
+			timerChanged = true
+		}
+	}
+	if len(changes) > 0 {
+		logger.Info("Config reloaded, the changes apply from the next run", "changes", len(changes))
+	}
+	if timerChanged && l.sched != nil {
+		l.sched.Update(jobs, time.Duration(conf.Timer.Jitter)*time.Second)
+	}
+	l.loaded = conf
+}
+
+// Watch reloads the config when the file changes or on SIGHUP, until ctx is
+// cancelled
+func (l *liveConfig) Watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			l.Reload("SIGHUP")
+		case <-ticker.C:
+			l.mu.Lock()
+			changed := l.path != "" && !l.fileModTime().Equal(l.modTime)
+			l.mu.Unlock()
+			if changed {
+				l.Reload("file changed")
+			}
+		}
+	}
+}
+
+func (l *liveConfig) fileModTime() time.Time {
+	if l.path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func needsRestart(path string) bool {
+	for _, prefix := range restartOnly {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nimbleape/iceperf-agent/signaling"
+	"github.com/urfave/cli/v2"
+)
+
+var signalCommand = &cli.Command{
+	Name:  "signal",
+	Usage: "Run a signaling server for distributed tests between agents",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "listen",
+			Value: ":9090",
+			Usage: "Address to listen on",
+		},
+		&cli.StringFlag{
+			Name:    "token",
+			Usage:   "Token the agents must send, as distributed.token in their config",
+			EnvVars: []string{"ICEPERF_DISTRIBUTED_TOKEN"},
+		},
+	},
+	Action: runSignal,
+}
+
+func runSignal(c *cli.Context) error {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	if c.String("token") == "" {
+		logger.Warn("No token set, anyone who can reach the server can use it")
+	}
+
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s := signaling.NewServer(c.String("listen"), c.String("token"), logger)
+	if err := s.Start(); err != nil {
+		return err
+	}
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.Close(shutdownCtx)
+}
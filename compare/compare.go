@@ -0,0 +1,219 @@
+package compare
+
+import (
+	"sort"
+
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+// Status of a metric between two sets of results
+const (
+	StatusOK         = "ok"
+	StatusImproved   = "improved"
+	StatusRegression = "regression"
+	// StatusMissing means one side has no connected results to compare
+	StatusMissing = "missing"
+)
+
+// Options control when a change counts as a regression
+type Options struct {
+	// ThresholdPercent is the smallest change, in the worse direction, that
+	// is flagged
+	ThresholdPercent float64
+	// Alpha is the significance level for Welch's t-test. When both sides
+	// have at least two samples, changes are only flagged when the p-value
+	// is below it; otherwise the threshold alone decides.
+	Alpha float64
+}
+
+// Delta is the change in one metric for one ICE server URL and address family
+type Delta struct {
+	Provider      string  `json:"provider"`
+	Scheme        string  `json:"scheme"`
+	Protocol      string  `json:"protocol"`
+	URL           string  `json:"url,omitempty"`
+	AddressFamily string  `json:"addressFamily,omitempty"`
+	AnswererURL   string  `json:"answererUrl,omitempty"`
+	Metric        string  `json:"metric"`
+	BeforeSamples int     `json:"beforeSamples"`
+	AfterSamples  int     `json:"afterSamples"`
+	Before        float64 `json:"before"`
+	After         float64 `json:"after"`
+	ChangePercent float64 `json:"changePercent"`
+	// PValue is from Welch's t-test, or -1 when there weren't enough samples
+	PValue float64 `json:"pValue"`
+	Status string  `json:"status"`
+}
+
+type metric struct {
+	name        string
+	higherIsBad bool
+	value       func(*stats.Stats) (float64, bool)
+}
+
+var metrics = []metric{
+	{"time_to_candidate_ms", true, func(st *stats.Stats) (float64, bool) {
+		return st.OffererTimeToReceiveCandidate, st.Connected
+	}},
+	{"time_to_connected_ms", true, func(st *stats.Stats) (float64, bool) {
+		return float64(st.TimeToConnectedState), st.Connected
+	}},
+	{"throughput_max_mbps", false, func(st *stats.Stats) (float64, bool) {
+		return st.ThroughputMax, st.Connected && len(st.Throughput) > 0
+	}},
+	{"latency_first_packet_ms", true, func(st *stats.Stats) (float64, bool) {
+		return st.LatencyFirstPacket, st.Connected && st.LatencyFirstPacket > 0
+	}},
+	{"success_rate", false, nil},
+}
+
+type group struct {
+	provider, scheme, protocol string
+	url, family, answererURL   string
+	before, after              []*stats.Stats
+}
+
+// Compare returns the change in every metric for each URL and address family
+// found in either set of results, grouped as stats.AggregateResults does
+func Compare(before, after []*stats.Stats, opts Options) []*Delta {
+	groups := make(map[string]*group)
+	add := func(st *stats.Stats, isAfter bool) {
+		key := st.Provider + "|" + st.URL + "|" + st.AddressFamily + "|" + st.AnswererURL
+		g, ok := groups[key]
+		if !ok {
+			g = &group{
+				provider:    st.Endpoints(),
+				scheme:      st.Scheme,
+				protocol:    st.Protocol,
+				url:         st.URL,
+				family:      st.AddressFamily,
+				answererURL: st.AnswererURL,
+			}
+			groups[key] = g
+		}
+		if isAfter {
+			g.after = append(g.after, st)
+		} else {
+			g.before = append(g.before, st)
+		}
+	}
+	for _, st := range before {
+		add(st, false)
+	}
+	for _, st := range after {
+		add(st, true)
+	}
+
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var deltas []*Delta
+	for _, k := range keys {
+		g := groups[k]
+		for _, m := range metrics {
+			var b, a []float64
+			if m.value == nil {
+				b, a = successValues(g.before), successValues(g.after)
+			} else {
+				b, a = values(g.before, m), values(g.after, m)
+			}
+			d := &Delta{
+				Provider:      g.provider,
+				Scheme:        g.scheme,
+				Protocol:      g.protocol,
+				URL:           g.url,
+				AddressFamily: g.family,
+				AnswererURL:   g.answererURL,
+				Metric:        m.name,
+				BeforeSamples: len(b),
+				AfterSamples:  len(a),
+				PValue:        -1,
+			}
+			evaluate(d, b, a, m.higherIsBad, opts)
+			deltas = append(deltas, d)
+		}
+	}
+	return deltas
+}
+
+// Regressions returns only the deltas flagged as regressions
+func Regressions(deltas []*Delta) []*Delta {
+	var out []*Delta
+	for _, d := range deltas {
+		if d.Status == StatusRegression {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+func values(results []*stats.Stats, m metric) []float64 {
+	var v []float64
+	for _, st := range results {
+		if x, ok := m.value(st); ok {
+			v = append(v, x)
+		}
+	}
+	return v
+}
+
+// successValues is 1 for each test that connected and 0 otherwise, so the
+// mean is the success rate
+func successValues(results []*stats.Stats) []float64 {
+	var v []float64
+	for _, st := range results {
+		if st.Connected {
+			v = append(v, 1)
+		} else {
+			v = append(v, 0)
+		}
+	}
+	return v
+}
+
+func evaluate(d *Delta, before, after []float64, higherIsBad bool, opts Options) {
+	if len(before) == 0 || len(after) == 0 {
+		d.Status = StatusMissing
+		return
+	}
+
+	d.Before = stats.Mean(before)
+	d.After = stats.Mean(after)
+	if d.Before != 0 {
+		d.ChangePercent = (d.After - d.Before) / d.Before * 100
+	} else if d.After != 0 {
+		d.ChangePercent = 100
+	}
+
+	// Welch's t-test needs two samples a side, with fewer only the threshold
+	// is checked
+	significant := true
+	if len(before) >= 2 && len(after) >= 2 {
+		d.PValue = WelchTTest(before, after)
+		significant = d.PValue < opts.Alpha
+	}
+
+	worse := d.ChangePercent > 0
+	if !higherIsBad {
+		worse = d.ChangePercent < 0
+	}
+	change := d.ChangePercent
+	if change < 0 {
+		change = -change
+	}
+
+	switch {
+	case d.Metric == "success_rate" && d.Before > 0 && d.After == 0:
+		// a URL that stops connecting is always a regression
+		d.Status = StatusRegression
+	case change < opts.ThresholdPercent || !significant:
+		d.Status = StatusOK
+	case worse:
+		d.Status = StatusRegression
+	default:
+		d.Status = StatusImproved
+	}
+}
@@ -0,0 +1,117 @@
+package compare
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+func result(provider string, connected bool, timeToConnected int64) *stats.Stats {
+	st := stats.NewStats("run", time.Now())
+	st.SetProvider(provider)
+	st.SetScheme("turn")
+	st.SetProtocol("udp")
+	st.SetTimeToConnectedState(timeToConnected)
+	st.Connected = connected
+	return st
+}
+
+func find(deltas []*Delta, provider, metric string) *Delta {
+	for _, d := range deltas {
+		if d.Provider == provider && d.Metric == metric {
+			return d
+		}
+	}
+	return nil
+}
+
+func TestWelchTTest(t *testing.T) {
+	// first example from the Wikipedia article on Welch's t-test, p = 0.021
+	a := []float64{27.5, 21.0, 19.0, 23.6, 17.0, 17.9, 16.9, 20.1, 21.9, 22.6, 23.1, 19.6, 19.0, 21.7, 21.4}
+	b := []float64{27.1, 22.0, 20.8, 23.4, 23.4, 23.5, 25.8, 22.0, 24.8, 20.2, 21.9, 22.1, 22.9, 20.5, 24.4}
+	assert.True(t, math.Abs(WelchTTest(a, b)-0.021) < 0.001)
+
+	assert.Equal(t, 1.0, WelchTTest([]float64{5, 5}, []float64{5, 5}))
+}
+
+func TestCompareFlagsRegressions(t *testing.T) {
+	var before, after []*stats.Stats
+	for i := 0; i < 5; i++ {
+		before = append(before, result("a", true, 100+int64(i)), result("b", true, 100+int64(i)))
+		after = append(after, result("a", true, 200+int64(i)), result("b", true, 80+int64(i)))
+	}
+	after = append(after, result("a", false, 0))
+
+	deltas := Compare(before, after, Options{ThresholdPercent: 10, Alpha: 0.05})
+
+	d := find(deltas, "a", "time_to_connected_ms")
+	assert.Equal(t, StatusRegression, d.Status)
+	assert.True(t, d.PValue >= 0 && d.PValue < 0.05)
+
+	assert.Equal(t, StatusImproved, find(deltas, "b", "time_to_connected_ms").Status)
+	assert.Equal(t, StatusOK, find(deltas, "b", "success_rate").Status)
+	assert.Equal(t, StatusMissing, find(deltas, "a", "throughput_max_mbps").Status)
+
+	// one failure in six is a big drop, but not a significant one
+	d = find(deltas, "a", "success_rate")
+	assert.True(t, d.ChangePercent < -10)
+	assert.Equal(t, StatusOK, d.Status)
+}
+
+func TestCompareSingleRuns(t *testing.T) {
+	before := []*stats.Stats{result("a", true, 100), result("b", true, 100), result("c", true, 100)}
+	after := []*stats.Stats{result("a", true, 500), result("b", true, 105), result("c", false, 0)}
+
+	deltas := Compare(before, after, Options{ThresholdPercent: 10, Alpha: 0.05})
+
+	// without enough samples for the t-test the threshold decides
+	d := find(deltas, "a", "time_to_connected_ms")
+	assert.Equal(t, 400.0, d.ChangePercent)
+	assert.Equal(t, -1.0, d.PValue)
+	assert.Equal(t, StatusRegression, d.Status)
+	assert.Equal(t, StatusOK, find(deltas, "b", "time_to_connected_ms").Status)
+
+	d = find(deltas, "c", "success_rate")
+	assert.Equal(t, -100.0, d.ChangePercent)
+	assert.Equal(t, StatusRegression, d.Status)
+}
+
+func TestCompareFlagsStoppedConnecting(t *testing.T) {
+	var before, after []*stats.Stats
+	for i := 0; i < 5; i++ {
+		before = append(before, result("a", true, 100), result("b", true, 100))
+		after = append(after, result("a", false, 0), result("b", false, 0))
+	}
+	after = append(after, result("b", true, 100))
+
+	// the threshold is never reached, but a URL that stops connecting is
+	// flagged anyway, and one that still connects sometimes isn't
+	deltas := Compare(before, after, Options{ThresholdPercent: 1000, Alpha: 0.05})
+	assert.Equal(t, StatusRegression, find(deltas, "a", "success_rate").Status)
+	assert.Equal(t, StatusOK, find(deltas, "b", "success_rate").Status)
+}
+
+func TestCompareGroupsByURLAndFamily(t *testing.T) {
+	at := func(family string, timeToConnected int64) *stats.Stats {
+		st := result("a", true, timeToConnected)
+		st.SetURL("turn:a.example.com:3478?transport=udp")
+		st.SetAddressFamily(family)
+		return st
+	}
+	var before, after []*stats.Stats
+	for i := int64(0); i < 5; i++ {
+		before = append(before, at("ipv4", 100+i), at("ipv6", 100+i))
+		after = append(after, at("ipv4", 100+i), at("ipv6", 300+i))
+	}
+
+	byFamily := map[string]string{}
+	for _, d := range Compare(before, after, Options{ThresholdPercent: 10, Alpha: 0.05}) {
+		if d.Metric == "time_to_connected_ms" {
+			byFamily[d.AddressFamily] = d.Status
+		}
+	}
+	assert.Equal(t, map[string]string{"ipv4": StatusOK, "ipv6": StatusRegression}, byFamily)
+}
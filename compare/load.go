@@ -0,0 +1,44 @@
+package compare
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+// LoadFile reads results from a JSON file. It accepts an array of stats, a
+// single run document, or run documents as JSON lines as written by the file
+// sink.
+func LoadFile(path string) ([]*stats.Stats, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("empty results file " + path)
+	}
+
+	if data[0] == '[' {
+		var results []*stats.Stats
+		if err := json.Unmarshal(data, &results); err != nil {
+			return nil, err
+		}
+		return results, nil
+	}
+
+	var results []*stats.Stats
+	dec := json.NewDecoder(bufio.NewReader(bytes.NewReader(data)))
+	for dec.More() {
+		var run stats.Run
+		if err := dec.Decode(&run); err != nil {
+			return nil, err
+		}
+		results = append(results, run.Results...)
+	}
+	return results, nil
+}
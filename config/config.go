@@ -1,12 +1,14 @@
 package config
 
 import (
-	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"reflect"
+	"time"
 
 	"github.com/pion/webrtc/v4"
 	"github.com/prometheus/client_golang/prometheus"
@@ -30,8 +32,132 @@ type ICEConfig struct {
 	StunEnabled       bool             `yaml:"stun_enabled"`
 	TurnEnabled       bool             `yaml:"turn_enabled"`
 	DoThroughput      bool             `yaml:"do_throughput"`
+
+	// StunHosts and TurnHosts add more hosts for generic providers, each
+	// tested on every port. Names starting with _ are SRV records, e.g.
+	// _turn._udp.example.com, tested on the hosts and ports they list.
+	StunHosts []string `json:"stunHosts,omitempty" yaml:"stun_hosts,omitempty"`
+	TurnHosts []string `json:"turnHosts,omitempty" yaml:"turn_hosts,omitempty"`
+	// IPFamilies runs every test once per family, ipv4 and/or ipv6, with
+	// the offerer limited to that family. Empty uses both at once.
+	IPFamilies []string `json:"ipFamilies,omitempty" yaml:"ip_families,omitempty"`
+	// SharedSecret mints TURN REST API credentials for generic providers,
+	// as used by coturn's use-auth-secret, eturnal and STUNner
+	SharedSecret string `json:"sharedSecret,omitempty" yaml:"shared_secret,omitempty"`
+	// TTL is how long minted credentials are valid for, in seconds
+	TTL int `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+	// UsernameSuffix is appended to the expiry time in minted usernames
+	UsernameSuffix string `json:"usernameSuffix,omitempty" yaml:"username_suffix,omitempty"`
+	// Answerer overrides the global answerer config for this provider
+	Answerer *AnswererConfig `json:"answerer,omitempty" yaml:"answerer,omitempty"`
+}
+
+// ICEServerConfig is an ICE server given directly in the config
+type ICEServerConfig struct {
+	URLs       []string `json:"urls" yaml:"urls"`
+	Username   string   `json:"username,omitempty" yaml:"username,omitempty"`
+	Credential string   `json:"credential,omitempty" yaml:"credential,omitempty"`
+}
+
+// AnswererConfig sets up the ICE servers of the answering peer of each test
+type AnswererConfig struct {
+	// Mode is same to use the ICE server under test (the default), none
+	// for host candidates only, or custom to use ICEServers
+	Mode       string            `json:"mode,omitempty" yaml:"mode,omitempty"`
+	ICEServers []ICEServerConfig `json:"iceServers,omitempty" yaml:"ice_servers,omitempty"`
+	// TransportPolicy is all (the default) or relay
+	TransportPolicy string `json:"transportPolicy,omitempty" yaml:"transport_policy,omitempty"`
+}
+
+// RelayPair is a relay-to-relay test between two providers
+type RelayPair struct {
+	Offerer  string `json:"offerer" yaml:"offerer"`
+	Answerer string `json:"answerer" yaml:"answerer"`
+}
+
+// RelayMatrixConfig adds tests with both peers forced to relay. Each TURN
+// server of the offerer's provider is paired with the answerer's TURN server
+// of the same scheme and protocol.
+type RelayMatrixConfig struct {
+	// Same relays both peers through the same TURN server, for every TURN
+	// server tested
+	Same bool `json:"same,omitempty" yaml:"same,omitempty"`
+	// Pairs relays the peers through two different providers or regions
+	Pairs []RelayPair `json:"pairs,omitempty" yaml:"pairs,omitempty"`
+}
+
+// DistributedConfig splits the peers of each test between two agents, e.g.
+// in London and Singapore, which meet in a room on a signaling server
+type DistributedConfig struct {
+	// Role is offerer or answerer. The offerer runs the tests and reports
+	// the results, the answerer only answers. Empty runs both peers here.
+	Role string `json:"role,omitempty" yaml:"role,omitempty"`
+	// SignalingURL is the signaling server, e.g. http://sg.example.com:9090.
+	// Defaults to this agent's own server when Listen is set.
+	SignalingURL string `json:"signalingUrl,omitempty" yaml:"signaling_url,omitempty"`
+	// Listen serves signaling from this agent on this address, e.g. :9090
+	Listen string `json:"listen,omitempty" yaml:"listen,omitempty"`
+	// Room pairs the two agents, defaults to iceperf. Each run then uses a
+	// room named after its test run ID.
+	Room string `json:"room,omitempty" yaml:"room,omitempty"`
+	// Token is shared by the agents and the signaling server, which
+	// rejects requests without it
+	Token string `json:"token,omitempty" yaml:"token,omitempty"`
+	// Providers limits the ICE servers the answerer agrees to test to
+	// these providers, all when empty
+	Providers []string `json:"providers,omitempty" yaml:"providers,omitempty"`
+}
+
+// Distributed roles
+const (
+	RoleOfferer  = "offerer"
+	RoleAnswerer = "answerer"
+)
+
+// URL returns the signaling server to use, the agent's own if it serves one
+// and no other is set
+func (d DistributedConfig) URL() string {
+	if d.SignalingURL != "" || d.Listen == "" {
+		return d.SignalingURL
+	}
+	host, port, err := net.SplitHostPort(d.Listen)
+	if err != nil {
+		return ""
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
 }
 
+// SoakConfig keeps the connection of each TURN test up for much longer than
+// the usual 20 seconds, sending low-rate keepalives, to catch allocation
+// refresh failures, permission expiry and drops that only show up later
+type SoakConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Duration is how long each TURN test runs in seconds, defaults to 1800
+	Duration int `json:"duration,omitempty" yaml:"duration,omitempty"`
+	// Interval is the time between samples in seconds, defaults to 10
+	Interval int `json:"interval,omitempty" yaml:"interval,omitempty"`
+	// Keepalive is the time between keepalive packets in seconds,
+	// defaults to 1
+	Keepalive int `json:"keepalive,omitempty" yaml:"keepalive,omitempty"`
+	// Providers limits soak tests to these providers, all when empty
+	Providers []string `json:"providers,omitempty" yaml:"providers,omitempty"`
+}
+
+// Applies reports whether the TURN tests of a provider are soak tests
+func (s SoakConfig) Applies(provider string) bool {
+	return s.Enabled && (len(s.Providers) == 0 || contains(s.Providers, provider))
+}
+
+// Answerer modes
+const (
+	AnswererSame   = "same"
+	AnswererNone   = "none"
+	AnswererCustom = "custom"
+)
+
 type LokiConfig struct {
 	Enabled        bool              `json:"enabled" yaml:"enabled"`
 	UseBasicAuth   bool              `yaml:"use_basic_auth"`
@@ -48,10 +174,35 @@ type PromConfig struct {
 	AuthHeaders map[string]string `yaml:"auth_headers,omitempty"`
 }
 
+type QueueConfig struct {
+	// Dir holds results waiting to be sent, defaults to a directory in the user cache dir
+	Dir string `json:"dir,omitempty" yaml:"dir,omitempty"`
+	// MaxSizeMB caps the disk used by queued results, the oldest are dropped first
+	MaxSizeMB int `json:"maxSizeMb,omitempty" yaml:"max_size_mb,omitempty"`
+	// MaxBackoff is the longest wait between retries, in seconds
+	MaxBackoff int `json:"maxBackoff,omitempty" yaml:"max_backoff,omitempty"`
+}
+
 type ApiConfig struct {
 	Enabled bool   `json:"enabled" yaml:"enabled"`
 	URI     string `json:"uri" yaml:"uri"`
 	ApiKey  string `json:"apiKey,omitempty" yaml:"api_key,omitempty"`
+	// Mode is either batch (one upload per test run, the default) or stream
+	// (one upload per ICE server test)
+	Mode  string      `json:"mode,omitempty" yaml:"mode,omitempty"`
+	Gzip  bool        `json:"gzip,omitempty" yaml:"gzip,omitempty"`
+	Queue QueueConfig `json:"queue,omitempty" yaml:"queue,omitempty"`
+}
+
+type OTelConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Endpoint is the host:port of the OTLP/HTTP collector, e.g. localhost:4318
+	Endpoint    string            `json:"endpoint" yaml:"endpoint"`
+	Insecure    bool              `json:"insecure" yaml:"insecure"`
+	Headers     map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	ServiceName string            `json:"serviceName,omitempty" yaml:"service_name,omitempty"`
+	// MetricsInterval is how often metrics are exported, in seconds
+	MetricsInterval int `json:"metricsInterval,omitempty" yaml:"metrics_interval,omitempty"`
 }
 
 type LoggingConfig struct {
@@ -59,19 +210,146 @@ type LoggingConfig struct {
 	API        ApiConfig  `json:"api" yaml:"api"`
 	Loki       LokiConfig `json:"loki" yaml:"loki"`
 	Prometheus PromConfig `yaml:"prometheus"`
+	OTel       OTelConfig `json:"otel" yaml:"otel"`
+}
+
+// ScheduleConfig runs tests for some providers on their own schedule, e.g.
+// throughput tests hourly and connectivity checks every 5 minutes
+type ScheduleConfig struct {
+	Name string `json:"name" yaml:"name"`
+	// Cron is a standard 5 field cron expression or a descriptor like @hourly
+	Cron string `json:"cron,omitempty" yaml:"cron,omitempty"`
+	// Every is the time between runs in seconds, used when Cron is empty
+	Every int `json:"every,omitempty" yaml:"every,omitempty"`
+	// Providers limits the schedule to these providers, all when empty
+	Providers []string `json:"providers,omitempty" yaml:"providers,omitempty"`
+	// Throughput overrides do_throughput for the providers in this schedule
+	Throughput *bool `json:"throughput,omitempty" yaml:"throughput,omitempty"`
 }
 
 type TimerConfig struct {
-	Enabled  bool `json:"enabled" yaml:"enabled"`
-	Interval int  `json:"interval" yaml:"interval"`
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Interval is the time between runs in minutes
+	Interval int `json:"interval" yaml:"interval"`
+	// Every is the time between runs in seconds and takes precedence over
+	// Interval
+	Every int `json:"every,omitempty" yaml:"every,omitempty"`
+	// Cron takes precedence over Every and Interval
+	Cron string `json:"cron,omitempty" yaml:"cron,omitempty"`
+	// Jitter delays each run by a random time of up to this many seconds
+	Jitter int `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+	// Schedules replace the single schedule above when set
+	Schedules []ScheduleConfig `json:"schedules,omitempty" yaml:"schedules,omitempty"`
+}
+
+// SinkConfig configures a single result sink. Which fields are used depends
+// on the sink type: file, stdout, log, loki, prometheus or webhook.
+type SinkConfig struct {
+	Type string `json:"type" yaml:"type"`
+	// URL is the push endpoint for the loki, prometheus and webhook sinks
+	URL string `json:"url,omitempty" yaml:"url,omitempty"`
+	// Path is the file the file sink appends to, or a directory to write a
+	// file per test run into
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	// Format is table or json for the stdout sink
+	Format   string            `json:"format,omitempty" yaml:"format,omitempty"`
+	Username string            `json:"username,omitempty" yaml:"username,omitempty"`
+	Password string            `json:"password,omitempty" yaml:"password,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// Labels are added to every stream or series pushed to loki or prometheus
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+// NotifyTarget is somewhere notifications are posted: a generic webhook, a
+// Slack incoming webhook or an MS Teams connector
+type NotifyTarget struct {
+	Type    string            `json:"type" yaml:"type"`
+	URL     string            `json:"url" yaml:"url"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// Template is a Go text/template for the message, see the notify package
+	Template string `json:"template,omitempty" yaml:"template,omitempty"`
+}
+
+// NotifyThresholds are the limits that trigger a notification when a
+// connected test exceeds them. Zero disables a threshold.
+type NotifyThresholds struct {
+	TimeToConnectedMs    float64 `json:"timeToConnectedMs,omitempty" yaml:"time_to_connected_ms,omitempty"`
+	TimeToCandidateMs    float64 `json:"timeToCandidateMs,omitempty" yaml:"time_to_candidate_ms,omitempty"`
+	LatencyFirstPacketMs float64 `json:"latencyFirstPacketMs,omitempty" yaml:"latency_first_packet_ms,omitempty"`
+	ThroughputMinMbps    float64 `json:"throughputMinMbps,omitempty" yaml:"throughput_min_mbps,omitempty"`
+}
+
+type NotificationsConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// FailureThreshold is how many consecutive failed tests mark a
+	// provider/transport as failing, defaults to 1
+	FailureThreshold int `json:"failureThreshold,omitempty" yaml:"failure_threshold,omitempty"`
+	// Cooldown is the minimum time in seconds between two notifications of
+	// the same kind for the same provider/transport, defaults to 3600
+	Cooldown   int              `json:"cooldown,omitempty" yaml:"cooldown,omitempty"`
+	Thresholds NotifyThresholds `json:"thresholds,omitempty" yaml:"thresholds,omitempty"`
+	Targets    []NotifyTarget   `json:"targets,omitempty" yaml:"targets,omitempty"`
+}
+
+type HistoryConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Path is the database file, defaults to iceperf/history.db in the user cache dir
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+}
+
+type DashboardConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Listen is the address the dashboard is served on, defaults to 127.0.0.1:8080
+	Listen string `json:"listen,omitempty" yaml:"listen,omitempty"`
+	// MaxRuns is how many recent runs are kept in memory, defaults to 100
+	MaxRuns int `json:"maxRuns,omitempty" yaml:"max_runs,omitempty"`
+}
+
+type DNSConfig struct {
+	// AllAddresses resolves ICE server hosts and tests every IPv4 and IPv6
+	// address separately, defaults to true. TLS and DTLS servers are still
+	// tested by name, as the certificate is checked against it.
+	AllAddresses bool `json:"allAddresses" yaml:"all_addresses"`
+}
+
+type NATConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Server is a STUN server supporting RFC 5780, defaults to
+	// stun.stunprotocol.org:3478
+	Server string `json:"server,omitempty" yaml:"server,omitempty"`
+	// Timeout is how long to wait for each STUN response in seconds,
+	// defaults to 3
+	Timeout int `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+type EnvironmentConfig struct {
+	// LookupURL returns the agent's public IP and ASN as JSON, e.g.
+	// https://ipinfo.io/json or http://ip-api.com/json. Skipped when empty.
+	LookupURL string `json:"lookupUrl,omitempty" yaml:"lookup_url,omitempty"`
+	// NTPServer is asked for the clock offset, defaults to pool.ntp.org.
+	// Set it to "" to skip the check.
+	NTPServer string `json:"ntpServer,omitempty" yaml:"ntp_server,omitempty"`
 }
 
 type Config struct {
-	NodeID    string               `json:"nodeId" yaml:"node_id"`
-	ICEConfig map[string]ICEConfig `json:"iceServers" yaml:"ice_servers"`
-	Logging   LoggingConfig        `json:"logging" yaml:"logging"`
-	Timer     TimerConfig          `json:"timer" yaml:"timer"`
-	Api       ApiConfig            `json:"api" yaml:"api"`
+	NodeID        string               `json:"nodeId" yaml:"node_id"`
+	ICEConfig     map[string]ICEConfig `json:"iceServers" yaml:"ice_servers"`
+	Logging       LoggingConfig        `json:"logging" yaml:"logging"`
+	Timer         TimerConfig          `json:"timer" yaml:"timer"`
+	Samples       int                  `json:"samples,omitempty" yaml:"samples,omitempty"`
+	Interleave    bool                 `json:"interleave,omitempty" yaml:"interleave,omitempty"`
+	DNS           DNSConfig            `json:"dns" yaml:"dns"`
+	NAT           NATConfig            `json:"nat,omitempty" yaml:"nat,omitempty"`
+	Environment   EnvironmentConfig    `json:"environment,omitempty" yaml:"environment,omitempty"`
+	Answerer      AnswererConfig       `json:"answerer,omitempty" yaml:"answerer,omitempty"`
+	RelayMatrix   RelayMatrixConfig    `json:"relayMatrix,omitempty" yaml:"relay_matrix,omitempty"`
+	Distributed   DistributedConfig    `json:"distributed,omitempty" yaml:"distributed,omitempty"`
+	Soak          SoakConfig           `json:"soak,omitempty" yaml:"soak,omitempty"`
+	Api           ApiConfig            `json:"api" yaml:"api"`
+	Sinks         []SinkConfig         `json:"sinks,omitempty" yaml:"sinks,omitempty"`
+	Notifications NotificationsConfig  `json:"notifications,omitempty" yaml:"notifications,omitempty"`
+	History       HistoryConfig        `json:"history,omitempty" yaml:"history,omitempty"`
+	Dashboard     DashboardConfig      `json:"dashboard,omitempty" yaml:"dashboard,omitempty"`
 
 	WebRTCConfig webrtc.Configuration
 	// TODO the following should be different for answerer and offerer sides
@@ -82,41 +360,24 @@ type Config struct {
 	ServiceName string `yaml:"-"`
 	Logger      *slog.Logger
 	Registry    *prometheus.Registry
+	// IPFamily limits the offerer of the current test to ipv4 or ipv6
+	IPFamily string `yaml:"-"`
+	// AnswererOverride replaces the answerer config for the current test
+	AnswererOverride *webrtc.Configuration `yaml:"-"`
+	// SoakTest runs the current test as a soak test
+	SoakTest bool `yaml:"-"`
+	// ApiError is why the API settings couldn't be fetched, when the config
+	// was loaded without them
+	ApiError error `yaml:"-"`
 }
 
-func mergeConfigs(c, responseConfig interface{}) {
-	mergeStructs(reflect.ValueOf(c).Elem(), reflect.ValueOf(responseConfig).Elem())
-}
-
-func mergeStructs(cValue, respValue reflect.Value) {
-	for i := 0; i < respValue.NumField(); i++ {
-		respField := respValue.Field(i)
-		cField := cValue.Field(i)
-
-		if !respField.IsZero() {
-			switch respField.Kind() {
-			case reflect.Ptr:
-				if !respField.IsNil() {
-					if cField.IsNil() {
-						cField.Set(reflect.New(cField.Type().Elem()))
-					}
-					mergeStructs(cField.Elem(), respField.Elem())
-				}
-			case reflect.Struct:
-				mergeStructs(cField, respField)
-			case reflect.Map:
-				if cField.IsNil() {
-					cField.Set(reflect.MakeMap(cField.Type()))
-				}
-				for _, key := range respField.MapKeys() {
-					val := respField.MapIndex(key)
-					cField.SetMapIndex(key, val)
-				}
-			default:
-				cField.Set(respField)
-			}
-		}
+// AnswererFor returns the answerer config for a provider, its own if it has
+// one, the global one otherwise
+func (c *Config) AnswererFor(provider string) AnswererConfig {
+	if ic, ok := c.ICEConfig[provider]; ok && ic.Answerer != nil {
+		return *ic.Answerer
 	}
+	return c.Answerer
 }
 
 func NewConfig(confString string) (*Config, error) {
@@ -131,43 +392,82 @@ func NewConfig(confString string) (*Config, error) {
 	return c, nil
 }
 
-func (c *Config) UpdateConfigFromApi() error {
-	httpClient := &http.Client{}
+// apiTimeout bounds fetching the API settings, which the agent does before
+// every run when the API is enabled
+const apiTimeout = 30 * time.Second
 
-	req, err := http.NewRequest("GET", c.Api.URI, nil)
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", "Bearer "+c.Api.ApiKey)
+// FetchApiSettings gets the settings for this agent from the API
+func FetchApiSettings(api *ApiConfig) ([]byte, error) {
+	httpClient := &http.Client{Timeout: apiTimeout}
 
+	req, err := http.NewRequest("GET", api.URI, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+api.ApiKey)
 
 	res, err := httpClient.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	defer res.Body.Close()
 	//check the code of the response
 	if res.StatusCode != 200 {
 		err = errors.New("error from our api " + res.Status)
-		return err
+		return nil, err
+	}
+
+	return io.ReadAll(res.Body)
+}
+
+// Build merges the layers into a config, without fetching the API settings.
+// It fails if any layer has settings that don't exist.
+func Build(layers ...Layer) (*Config, Sources, error) {
+	var errs []error
+	for _, l := range layers {
+		for _, err := range checkKnown(l.Values, reflect.TypeOf(Config{}), "") {
+			errs = append(errs, fmt.Errorf("%s: %w", l.Source, err))
+		}
 	}
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+
+	all := append([]Layer{DefaultLayer()}, layers...)
+	sortLayers(all)
 
-	responseData, err := io.ReadAll(res.Body)
+	merged, sources := Merge(all...)
+	conf, err := Decode(merged)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	responseConfig := Config{}
-	json.Unmarshal([]byte(responseData), &responseConfig)
+	return conf, sources, nil
+}
 
-	//go and merge in values from the API into the config
+// Load builds the config from the given layers, each overriding the ones
+// with lower precedence: defaults, file, API, env and then CLI flags. When
+// the API is enabled its settings are fetched and merged in as well, and if
+// it can't be reached the other layers are used and the error is left in
+// ApiError for the caller to warn about once its logger is set up.
+func Load(layers ...Layer) (*Config, Sources, error) {
+	conf, sources, err := Build(layers...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !conf.Api.Enabled || conf.Api.ApiKey == "" || conf.Api.URI == "" {
+		return conf, sources, nil
+	}
 
-	//lets just do the basics for now....
-	//this needs a lot more work
-	c.NodeID = responseConfig.NodeID
-	c.ICEConfig = responseConfig.ICEConfig
-	c.Logging = responseConfig.Logging
-	// mergeConfigs(c, responseConfig)
-	return nil
+	body, err := FetchApiSettings(&conf.Api)
+	if err != nil {
+		conf.ApiError = err
+		return conf, sources, nil
+	}
+	api, err := ApiLayer(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return Build(append(layers, api)...)
 }
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change is a single setting that differs between two configs
+type Change struct {
+	Path string
+	Old  string
+	New  string
+	// Secret is set when the setting shouldn't be logged
+	Secret bool
+}
+
+func (ch Change) String() string {
+	if ch.Secret {
+		return ch.Path + " changed"
+	}
+	return fmt.Sprintf("%s: %q -> %q", ch.Path, ch.Old, ch.New)
+}
+
+// Diff lists the settings that differ between old and new, by path
+func Diff(old, new *Config) []Change {
+	before, after := Flatten(old), Flatten(new)
+
+	paths := make(map[string]bool)
+	for p := range before {
+		paths[p] = true
+	}
+	for p := range after {
+		paths[p] = true
+	}
+
+	var changes []Change
+	for p := range paths {
+		if before[p] != after[p] {
+			secret := IsSecret(p, before) || IsSecret(p, after)
+			changes = append(changes, Change{Path: p, Old: before[p], New: after[p], Secret: secret})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// Flatten returns every setting in c keyed by its dotted YAML path, e.g.
+// ice_servers.cloudflare.api_key. Fields that can't be set from YAML are
+// left out, and so are settings with zero values.
+func Flatten(c *Config) map[string]string {
+	out := make(map[string]string)
+	if c != nil {
+		flatten("", reflect.ValueOf(c).Elem(), out, false)
+	}
+	return out
+}
+
+// FlattenAll is like Flatten but includes settings with zero values
+func FlattenAll(c *Config) map[string]string {
+	out := make(map[string]string)
+	if c != nil {
+		flatten("", reflect.ValueOf(c).Elem(), out, true)
+	}
+	return out
+}
+
+func flatten(prefix string, v reflect.Value, out map[string]string, keepZero bool) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			flatten(prefix, v.Elem(), out, keepZero)
+		} else if keepZero {
+			out[prefix] = ""
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name, ok := yamlName(t.Field(i))
+			if !ok {
+				continue
+			}
+			flatten(join(prefix, name), v.Field(i), out, keepZero)
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		for _, k := range keys {
+			flatten(join(prefix, fmt.Sprint(k.Interface())), v.MapIndex(k), out, keepZero)
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Struct {
+			if keepZero || !v.IsZero() {
+				out[prefix] = fmt.Sprint(v.Interface())
+			}
+			return
+		}
+		// lists of structs are flattened by index, so the secrets in them,
+		// like sink passwords, are found by their path
+		for i := 0; i < v.Len(); i++ {
+			flatten(join(prefix, strconv.Itoa(i)), v.Index(i), out, keepZero)
+		}
+	default:
+		if keepZero || !v.IsZero() {
+			out[prefix] = fmt.Sprint(v.Interface())
+		}
+	}
+}
+
+// yamlName returns the YAML key of a field, or false if it has none
+func yamlName(f reflect.StructField) (string, bool) {
+	if !f.IsExported() {
+		return "", false
+	}
+	tag, ok := f.Tag.Lookup("yaml")
+	if !ok {
+		return "", false
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "-" || name == "" {
+		return "", false
+	}
+	return name, true
+}
+
+func join(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+var secretKeys = []string{"password", "api_key", "secret", "token", "headers", "credential"}
+
+// secretPaths are settings that are secret whatever their name, with * for
+// a list index. Notification target URLs are webhooks with a token in them.
+var secretPaths = []string{"notifications.targets.*.url"}
+
+// IsSecret reports whether the setting at path shouldn't be logged or shown.
+// values are the flattened settings path is from, to tell the URLs of
+// webhook sinks, which usually carry a token, from those of other sinks.
+func IsSecret(path string, values map[string]string) bool {
+	parts := strings.Split(path, ".")
+	for _, part := range parts {
+		for _, k := range secretKeys {
+			if strings.Contains(part, k) {
+				return true
+			}
+		}
+	}
+	for _, p := range secretPaths {
+		if matchPath(p, parts) {
+			return true
+		}
+	}
+	if matchPath("sinks.*.url", parts) {
+		return values["sinks."+parts[1]+".type"] == "webhook"
+	}
+	return false
+}
+
+func matchPath(pattern string, parts []string) bool {
+	want := strings.Split(pattern, ".")
+	if len(want) != len(parts) {
+		return false
+	}
+	for i, w := range want {
+		if w != "*" && w != parts[i] {
+			return false
+		}
+	}
+	return true
+}
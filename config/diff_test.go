@@ -0,0 +1,93 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestDiff(t *testing.T) {
+	old, err := NewConfig(`
+node_id: node-1
+ice_servers:
+  cloudflare:
+    enabled: true
+    api_key: old-key
+    turn_ports:
+      udp: [3478]
+`)
+	assert.NoError(t, err)
+	new, err := NewConfig(`
+node_id: node-1
+samples: 3
+ice_servers:
+  cloudflare:
+    enabled: true
+    api_key: new-key
+    turn_ports:
+      udp: [3478, 53]
+`)
+	assert.NoError(t, err)
+
+	changes := Diff(old, new)
+	assert.Equal(t, 3, len(changes))
+	assert.Equal(t, "ice_servers.cloudflare.api_key changed", changes[0].String())
+	assert.Equal(t, `ice_servers.cloudflare.turn_ports.udp: "[3478]" -> "[3478 53]"`, changes[1].String())
+	assert.Equal(t, `samples: "" -> "3"`, changes[2].String())
+
+	assert.Equal(t, 0, len(Diff(new, new)))
+}
+
+func TestDiffSinks(t *testing.T) {
+	old, err := NewConfig(`
+sinks:
+  - type: loki
+    url: http://loki:3100/loki/api/v1/push
+    password: old-password
+`)
+	assert.NoError(t, err)
+	new, err := NewConfig(`
+sinks:
+  - type: loki
+    url: http://loki:3100/loki/api/v1/push
+    password: new-password
+`)
+	assert.NoError(t, err)
+
+	changes := Diff(old, new)
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, "sinks.0.password changed", changes[0].String())
+}
+
+func TestDiffWebhookURLs(t *testing.T) {
+	old, err := NewConfig(`
+sinks:
+  - type: webhook
+    url: https://hooks.example.com/old-token
+  - type: loki
+    url: http://loki:3100/loki/api/v1/push
+notifications:
+  targets:
+    - type: slack
+      url: https://hooks.slack.com/services/old
+`)
+	assert.NoError(t, err)
+	new, err := NewConfig(`
+sinks:
+  - type: webhook
+    url: https://hooks.example.com/new-token
+  - type: loki
+    url: http://loki:3101/loki/api/v1/push
+notifications:
+  targets:
+    - type: slack
+      url: https://hooks.slack.com/services/new
+`)
+	assert.NoError(t, err)
+
+	changes := Diff(old, new)
+	assert.Equal(t, 3, len(changes))
+	assert.Equal(t, "notifications.targets.0.url changed", changes[0].String())
+	assert.Equal(t, "sinks.0.url changed", changes[1].String())
+	assert.Equal(t, `sinks.1.url: "http://loki:3100/loki/api/v1/push" -> "http://loki:3101/loki/api/v1/push"`, changes[2].String())
+}
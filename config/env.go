@@ -0,0 +1,325 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the environment variables that override config settings,
+// e.g. ICEPERF_ICE_SERVERS_CLOUDFLARE_API_KEY sets ice_servers.cloudflare.api_key
+const EnvPrefix = "ICEPERF_"
+
+// fileSuffix marks a setting whose value is read from a file, e.g. api_key_file
+const fileSuffix = "_file"
+
+// EnvLayer builds a layer from the ICEPERF_ environment variables in environ,
+// as returned by os.Environ. Values are parsed as YAML, so lists and numbers
+// can be set too. Variables that don't match a setting are ignored.
+func EnvLayer(environ []string) (Layer, error) {
+	l := Layer{Source: SourceEnv, Values: map[string]any{}}
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(key, EnvPrefix) {
+			continue
+		}
+		path, t, ok := envPath(strings.ToLower(strings.TrimPrefix(key, EnvPrefix)), reflect.TypeOf(Config{}))
+		if !ok {
+			continue
+		}
+		if t.Kind() == reflect.String {
+			setPath(l.Values, path, value)
+			continue
+		}
+
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(value), &doc); err != nil {
+			return l, fmt.Errorf("%s: %w", key, err)
+		}
+		var v any = value
+		if len(doc.Content) > 0 {
+			v = nodeToTree(doc.Content[0])
+		}
+		setPath(l.Values, path, v)
+	}
+
+	if err := resolveFiles(l.Values, ""); err != nil {
+		return l, err
+	}
+	return l, nil
+}
+
+// envPath splits the lower cased name of an environment variable into the
+// path of the setting it refers to, using the config types to tell the
+// underscores between settings from those within them. It also returns the
+// type of the setting.
+func envPath(name string, t reflect.Type) ([]string, reflect.Type, bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field, ok := yamlName(t.Field(i))
+			if !ok {
+				continue
+			}
+			if name == field {
+				return []string{field}, t.Field(i).Type, true
+			}
+			if name == field+fileSuffix {
+				return []string{name}, reflect.TypeOf(""), true
+			}
+			if rest, ok := strings.CutPrefix(name, field+"_"); ok {
+				if sub, st, ok := envPath(rest, t.Field(i).Type); ok {
+					return append([]string{field}, sub...), st, true
+				}
+			}
+		}
+	case reflect.Map:
+		if name == "" {
+			return nil, nil, false
+		}
+		elem := t.Elem()
+		for elem.Kind() == reflect.Pointer {
+			elem = elem.Elem()
+		}
+		if elem.Kind() != reflect.Struct {
+			return []string{name}, t.Elem(), true
+		}
+		// the map key is the shortest prefix that leaves a valid setting
+		for i := strings.Index(name, "_"); i > 0; {
+			if sub, st, ok := envPath(name[i+1:], elem); ok {
+				return append([]string{name[:i]}, sub...), st, true
+			}
+			next := strings.Index(name[i+1:], "_")
+			if next < 0 {
+				break
+			}
+			i += next + 1
+		}
+	}
+	return nil, nil, false
+}
+
+func setPath(values map[string]any, path []string, v any) {
+	for _, k := range path[:len(path)-1] {
+		next, ok := values[k].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			values[k] = next
+		}
+		values = next
+	}
+	values[path[len(path)-1]] = v
+}
+
+// nodeToTree converts a YAML node into a tree of string keyed maps. Scalars
+// and lists are kept as nodes, so values are decoded from their original
+// text: a password of 0123 stays 0123 rather than becoming the number 123.
+func nodeToTree(n *yaml.Node) any {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil
+		}
+		return nodeToTree(n.Content[0])
+	case yaml.AliasNode:
+		return nodeToTree(n.Alias)
+	case yaml.MappingNode:
+		m := make(map[string]any, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			m[n.Content[i].Value] = nodeToTree(n.Content[i+1])
+		}
+		return m
+	case yaml.ScalarNode:
+		if n.ShortTag() == "!!null" {
+			return nil
+		}
+	}
+	return n
+}
+
+// interpolate replaces ${VAR} and ${VAR:-default} in every string in the
+// tree with the value of the environment variable. $$ is a literal $.
+func interpolate(v any, path string) error {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, val := range t {
+			if err := interpolate(val, join(path, k)); err != nil {
+				return err
+			}
+		}
+	case *yaml.Node:
+		if t.Kind == yaml.SequenceNode || t.Kind == yaml.MappingNode {
+			for _, c := range t.Content {
+				if err := interpolate(c, path); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if t.Kind != yaml.ScalarNode || !strings.Contains(t.Value, "$") {
+			return nil
+		}
+		value, err := expandEnv(t.Value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		whole := strings.HasPrefix(t.Value, "${") && strings.IndexByte(t.Value, '}') == len(t.Value)-1
+		if value != t.Value && (t.Style == 0 || whole) && !isNull(value) {
+			// let the decoder work out the type of the new value, so
+			// port: ${PORT} and ports: ["${PORT}"] are still numbers
+			t.Tag = ""
+			t.Style = 0
+		}
+		t.Value = value
+	}
+	return nil
+}
+
+// isNull reports whether a plain YAML scalar would decode as null
+func isNull(s string) bool {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return true
+	}
+	return false
+}
+
+func expandEnv(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated ${ in %q", s)
+			}
+			expr := s[i+2 : i+end]
+			name, def, hasDefault := strings.Cut(expr, ":-")
+			value, ok := os.LookupEnv(name)
+			switch {
+			case ok && value != "":
+				b.WriteString(value)
+			case hasDefault:
+				b.WriteString(def)
+			case ok:
+			default:
+				return "", fmt.Errorf("environment variable %s is not set", name)
+			}
+			i += end
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// resolveFiles replaces every setting ending in _file with a setting of the
+// same name without the suffix, holding the contents of the file. This lets
+// secrets be mounted as files, e.g. api_key_file: /run/secrets/api_key.
+func resolveFiles(values map[string]any, path string) error {
+	for k, v := range values {
+		switch t := v.(type) {
+		case map[string]any:
+			if err := resolveFiles(t, join(path, k)); err != nil {
+				return err
+			}
+			continue
+		case *yaml.Node:
+			if t.Kind != yaml.ScalarNode {
+				if err := resolveNodeFiles(t, join(path, k)); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		name, ok := strings.CutSuffix(k, fileSuffix)
+		if !ok || name == "" {
+			continue
+		}
+		filename := ""
+		switch f := v.(type) {
+		case string:
+			filename = f
+		case *yaml.Node:
+			filename = f.Value
+		}
+		if filename == "" {
+			delete(values, k)
+			continue
+		}
+
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("%s: %w", join(path, k), err)
+		}
+		values[name] = strings.TrimRight(string(content), "\r\n")
+		delete(values, k)
+	}
+	return nil
+}
+
+// resolveNodeFiles reads the settings with a _file suffix in the lists of a
+// config tree, which are left as YAML nodes, e.g. sinks[].password_file
+func resolveNodeFiles(n *yaml.Node, path string) error {
+	switch n.Kind {
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			if err := resolveNodeFiles(c, join(path, strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		// a setting read from a file replaces the one set directly
+		fromFile := make(map[string]bool)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if name, ok := strings.CutSuffix(n.Content[i].Value, fileSuffix); ok && name != "" && n.Content[i+1].Value != "" {
+				fromFile[name] = true
+			}
+		}
+
+		content := make([]*yaml.Node, 0, len(n.Content))
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			if fromFile[key.Value] {
+				continue
+			}
+			name, ok := strings.CutSuffix(key.Value, fileSuffix)
+			if !ok || name == "" || value.Kind != yaml.ScalarNode {
+				if err := resolveNodeFiles(value, join(path, key.Value)); err != nil {
+					return err
+				}
+				content = append(content, key, value)
+				continue
+			}
+			if value.Value == "" {
+				continue
+			}
+
+			data, err := os.ReadFile(value.Value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", join(path, key.Value), err)
+			}
+			key.Value = name
+			value.SetString(strings.TrimRight(string(data), "\r\n"))
+			content = append(content, key, value)
+		}
+		n.Content = content
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestFileLayerInterpolation(t *testing.T) {
+	t.Setenv("TEST_TURN_HOST", "turn.example.com")
+	t.Setenv("TEST_TURN_PORT", "3478")
+
+	secret := filepath.Join(t.TempDir(), "api_key")
+	assert.NoError(t, os.WriteFile(secret, []byte("0123\n"), 0o600))
+
+	file, err := FileLayer(`
+ice_servers:
+  generic:
+    turn_host: ${TEST_TURN_HOST}
+    stun_host: ${TEST_UNSET_HOST:-stun.example.com}
+    password: "p$$ss 0123"
+    username: 0123
+    api_key_file: ` + secret + `
+    turn_ports:
+      udp: ["${TEST_TURN_PORT}", 53]
+`)
+	assert.NoError(t, err)
+
+	c, _, err := Build(file)
+	assert.NoError(t, err)
+	g := c.ICEConfig["generic"]
+	assert.Equal(t, "turn.example.com", g.TurnHost)
+	assert.Equal(t, "stun.example.com", g.StunHost)
+	assert.Equal(t, "p$ss 0123", g.Password)
+	assert.Equal(t, "0123", g.Username)
+	assert.Equal(t, "0123", g.ApiKey)
+	assert.Equal(t, []int{3478, 53}, g.TurnPorts["udp"])
+
+	_, err = FileLayer("node_id: ${TEST_UNSET_NODE}")
+	assert.Error(t, err)
+}
+
+func TestFileLayerListSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	password := filepath.Join(dir, "password")
+	assert.NoError(t, os.WriteFile(password, []byte("hunter2\n"), 0o600))
+	webhook := filepath.Join(dir, "webhook")
+	assert.NoError(t, os.WriteFile(webhook, []byte("https://hooks.example.com/secret"), 0o600))
+
+	file, err := FileLayer(`
+sinks:
+  - type: loki
+    url: https://loki.example.com
+    username: iceperf
+    password: replaced
+    password_file: ` + password + `
+notifications:
+  targets:
+    - type: slack
+      url_file: ` + webhook + `
+`)
+	assert.NoError(t, err)
+
+	c, _, err := Build(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", c.Sinks[0].Password)
+	assert.Equal(t, "iceperf", c.Sinks[0].Username)
+	assert.Equal(t, "https://hooks.example.com/secret", c.Notifications.Targets[0].URL)
+
+	_, err = FileLayer(`
+sinks:
+  - type: loki
+    password_file: ` + filepath.Join(dir, "missing") + `
+`)
+	assert.Error(t, err)
+}
+
+func TestEnvLayer(t *testing.T) {
+	env, err := EnvLayer([]string{
+		"ICEPERF_NODE_ID=from-env",
+		"ICEPERF_ICE_SERVERS_EXPRESS_TURN_API_KEY=0123: secret",
+		"ICEPERF_ICE_SERVERS_EXPRESS_TURN_TURN_PORTS_TCP=[443, 80]",
+		"ICEPERF_ICE_SERVERS_EXPRESS_TURN_DO_THROUGHPUT=false",
+		"ICEPERF_TIMER_EVERY=30",
+		"ICEPERF_UNKNOWN_SETTING=1",
+		"PATH=/usr/bin",
+	})
+	assert.NoError(t, err)
+
+	file, err := FileLayer(`
+node_id: from-file
+ice_servers:
+  express_turn:
+    enabled: true
+    do_throughput: true
+`)
+	assert.NoError(t, err)
+
+	c, sources, err := Build(file, env)
+	assert.NoError(t, err)
+	assert.Equal(t, "from-env", c.NodeID)
+	assert.Equal(t, SourceEnv, sources.Lookup("node_id"))
+
+	et := c.ICEConfig["express_turn"]
+	assert.True(t, et.Enabled)
+	assert.False(t, et.DoThroughput)
+	assert.Equal(t, "0123: secret", et.ApiKey)
+	assert.Equal(t, []int{443, 80}, et.TurnPorts["tcp"])
+	assert.Equal(t, 30, c.Timer.Every)
+}
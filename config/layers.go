@@ -0,0 +1,318 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Where a setting came from, from lowest to highest precedence
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceAPI     = "api"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+var precedence = map[string]int{
+	SourceDefault: 0,
+	SourceFile:    1,
+	SourceAPI:     2,
+	SourceEnv:     3,
+	SourceFlag:    4,
+}
+
+// sortLayers orders layers from lowest to highest precedence
+func sortLayers(layers []Layer) {
+	sort.SliceStable(layers, func(i, j int) bool {
+		return precedence[layers[i].Source] < precedence[layers[j].Source]
+	})
+}
+
+// DefaultApiURI is where settings are fetched from when the API is enabled
+const DefaultApiURI = "https://api.iceperf.com/api/settings"
+
+// Layer is a set of settings from one source, as a tree keyed by the YAML
+// names of the settings. A setting that is present in a layer overrides
+// lower layers even if it is a zero value, like false or 0.
+type Layer struct {
+	Source string
+	Values map[string]any
+}
+
+// Sources records which source each setting came from, keyed by the same
+// dotted paths as Flatten
+type Sources map[string]string
+
+// DefaultLayer holds the settings used when no other source sets them
+func DefaultLayer() Layer {
+	return Layer{
+		Source: SourceDefault,
+		Values: map[string]any{
+			"api": map[string]any{
+				"uri": DefaultApiURI,
+			},
+			"timer": map[string]any{
+				"interval": 60,
+			},
+			"dns": map[string]any{
+				"all_addresses": true,
+			},
+			"environment": map[string]any{
+				"ntp_server": "pool.ntp.org",
+			},
+			"distributed": map[string]any{
+				"room": "iceperf",
+			},
+			"soak": map[string]any{
+				"duration":  1800,
+				"interval":  10,
+				"keepalive": 1,
+			},
+		},
+	}
+}
+
+// FileLayer parses a YAML config file, replacing ${VAR} with environment
+// variables and reading the settings ending in _file from their files
+func FileLayer(body string) (Layer, error) {
+	l := Layer{Source: SourceFile, Values: map[string]any{}}
+	if strings.TrimSpace(body) == "" {
+		return l, nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(body), &doc); err != nil {
+		return l, err
+	}
+	tree := nodeToTree(&doc)
+	if tree == nil {
+		return l, nil
+	}
+	values, ok := tree.(map[string]any)
+	if !ok {
+		return l, fmt.Errorf("config file must be a YAML mapping")
+	}
+	if err := interpolate(values, ""); err != nil {
+		return l, err
+	}
+	if err := resolveFiles(values, ""); err != nil {
+		return l, err
+	}
+	l.Values = values
+	return l, nil
+}
+
+// ApiLayer parses the settings returned by the API. The API uses the JSON
+// names of the settings, which are translated to their YAML names. The API
+// can't change the api settings used to reach it.
+func ApiLayer(body []byte) (Layer, error) {
+	l := Layer{Source: SourceAPI, Values: map[string]any{}}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var raw map[string]any
+	if err := dec.Decode(&raw); err != nil {
+		return l, fmt.Errorf("invalid settings from the API: %w", err)
+	}
+
+	values, _ := translateJSON(normalize(raw), reflect.TypeOf(Config{})).(map[string]any)
+	if values != nil {
+		delete(values, "api")
+		l.Values = values
+	}
+	return l, nil
+}
+
+// Merge deep merges the layers in order, later layers taking precedence.
+// Maps, like ice_servers, are merged key by key, while lists and other
+// values are replaced as a whole. It returns the merged tree and the source
+// of every setting in it.
+func Merge(layers ...Layer) (map[string]any, Sources) {
+	merged := map[string]any{}
+	sources := Sources{}
+	for _, l := range layers {
+		mergeInto(merged, l.Values, "", l.Source, sources)
+	}
+	return merged, sources
+}
+
+func mergeInto(dst, src map[string]any, prefix, source string, sources Sources) {
+	for k, v := range src {
+		path := join(prefix, k)
+		if v == nil {
+			// an explicit null is the same as leaving the setting out
+			continue
+		}
+
+		srcMap, srcIsMap := v.(map[string]any)
+		dstMap, dstIsMap := dst[k].(map[string]any)
+		switch {
+		case srcIsMap && dstIsMap:
+			mergeInto(dstMap, srcMap, path, source, sources)
+		case srcIsMap:
+			clearSources(sources, path)
+			m := map[string]any{}
+			mergeInto(m, srcMap, path, source, sources)
+			dst[k] = m
+		default:
+			clearSources(sources, path)
+			dst[k] = v
+			sources[path] = source
+		}
+	}
+}
+
+// clearSources forgets the sources of everything below path, when it is
+// replaced by another layer
+func clearSources(sources Sources, path string) {
+	for p := range sources {
+		if p == path || strings.HasPrefix(p, path+".") {
+			delete(sources, p)
+		}
+	}
+}
+
+// Decode builds a Config from a merged tree, failing on unknown settings
+func Decode(values map[string]any) (*Config, error) {
+	body, err := yaml.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{
+		ServiceName: "ICEPerf",
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(body))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Lookup returns the source of the setting at path, or of the closest
+// setting above it, e.g. the source of a whole list
+func (s Sources) Lookup(path string) string {
+	for p := path; p != ""; {
+		if src, ok := s[p]; ok {
+			return src
+		}
+		i := strings.LastIndex(p, ".")
+		if i < 0 {
+			break
+		}
+		p = p[:i]
+	}
+	return SourceDefault
+}
+
+// Paths returns the paths with a known source, sorted
+func (s Sources) Paths() []string {
+	paths := make([]string, 0, len(s))
+	for p := range s {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// normalize turns the JSON numbers from the API into ints or floats
+func normalize(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, val := range t {
+			t[k] = normalize(val)
+		}
+		return t
+	case []any:
+		for i, val := range t {
+			t[i] = normalize(val)
+		}
+		return t
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	}
+	return v
+}
+
+// translateJSON renames the JSON keys of v to the YAML names of the fields
+// of t, dropping keys that can't be set from YAML
+func translateJSON(v any, t reflect.Type) any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[string]any)
+		if !ok {
+			return v
+		}
+		out := make(map[string]any, len(m))
+		for k, val := range m {
+			f, ok := jsonField(t, k)
+			if !ok {
+				continue
+			}
+			name, ok := yamlName(f)
+			if !ok {
+				continue
+			}
+			out[name] = translateJSON(val, f.Type)
+		}
+		return out
+	case reflect.Map:
+		m, ok := v.(map[string]any)
+		if !ok {
+			return v
+		}
+		for k, val := range m {
+			m[k] = translateJSON(val, t.Elem())
+		}
+		return m
+	case reflect.Slice:
+		s, ok := v.([]any)
+		if !ok {
+			return v
+		}
+		for i, val := range s {
+			s[i] = translateJSON(val, t.Elem())
+		}
+		return s
+	}
+	return v
+}
+
+// jsonField finds the field encoding/json would decode key into
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
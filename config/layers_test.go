@@ -0,0 +1,94 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestMergeLayers(t *testing.T) {
+	file, err := FileLayer(`
+node_id: local
+ice_servers:
+  cloudflare:
+    enabled: true
+    api_key: file-key
+    do_throughput: true
+    turn_ports:
+      udp: [3478, 53]
+  metered:
+    enabled: true
+`)
+	assert.NoError(t, err)
+
+	api, err := ApiLayer([]byte(`{
+		"nodeId": "from-api",
+		"iceServers": {
+			"cloudflare": {"apiKey": "api-key", "DoThroughput": false, "TurnPorts": {"udp": [3478]}},
+			"twilio": {"Enabled": true}
+		},
+		"api": {"uri": "https://elsewhere.example.com"}
+	}`))
+	assert.NoError(t, err)
+
+	flags := Layer{Source: SourceFlag, Values: map[string]any{
+		"node_id": "from-flag",
+	}}
+
+	merged, sources := Merge(DefaultLayer(), file, api, flags)
+	c, err := Decode(merged)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "from-flag", c.NodeID)
+	assert.Equal(t, SourceFlag, sources.Lookup("node_id"))
+
+	// ice_servers is merged provider by provider, and setting by setting
+	assert.Equal(t, 3, len(c.ICEConfig))
+	cf := c.ICEConfig["cloudflare"]
+	assert.True(t, cf.Enabled)
+	assert.Equal(t, "api-key", cf.ApiKey)
+	assert.Equal(t, SourceAPI, sources.Lookup("ice_servers.cloudflare.api_key"))
+	assert.Equal(t, SourceFile, sources.Lookup("ice_servers.cloudflare.enabled"))
+
+	// an explicit false overrides true from a lower layer
+	assert.False(t, cf.DoThroughput)
+	assert.Equal(t, SourceAPI, sources.Lookup("ice_servers.cloudflare.do_throughput"))
+
+	// lists are replaced, not appended to
+	assert.Equal(t, []int{3478}, cf.TurnPorts["udp"])
+
+	// the API can't change where it is fetched from
+	assert.Equal(t, DefaultApiURI, c.Api.URI)
+	assert.Equal(t, SourceDefault, sources.Lookup("api.uri"))
+	assert.Equal(t, 60, c.Timer.Interval)
+}
+
+func TestApiLayerRejectsInvalidJSON(t *testing.T) {
+	_, err := ApiLayer([]byte(`{"nodeId": `))
+	assert.Error(t, err)
+}
+
+func TestLoadWithoutApi(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	file, err := FileLayer(`
+node_id: local
+api:
+  enabled: true
+  api_key: key
+  uri: ` + srv.URL + `
+`)
+	assert.NoError(t, err)
+
+	// an unreachable API leaves the other layers
+	c, sources, err := Load(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "local", c.NodeID)
+	assert.Error(t, c.ApiError)
+	assert.Equal(t, SourceFile, sources.Lookup("node_id"))
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// schemaOverrides adds constraints the Go types can't express, keyed by
+// path, with * for any map key and [] for list items
+var schemaOverrides = map[string]map[string]any{
+	"logging.level":                 {"enum": []string{"debug", "info", "error"}},
+	"api.mode":                      {"enum": []string{"batch", "stream"}},
+	"logging.api.mode":              {"enum": []string{"batch", "stream"}},
+	"ice_servers.*.stun_ports":      {"propertyNames": map[string]any{"enum": StunTransports}},
+	"ice_servers.*.turn_ports":      {"propertyNames": map[string]any{"enum": TurnTransports}},
+	"ice_servers.*.stun_ports.*.[]": {"minimum": 1, "maximum": 65535},
+	"ice_servers.*.turn_ports.*.[]": {"minimum": 1, "maximum": 65535},
+	"ice_servers.*.ip_families.[]":  {"enum": IPFamilies},
+	"sinks.[].type":                 {"enum": []string{"log", "stdout", "file", "loki", "prometheus", "webhook", "api"}},
+	"sinks.[].format":               {"enum": []string{"table", "json"}},
+	"notifications.targets.[].type": {"enum": []string{"webhook", "slack", "teams"}},
+	"samples":                       {"minimum": 0},
+	"timer.interval":                {"minimum": 0},
+	"timer.every":                   {"minimum": 0},
+	"timer.jitter":                  {"minimum": 0},
+	"timer.schedules.[].every":      {"minimum": 0},
+	"nat.timeout":                   {"minimum": 0},
+	"distributed.role":              {"enum": []string{RoleOfferer, RoleAnswerer}},
+	"ice_servers":                   {"description": "ICE server providers keyed by name. Names other than the built-in providers are generic providers built from stun_host, turn_host and ports."},
+}
+
+func init() {
+	// the answerer can be set globally and per provider
+	for _, path := range []string{"answerer", "ice_servers.*.answerer"} {
+		schemaOverrides[path+".mode"] = map[string]any{"enum": []string{AnswererSame, AnswererNone, AnswererCustom}}
+		schemaOverrides[path+".transport_policy"] = map[string]any{"enum": []string{"all", "relay"}}
+	}
+}
+
+// Schema returns a JSON Schema for the YAML config file, generated from the
+// config types. Every string setting can also be read from a file with a
+// setting of the same name ending in _file.
+func Schema() map[string]any {
+	s := typeSchema(reflect.TypeOf(Config{}), "")
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "ICEPerf agent config"
+	return s
+}
+
+// SchemaJSON returns the schema as indented JSON
+func SchemaJSON() ([]byte, error) {
+	b, err := json.MarshalIndent(Schema(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func typeSchema(t reflect.Type, path string) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var s map[string]any
+	switch t.Kind() {
+	case reflect.Struct:
+		props := map[string]any{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, ok := yamlName(f)
+			if !ok {
+				continue
+			}
+			props[name] = typeSchema(f.Type, join(path, name))
+			if f.Type.Kind() == reflect.String {
+				props[name+fileSuffix] = map[string]any{
+					"type":        "string",
+					"description": "Path of a file to read " + name + " from",
+				}
+			}
+		}
+		s = map[string]any{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+	case reflect.Map:
+		s = map[string]any{
+			"type":                 "object",
+			"additionalProperties": typeSchema(t.Elem(), join(path, "*")),
+		}
+	case reflect.Slice, reflect.Array:
+		s = map[string]any{
+			"type":  "array",
+			"items": typeSchema(t.Elem(), join(path, "[]")),
+		}
+	case reflect.String:
+		s = map[string]any{"type": "string"}
+	case reflect.Bool:
+		s = map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s = map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		s = map[string]any{"type": "number"}
+	default:
+		s = map[string]any{}
+	}
+
+	for k, v := range schemaOverrides[path] {
+		s[k] = v
+	}
+	return s
+}
@@ -0,0 +1,319 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Transports that can be used as keys of stun_ports and turn_ports
+var (
+	StunTransports = []string{"udp", "tcp", "tls"}
+	TurnTransports = []string{"udp", "tcp", "tls", "dtls"}
+	IPFamilies     = []string{"ipv4", "ipv6"}
+)
+
+// providerRequired lists the settings each built-in provider needs to fetch
+// its ICE servers. Cloudflare, Google and ExpressTURN are checked separately
+// as they can also be configured with hosts and ports.
+var providerRequired = map[string][]string{
+	"api":     {"request_url", "api_key"},
+	"elixir":  {"request_url", "http_username"},
+	"metered": {"request_url", "api_key"},
+	"stunner": {"request_url"},
+	"twilio":  {"request_url", "http_username", "http_password"},
+	"xirsys":  {"request_url", "http_username", "http_password"},
+}
+
+// hostProviders build their ICE servers from stun_host, turn_host and ports
+var hostProviders = map[string]bool{
+	"cloudflare":  true,
+	"expressturn": true,
+	"google":      true,
+}
+
+// Validate checks the config for settings that can't work, returning every
+// problem found
+func (c *Config) Validate() error {
+	var errs []error
+
+	names := make([]string, 0, len(c.ICEConfig))
+	for name := range c.ICEConfig {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		errs = append(errs, validateProvider(name, c.ICEConfig[name])...)
+	}
+
+	if c.Samples < 0 {
+		errs = append(errs, errors.New("samples can't be negative"))
+	}
+	if c.Timer.Interval < 0 || c.Timer.Every < 0 || c.Timer.Jitter < 0 {
+		errs = append(errs, errors.New("timer interval, every and jitter can't be negative"))
+	}
+
+	errs = append(errs, validateAnswerer("answerer", c.Answerer)...)
+	// with the API the providers aren't known until it is asked
+	for i, pair := range c.RelayMatrix.Pairs {
+		for _, provider := range []string{pair.Offerer, pair.Answerer} {
+			if _, ok := c.ICEConfig[provider]; !ok && !c.ICEConfig["api"].Enabled {
+				errs = append(errs, fmt.Errorf("relay_matrix.pairs.%d: unknown provider %q", i, provider))
+			}
+		}
+	}
+
+	switch c.Distributed.Role {
+	case "", RoleOfferer, RoleAnswerer:
+	default:
+		errs = append(errs, fmt.Errorf("distributed.role: unknown role %q, use offerer or answerer", c.Distributed.Role))
+	}
+	if c.Distributed.Role != "" && c.Distributed.URL() == "" {
+		errs = append(errs, errors.New("distributed.signaling_url or distributed.listen is required with a role"))
+	}
+
+	if c.Soak.Enabled {
+		if c.Soak.Duration <= 0 || c.Soak.Interval <= 0 || c.Soak.Keepalive <= 0 {
+			errs = append(errs, errors.New("soak duration, interval and keepalive must be positive"))
+		}
+		// the answering agent doesn't echo keepalives or restart ICE
+		if c.Distributed.Role != "" {
+			errs = append(errs, errors.New("soak tests can't be distributed, unset distributed.role"))
+		}
+	}
+
+	for _, api := range []ApiConfig{c.Api, c.Logging.API} {
+		switch api.Mode {
+		case "", "batch", "stream":
+		default:
+			errs = append(errs, fmt.Errorf("unknown api mode %q, use batch or stream", api.Mode))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateProvider(name string, ic ICEConfig) []error {
+	var errs []error
+	prefix := "ice_servers." + name
+
+	for transport, ports := range ic.StunPorts {
+		errs = append(errs, validatePorts(prefix+".stun_ports", transport, ports, StunTransports)...)
+	}
+	for transport, ports := range ic.TurnPorts {
+		errs = append(errs, validatePorts(prefix+".turn_ports", transport, ports, TurnTransports)...)
+	}
+
+	for _, family := range ic.IPFamilies {
+		if !contains(IPFamilies, family) {
+			errs = append(errs, fmt.Errorf("%s.ip_families: unknown family %s, use ipv4 or ipv6", prefix, family))
+		}
+	}
+
+	if ic.Answerer != nil {
+		errs = append(errs, validateAnswerer(prefix+".answerer", *ic.Answerer)...)
+	}
+
+	_, builtIn := providerRequired[name]
+	if (builtIn || hostProviders[name]) && !ic.Enabled {
+		// built-in providers are skipped unless enabled
+		return errs
+	}
+
+	if required, ok := providerRequired[name]; ok {
+		for _, field := range required {
+			if iceSetting(ic, field) == "" {
+				errs = append(errs, fmt.Errorf("%s.%s is required when %s is enabled", prefix, field, name))
+			}
+		}
+		return errs
+	}
+
+	if name == "cloudflare" && ic.RequestUrl != "" {
+		if ic.ApiKey == "" {
+			errs = append(errs, fmt.Errorf("%s.api_key is required with request_url", prefix))
+		}
+		return errs
+	}
+	if name == "cloudflare" && ic.TurnHost == "" && ic.StunHost == "" {
+		return append(errs, fmt.Errorf("%s needs request_url and api_key, or turn_host or stun_host", prefix))
+	}
+
+	if ic.StunEnabled {
+		errs = append(errs, validateHosts(prefix, "stun", ic.StunHost, ic.StunHosts, ic.StunPorts)...)
+	}
+	if ic.TurnEnabled {
+		errs = append(errs, validateHosts(prefix, "turn", ic.TurnHost, ic.TurnHosts, ic.TurnPorts)...)
+	}
+	if !hostProviders[name] && !ic.StunEnabled && !ic.TurnEnabled {
+		errs = append(errs, fmt.Errorf("%s is not a built-in provider, so it needs stun_enabled or turn_enabled with hosts and ports", prefix))
+	}
+	return errs
+}
+
+func validateAnswerer(path string, a AnswererConfig) []error {
+	var errs []error
+	switch a.Mode {
+	case "", AnswererSame, AnswererNone:
+		if len(a.ICEServers) > 0 {
+			errs = append(errs, fmt.Errorf("%s.ice_servers is only used with mode custom", path))
+		}
+	case AnswererCustom:
+		if len(a.ICEServers) == 0 {
+			errs = append(errs, fmt.Errorf("%s.ice_servers is required with mode custom", path))
+		}
+		for i, is := range a.ICEServers {
+			if len(is.URLs) == 0 {
+				errs = append(errs, fmt.Errorf("%s.ice_servers.%d.urls is required", path, i))
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("%s.mode: unknown mode %q, use same, none or custom", path, a.Mode))
+	}
+
+	switch a.TransportPolicy {
+	case "", "all":
+	case "relay":
+		if a.Mode == AnswererNone {
+			errs = append(errs, fmt.Errorf("%s: transport_policy relay needs a TURN server, so can't be used with mode none", path))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("%s.transport_policy: unknown policy %q, use all or relay", path, a.TransportPolicy))
+	}
+	return errs
+}
+
+// validateHosts checks that STUN or TURN has a host, and ports unless every
+// host is an SRV record name, which lists its own ports
+func validateHosts(prefix, kind, host string, hosts []string, ports map[string][]int) []error {
+	var errs []error
+	all := append([]string{host}, hosts...)
+	needPorts := strings.Join(all, "") == ""
+	for _, h := range all {
+		if h != "" && !strings.HasPrefix(h, "_") {
+			needPorts = true
+		}
+	}
+	if strings.Join(all, "") == "" {
+		errs = append(errs, fmt.Errorf("%s.%s_host or %s_hosts is required when %s_enabled is set", prefix, kind, kind, kind))
+	}
+	if needPorts && len(ports) == 0 {
+		errs = append(errs, fmt.Errorf("%s.%s_ports is required when %s_enabled is set", prefix, kind, kind))
+	}
+	return errs
+}
+
+func validatePorts(path, transport string, ports []int, transports []string) []error {
+	var errs []error
+	if !contains(transports, transport) {
+		errs = append(errs, fmt.Errorf("%s.%s: unknown transport, use one of %s", path, transport, strings.Join(transports, ", ")))
+	}
+	for _, p := range ports {
+		if p < 1 || p > 65535 {
+			errs = append(errs, fmt.Errorf("%s.%s: invalid port %d, ports are 1 to 65535", path, transport, p))
+		}
+	}
+	return errs
+}
+
+// iceSetting returns the value of the ICEConfig string setting with the
+// given YAML name
+func iceSetting(ic ICEConfig, name string) string {
+	v := reflect.ValueOf(ic)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if n, ok := yamlName(t.Field(i)); ok && n == name {
+			return v.Field(i).String()
+		}
+	}
+	return ""
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// checkKnown reports the settings in a layer that don't exist, suggesting
+// the closest one, e.g. for typos like turn_port or do_thoughput
+func checkKnown(values map[string]any, t reflect.Type, path string) []error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var errs []error
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := map[string]reflect.Type{}
+		var names []string
+		for i := 0; i < t.NumField(); i++ {
+			if name, ok := yamlName(t.Field(i)); ok {
+				fields[name] = t.Field(i).Type
+				names = append(names, name)
+			}
+		}
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ft, ok := fields[k]
+			if !ok {
+				msg := fmt.Sprintf("%s: unknown setting", join(path, k))
+				if s := closest(k, names); s != "" {
+					msg += fmt.Sprintf(", did you mean %s?", s)
+				}
+				errs = append(errs, errors.New(msg))
+				continue
+			}
+			if m, ok := values[k].(map[string]any); ok {
+				errs = append(errs, checkKnown(m, ft, join(path, k))...)
+			}
+		}
+	case reflect.Map:
+		for k, v := range values {
+			if m, ok := v.(map[string]any); ok {
+				errs = append(errs, checkKnown(m, t.Elem(), join(path, k))...)
+			}
+		}
+	}
+	return errs
+}
+
+// closest returns the name within a small edit distance of s, if any
+func closest(s string, names []string) string {
+	best, bestDist := "", 4
+	for _, n := range names {
+		if d := editDistance(s, n); d < bestDist {
+			best, bestDist = n, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestValidate(t *testing.T) {
+	c, err := NewConfig(`
+samples: -1
+ice_servers:
+  metered:
+    enabled: true
+    request_url: https://example.metered.live/api/v1/turn/credentials
+    turn_ports:
+      tcp: [0, 443]
+      sctp: [5000]
+  twilio:
+    enabled: false
+  mine:
+    stun_enabled: true
+    stun_host: stun.example.com
+    stun_ports:
+      udp: [3478]
+    ip_families: [ipv4, ipv5]
+    answerer:
+      mode: none
+      transport_policy: relay
+answerer:
+  mode: custom
+distributed:
+  role: offerer
+soak:
+  enabled: true
+  interval: -1
+`)
+	assert.NoError(t, err)
+	err = c.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ice_servers.metered.turn_ports.tcp: invalid port 0")
+	assert.Contains(t, err.Error(), "ice_servers.metered.turn_ports.sctp: unknown transport")
+	assert.Contains(t, err.Error(), "ice_servers.metered.api_key is required when metered is enabled")
+	assert.Contains(t, err.Error(), "samples")
+	assert.Contains(t, err.Error(), "ice_servers.mine.ip_families: unknown family ipv5")
+	assert.Contains(t, err.Error(), "answerer.ice_servers is required with mode custom")
+	assert.Contains(t, err.Error(), "ice_servers.mine.answerer: transport_policy relay needs a TURN server")
+	assert.Contains(t, err.Error(), "distributed.signaling_url or distributed.listen is required")
+	assert.Contains(t, err.Error(), "soak duration, interval and keepalive must be positive")
+	assert.Contains(t, err.Error(), "soak tests can't be distributed")
+	assert.NotContains(t, err.Error(), "twilio")
+	assert.NotContains(t, err.Error(), "mine.stun")
+}
+
+func TestDistributedURL(t *testing.T) {
+	assert.Equal(t, "http://localhost:9090", DistributedConfig{Listen: ":9090"}.URL())
+	assert.Equal(t, "http://10.0.0.2:9090", DistributedConfig{Listen: "10.0.0.2:9090"}.URL())
+	assert.Equal(t, "https://sg.example.com", DistributedConfig{Listen: ":9090", SignalingURL: "https://sg.example.com"}.URL())
+	assert.Equal(t, "", DistributedConfig{}.URL())
+}
+
+func TestUnknownSettings(t *testing.T) {
+	file, err := FileLayer(`
+ice_servers:
+  metered:
+    do_thoughput: true
+    turn_port:
+      udp: [3478]
+timer:
+  intervall: 5
+`)
+	assert.NoError(t, err)
+	_, _, err = Build(file)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ice_servers.metered.do_thoughput: unknown setting, did you mean do_throughput?")
+	assert.Contains(t, err.Error(), "ice_servers.metered.turn_port: unknown setting, did you mean turn_ports?")
+	assert.Contains(t, err.Error(), "timer.intervall: unknown setting, did you mean interval?")
+}
+
+func TestSchemaIsUpToDate(t *testing.T) {
+	committed, err := os.ReadFile("../config.schema.json")
+	assert.NoError(t, err)
+	schema, err := SchemaJSON()
+	assert.NoError(t, err)
+	// regenerate with: go run ./cmd/iceperf config schema > config.schema.json
+	assert.Equal(t, string(schema), string(committed))
+}
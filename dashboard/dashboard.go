@@ -0,0 +1,164 @@
+package dashboard
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+//go:embed static
+var staticFiles embed.FS
+
+const defaultMaxRuns = 100
+
+// Failure is a single ICE server test that didn't connect
+type Failure struct {
+	TestRunID string    `json:"testRunID"`
+	StartedAt time.Time `json:"startedAt"`
+	Provider  string    `json:"provider"`
+	Scheme    string    `json:"scheme"`
+	Protocol  string    `json:"protocol"`
+	Port      string    `json:"port"`
+	Node      string    `json:"node"`
+}
+
+// Server serves the dashboard and keeps the most recent runs in memory. It
+// is a sink, so it is fed every run as it finishes.
+type Server struct {
+	listen  string
+	maxRuns int
+	logger  *slog.Logger
+
+	mu   sync.RWMutex
+	runs []*stats.Run
+
+	srv *http.Server
+}
+
+func New(listen string, maxRuns int, logger *slog.Logger) *Server {
+	if maxRuns <= 0 {
+		maxRuns = defaultMaxRuns
+	}
+	return &Server{
+		listen:  listen,
+		maxRuns: maxRuns,
+		logger:  logger.With("component", "dashboard"),
+	}
+}
+
+// Seed loads earlier runs, e.g. from the history store, oldest first
+func (s *Server) Seed(runs []*stats.Run) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs = append(s.runs, runs...)
+	s.trim()
+}
+
+// Write records a finished run
+func (s *Server) Write(ctx context.Context, run *stats.Run, results []*stats.Stats) error {
+	doc := *run
+	doc.Results = results
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs = append(s.runs, &doc)
+	s.trim()
+	return nil
+}
+
+// trim drops the oldest runs over maxRuns. Callers must hold s.mu.
+func (s *Server) trim() {
+	if over := len(s.runs) - s.maxRuns; over > 0 {
+		s.runs = append([]*stats.Run(nil), s.runs[over:]...)
+	}
+}
+
+// Handler returns the dashboard's routes
+func (s *Server) Handler() http.Handler {
+	static, _ := fs.Sub(staticFiles, "static")
+
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(static)))
+	mux.HandleFunc("/api/runs", s.handleRuns)
+	mux.HandleFunc("/api/latest", s.handleLatest)
+	mux.HandleFunc("/api/failures", s.handleFailures)
+	return mux
+}
+
+// Start serves the dashboard in the background
+func (s *Server) Start() {
+	s.srv = &http.Server{
+		Addr:              s.listen,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		s.logger.Info("Dashboard listening", "listen", s.listen)
+		if err := s.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("Dashboard server stopped", "err", err)
+		}
+	}()
+}
+
+// Close shuts the HTTP server down
+func (s *Server) Close(ctx context.Context) error {
+	if s.srv == nil {
+		return nil
+	}
+	return s.srv.Shutdown(ctx)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	writeJSON(w, s.runs)
+}
+
+func (s *Server) handleLatest(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.runs) == 0 {
+		http.Error(w, "no runs yet", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, s.runs[len(s.runs)-1])
+}
+
+func (s *Server) handleFailures(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	failures := []Failure{}
+	// newest first
+	for i := len(s.runs) - 1; i >= 0; i-- {
+		run := s.runs[i]
+		for _, st := range run.Results {
+			if st.Connected {
+				continue
+			}
+			failures = append(failures, Failure{
+				TestRunID: run.TestRunID,
+				StartedAt: run.StartedAt,
+				Provider:  st.Provider,
+				Scheme:    st.Scheme,
+				Protocol:  st.Protocol,
+				Port:      st.Port,
+				Node:      st.Node,
+			})
+		}
+	}
+	writeJSON(w, failures)
+}
@@ -0,0 +1,55 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+func get(t *testing.T, h http.Handler, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	return rec
+}
+
+func TestDashboardServesRuns(t *testing.T) {
+	s := New(":0", 2, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h := s.Handler()
+
+	assert.Equal(t, http.StatusNotFound, get(t, h, "/api/latest").Code)
+
+	for _, id := range []string{"run1", "run2", "run3"} {
+		run := stats.NewRun(id, time.Now())
+		st := stats.NewStats(id, time.Now())
+		st.SetProvider("cloudflare")
+		run.AddResult(st)
+		assert.NoError(t, s.Write(context.Background(), run, run.Results))
+	}
+
+	var runs []*stats.Run
+	assert.NoError(t, json.NewDecoder(get(t, h, "/api/runs").Body).Decode(&runs))
+	assert.Equal(t, 2, len(runs))
+	assert.Equal(t, "run2", runs[0].TestRunID)
+
+	var latest stats.Run
+	assert.NoError(t, json.NewDecoder(get(t, h, "/api/latest").Body).Decode(&latest))
+	assert.Equal(t, "run3", latest.TestRunID)
+
+	var failures []Failure
+	assert.NoError(t, json.NewDecoder(get(t, h, "/api/failures").Body).Decode(&failures))
+	assert.Equal(t, 2, len(failures))
+	assert.Equal(t, "run3", failures[0].TestRunID)
+
+	index := get(t, h, "/")
+	assert.Equal(t, http.StatusOK, index.Code)
+	assert.True(t, strings.Contains(index.Body.String(), "ICEPerf Agent"))
+}
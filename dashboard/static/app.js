@@ -0,0 +1,150 @@
+"use strict";
+
+const COLORS = ["#2e86de", "#e67e22", "#27ae60", "#8e44ad", "#c0392b", "#16a085", "#d35400", "#2c3e50"];
+
+function el(tag, attrs, children) {
+  const e = document.createElement(tag);
+  Object.entries(attrs || {}).forEach(([k, v]) => e.setAttribute(k, v));
+  (children || []).forEach((c) => e.append(c));
+  return e;
+}
+
+function svgEl(tag, attrs) {
+  const e = document.createElementNS("http://www.w3.org/2000/svg", tag);
+  Object.entries(attrs || {}).forEach(([k, v]) => e.setAttribute(k, v));
+  return e;
+}
+
+function fmt(v) {
+  return typeof v === "number" ? (Math.round(v * 100) / 100).toString() : v;
+}
+
+// lineChart draws one or more series of [x, y] points into an SVG
+function lineChart(title, series, xLabel) {
+  const w = 360, h = 160, pad = 30;
+  const points = series.flatMap((s) => s.points);
+  const chart = el("div", { class: "chart" }, [el("h3", {}, [title])]);
+  const svg = svgEl("svg", { viewBox: `0 0 ${w} ${h}`, preserveAspectRatio: "none" });
+  chart.append(svg);
+  if (points.length === 0) {
+    return chart;
+  }
+
+  const xs = points.map((p) => p[0]), ys = points.map((p) => p[1]);
+  const minX = Math.min(...xs), maxX = Math.max(...xs);
+  const maxY = Math.max(...ys, 1);
+  const sx = (x) => pad + ((x - minX) / (maxX - minX || 1)) * (w - 2 * pad);
+  const sy = (y) => h - pad + 10 - (y / maxY) * (h - 2 * pad);
+
+  svg.append(svgEl("line", { x1: pad, y1: sy(0), x2: w - pad, y2: sy(0), stroke: "#ccc" }));
+  const top = svgEl("text", { x: 2, y: sy(maxY) + 4, "font-size": 9, fill: "#777" });
+  top.textContent = fmt(maxY);
+  svg.append(top);
+  const label = svgEl("text", { x: w - pad, y: h - 2, "font-size": 9, fill: "#777", "text-anchor": "end" });
+  label.textContent = xLabel;
+  svg.append(label);
+
+  const legend = el("div", { class: "legend" });
+  series.forEach((s, i) => {
+    const color = COLORS[i % COLORS.length];
+    const d = s.points.map((p, j) => `${j ? "L" : "M"}${sx(p[0]).toFixed(1)},${sy(p[1]).toFixed(1)}`).join(" ");
+    svg.append(svgEl("path", { d, fill: "none", stroke: color, "stroke-width": 1.5 }));
+    s.points.forEach((p) => svg.append(svgEl("circle", { cx: sx(p[0]), cy: sy(p[1]), r: 2, fill: color })));
+    const item = el("span", {}, [s.name]);
+    item.style.color = color;
+    legend.append(item);
+  });
+  chart.append(legend);
+  return chart;
+}
+
+function transport(st) {
+  return `${st.scheme}/${st.protocol}:${st.port}`;
+}
+
+function renderLatest(run) {
+  const tbody = document.querySelector("#latest tbody");
+  tbody.replaceChildren();
+  if (!run) {
+    return;
+  }
+  document.getElementById("latest-meta").textContent =
+    `${run.testRunID} on ${run.node || "unknown node"} at ${new Date(run.startedAt).toLocaleString()}`;
+  run.results.forEach((st) => {
+    const connected = el("td", { class: st.connected ? "" : "fail" }, [st.connected ? "yes" : "no"]);
+    tbody.append(el("tr", {}, [
+      el("td", {}, [st.provider]), el("td", {}, [st.scheme]), el("td", {}, [st.protocol]), el("td", {}, [st.port]),
+      connected,
+      el("td", {}, [fmt(st.offererTimeToReceiveCandidate)]), el("td", {}, [fmt(st.timeToConnectedState)]),
+      el("td", {}, [fmt(st.throughputMax)]), el("td", {}, [fmt(st.latencyFirstPacket)]),
+    ]));
+  });
+}
+
+function renderConnectCharts(runs) {
+  // one chart per provider, one series per transport, x is the run start time
+  const byProvider = {};
+  runs.forEach((run) => {
+    const t = new Date(run.startedAt).getTime();
+    run.results.forEach((st) => {
+      if (!st.connected) {
+        return;
+      }
+      const p = (byProvider[st.provider] = byProvider[st.provider] || {});
+      (p[transport(st)] = p[transport(st)] || []).push([t, st.timeToConnectedState]);
+    });
+  });
+
+  const container = document.getElementById("connect-charts");
+  container.replaceChildren();
+  Object.keys(byProvider).sort().forEach((provider) => {
+    const series = Object.entries(byProvider[provider]).map(([name, points]) => ({ name, points }));
+    container.append(lineChart(provider, series, "run time"));
+  });
+}
+
+function renderThroughputCharts(run) {
+  const container = document.getElementById("throughput-charts");
+  container.replaceChildren();
+  if (!run) {
+    return;
+  }
+  run.results.forEach((st) => {
+    const toPoints = (m) => Object.entries(m || {}).map(([k, v]) => [Number(k), v]).sort((a, b) => a[0] - b[0]);
+    const avg = toPoints(st.throughput), inst = toPoints(st.instantThroughput);
+    if (avg.length === 0 && inst.length === 0) {
+      return;
+    }
+    container.append(lineChart(`${st.provider} ${transport(st)}`, [
+      { name: "average", points: avg },
+      { name: "instant", points: inst },
+    ], "ms"));
+  });
+}
+
+function renderFailures(failures) {
+  const tbody = document.querySelector("#failures tbody");
+  tbody.replaceChildren();
+  failures.slice(0, 50).forEach((f) => {
+    tbody.append(el("tr", {}, [
+      el("td", {}, [new Date(f.startedAt).toLocaleString()]), el("td", {}, [f.testRunID]),
+      el("td", {}, [f.provider]), el("td", {}, [f.scheme]), el("td", {}, [f.protocol]), el("td", {}, [f.port]),
+    ]));
+  });
+}
+
+async function refresh() {
+  const [runs, failures] = await Promise.all([
+    fetch("api/runs").then((r) => r.json()),
+    fetch("api/failures").then((r) => r.json()),
+  ]);
+  const latest = runs && runs.length ? runs[runs.length - 1] : null;
+  renderLatest(latest);
+  renderConnectCharts(runs || []);
+  renderThroughputCharts(latest);
+  renderFailures(failures || []);
+  document.getElementById("updated").textContent = `updated ${new Date().toLocaleTimeString()}`;
+}
+
+refresh();
+setInterval(refresh, 30000);
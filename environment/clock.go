@@ -0,0 +1,76 @@
+package environment
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// ntpEpochOffset is the number of seconds from 1900, the NTP epoch, to 1970
+const ntpEpochOffset = 2208988800
+
+// ClockOffset asks an NTP server for the time using SNTP (RFC 4330) and
+// returns how far the local clock is ahead of it. server is a host with an
+// optional port, 123 by default.
+func ClockOffset(ctx context.Context, server string, timeout time.Duration) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", server)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return 0, err
+	}
+
+	// version 4, client mode, with the transmit time to match the response
+	req := make([]byte, 48)
+	req[0] = 4<<3 | 3
+	t1 := time.Now()
+	binary.BigEndian.PutUint64(req[40:], toNTP(t1))
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	t4 := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	if n < 48 {
+		return 0, errors.New("short NTP response")
+	}
+	if mode := resp[0] & 0x7; mode != 4 {
+		return 0, errors.New("not an NTP server response")
+	}
+	if resp[1] == 0 {
+		return 0, errors.New("NTP server sent a kiss-o'-death")
+	}
+	if binary.BigEndian.Uint64(resp[24:]) != binary.BigEndian.Uint64(req[40:]) {
+		return 0, errors.New("NTP response doesn't match the request")
+	}
+
+	t2 := fromNTP(binary.BigEndian.Uint64(resp[32:]))
+	t3 := fromNTP(binary.BigEndian.Uint64(resp[40:]))
+	// the server is ahead by ((t2 - t1) + (t3 - t4)) / 2
+	serverAhead := (t2.Sub(t1) + t3.Sub(t4)) / 2
+	return -serverAhead, nil
+}
+
+func toNTP(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+func fromNTP(v uint64) time.Time {
+	secs := int64(v>>32) - ntpEpochOffset
+	nanos := int64((v & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(secs, nanos)
+}
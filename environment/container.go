@@ -0,0 +1,43 @@
+package environment
+
+import (
+	"os"
+	"strings"
+)
+
+// Container returns the container runtime the agent runs in, or "" when it
+// doesn't look like it runs in one
+func Container() string {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return "kubernetes"
+	}
+	if _, err := os.Stat("/run/.containerenv"); err == nil {
+		return "podman"
+	}
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		return "docker"
+	}
+	cgroup, err := os.ReadFile("/proc/1/cgroup")
+	if err != nil {
+		return ""
+	}
+	return containerFromCgroup(string(cgroup))
+}
+
+// containerFromCgroup finds the container runtime in the contents of
+// /proc/1/cgroup. With cgroup v2 it often only shows 0::/, in which case
+// the files checked by Container are all there is to go on.
+func containerFromCgroup(cgroup string) string {
+	for _, c := range []struct{ marker, runtime string }{
+		{"kubepods", "kubernetes"},
+		{"docker", "docker"},
+		{"libpod", "podman"},
+		{"containerd", "containerd"},
+		{"lxc", "lxc"},
+	} {
+		if strings.Contains(cgroup, c.marker) {
+			return c.runtime
+		}
+	}
+	return ""
+}
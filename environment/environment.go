@@ -0,0 +1,103 @@
+// Package environment collects metadata about the host the agent runs on, so
+// results from different agents can be filtered and explained
+package environment
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+// Collect fills in env with the public IP and ASN, interfaces, container
+// runtime and clock offset. Everything that can be found is kept, the
+// errors returned are for the parts that couldn't.
+func Collect(ctx context.Context, cfg config.EnvironmentConfig, env *stats.Environment) []error {
+	var errs []error
+
+	interfaces, mtu, err := Interfaces()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("listing interfaces: %w", err))
+	}
+	env.Interfaces = interfaces
+	env.MTU = mtu
+	env.Container = Container()
+
+	if cfg.LookupURL != "" {
+		client := &http.Client{Timeout: 5 * time.Second}
+		info, err := LookupPublicIP(ctx, client, cfg.LookupURL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("looking up public IP: %w", err))
+		} else {
+			env.PublicIP = info.IP
+			env.ASN = info.ASN
+			env.ASOrg = info.Org
+		}
+	}
+
+	if cfg.NTPServer != "" {
+		offset, err := ClockOffset(ctx, cfg.NTPServer, 3*time.Second)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("checking clock offset: %w", err))
+		} else {
+			env.ClockOffset = float64(offset.Microseconds()) / 1000
+			env.NTPServer = cfg.NTPServer
+		}
+	}
+	return errs
+}
+
+// Interfaces lists the network interfaces that are up, other than loopback,
+// and returns the MTU of the one used to reach the internet
+func Interfaces() ([]stats.Interface, int, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	outbound := outboundIP()
+	mtu := 0
+	var list []stats.Interface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		list = append(list, stats.Interface{
+			Name: iface.Name,
+			Type: interfaceType(iface),
+			MTU:  iface.MTU,
+		})
+		if outbound != nil && hasAddr(iface, outbound) {
+			mtu = iface.MTU
+		}
+	}
+	return list, mtu, nil
+}
+
+// outboundIP returns the local address used to reach the internet, without
+// sending anything
+func outboundIP() net.IP {
+	c, err := net.Dial("udp4", "192.0.2.1:9")
+	if err != nil {
+		return nil
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).IP
+}
+
+func hasAddr(iface net.Interface, ip net.IP) bool {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package environment
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestParsePublicIP(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		body map[string]any
+		want PublicIPInfo
+	}{
+		{"ipinfo.io", map[string]any{"ip": "198.51.100.7", "org": "AS13335 Cloudflare, Inc."},
+			PublicIPInfo{IP: "198.51.100.7", ASN: "AS13335", Org: "Cloudflare, Inc."}},
+		{"ipapi.co", map[string]any{"ip": "198.51.100.7", "asn": "AS15169", "org": "GOOGLE"},
+			PublicIPInfo{IP: "198.51.100.7", ASN: "AS15169", Org: "GOOGLE"}},
+		{"ip-api.com", map[string]any{"query": "198.51.100.7", "as": "AS3320 Deutsche Telekom AG", "isp": "Deutsche Telekom AG"},
+			PublicIPInfo{IP: "198.51.100.7", ASN: "AS3320", Org: "Deutsche Telekom AG"}},
+		{"numeric asn", map[string]any{"ip": "198.51.100.7", "asn": float64(64496)},
+			PublicIPInfo{IP: "198.51.100.7", ASN: "AS64496"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, *parsePublicIP(tt.body))
+		})
+	}
+}
+
+func TestContainerFromCgroup(t *testing.T) {
+	assert.Equal(t, "docker", containerFromCgroup("12:pids:/docker/3f2a9c\n"))
+	assert.Equal(t, "kubernetes", containerFromCgroup("0::/kubepods/besteffort/pod1234/abcd\n"))
+	assert.Equal(t, "", containerFromCgroup("0::/init.scope\n"))
+}
+
+func TestClockOffset(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// a server whose clock is two seconds ahead
+	go func() {
+		req := make([]byte, 48)
+		_, from, err := conn.ReadFromUDP(req)
+		if err != nil {
+			return
+		}
+		resp := make([]byte, 48)
+		resp[0] = 4<<3 | 4
+		resp[1] = 2
+		copy(resp[24:32], req[40:48])
+		now := toNTP(time.Now().Add(2 * time.Second))
+		binary.BigEndian.PutUint64(resp[32:], now)
+		binary.BigEndian.PutUint64(resp[40:], now)
+		conn.WriteToUDP(resp, from)
+	}()
+
+	offset, err := ClockOffset(context.Background(), conn.LocalAddr().String(), time.Second)
+	assert.NoError(t, err)
+	assert.True(t, offset < -1900*time.Millisecond && offset > -2100*time.Millisecond, "offset %s", offset)
+}
+
+func TestNTPTimestamps(t *testing.T) {
+	now := time.Unix(1700000000, 123456789)
+	assert.True(t, fromNTP(toNTP(now)).Sub(now).Abs() < time.Microsecond)
+}
@@ -0,0 +1,75 @@
+package environment
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sysClassNet is where Linux describes its network interfaces
+const sysClassNet = "/sys/class/net"
+
+// interfaceType guesses what kind of link an interface is, from sysfs on
+// Linux and from common interface names elsewhere
+func interfaceType(iface net.Interface) string {
+	dir := filepath.Join(sysClassNet, iface.Name)
+	if _, err := os.Stat(filepath.Join(dir, "wireless")); err == nil {
+		return "wifi"
+	}
+	if t := typeFromName(iface.Name); t != "unknown" {
+		return t
+	}
+	if iface.Flags&net.FlagPointToPoint != 0 {
+		return "tunnel"
+	}
+	if _, err := os.Stat(dir); err == nil {
+		// interfaces without a device behind them are virtual
+		if _, err := os.Stat(filepath.Join(dir, "device")); err != nil {
+			return "virtual"
+		}
+		return "ethernet"
+	}
+	return "unknown"
+}
+
+var namePrefixes = []struct {
+	prefix string
+	kind   string
+}{
+	{"wlan", "wifi"},
+	{"wlp", "wifi"},
+	{"wl", "wifi"},
+	{"wwan", "cellular"},
+	{"rmnet", "cellular"},
+	{"pdp_ip", "cellular"},
+	{"tun", "tunnel"},
+	{"tap", "tunnel"},
+	{"utun", "tunnel"},
+	{"wg", "tunnel"},
+	{"ppp", "tunnel"},
+	{"ipsec", "tunnel"},
+	{"docker", "virtual"},
+	{"br-", "virtual"},
+	{"veth", "virtual"},
+	{"virbr", "virtual"},
+	{"vmnet", "virtual"},
+	{"vboxnet", "virtual"},
+	{"cni", "virtual"},
+	{"flannel", "virtual"},
+	{"cali", "virtual"},
+	{"eth", "ethernet"},
+	{"eno", "ethernet"},
+	{"ens", "ethernet"},
+	{"enp", "ethernet"},
+	{"enx", "ethernet"},
+}
+
+func typeFromName(name string) string {
+	for _, p := range namePrefixes {
+		if strings.HasPrefix(name, p.prefix) {
+			return p.kind
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,88 @@
+package environment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// PublicIPInfo is the agent's public IP and the autonomous system it is in
+type PublicIPInfo struct {
+	IP  string
+	ASN string
+	Org string
+}
+
+var asnPattern = regexp.MustCompile(`^AS\d+`)
+
+// LookupPublicIP fetches url and reads the public IP and ASN from the JSON
+// it returns. The field names of the common services are understood:
+// ipinfo.io (ip, org "AS13335 Cloudflare"), ipapi.co (ip, asn, org) and
+// ip-api.com (query, as, isp).
+func LookupPublicIP(ctx context.Context, client *http.Client, url string) (*PublicIPInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status code %d", url, res.StatusCode)
+	}
+
+	var body map[string]any
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	info := parsePublicIP(body)
+	if info.IP == "" {
+		return nil, fmt.Errorf("no IP address in the response from %s", url)
+	}
+	return info, nil
+}
+
+func parsePublicIP(body map[string]any) *PublicIPInfo {
+	str := func(keys ...string) string {
+		for _, k := range keys {
+			switch v := body[k].(type) {
+			case string:
+				if v != "" {
+					return v
+				}
+			case float64:
+				return fmt.Sprintf("%.0f", v)
+			}
+		}
+		return ""
+	}
+
+	info := &PublicIPInfo{IP: str("ip", "query")}
+	info.ASN = str("asn")
+	if info.ASN != "" && !strings.HasPrefix(info.ASN, "AS") {
+		info.ASN = "AS" + info.ASN
+	}
+	// ipinfo.io and ip-api.com put the ASN in front of the org name
+	for _, s := range []string{str("org"), str("as"), str("isp")} {
+		asn := asnPattern.FindString(s)
+		if asn == "" {
+			continue
+		}
+		if info.ASN == "" {
+			info.ASN = asn
+		}
+		if info.Org == "" {
+			info.Org = strings.TrimSpace(strings.TrimPrefix(s, asn))
+		}
+	}
+	if info.Org == "" {
+		info.Org = str("org", "isp")
+	}
+	return info
+}
@@ -0,0 +1,186 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nimbleape/iceperf-agent/stats"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	runsBucket  = []byte("runs")
+	runIDBucket = []byte("runIDs")
+
+	// ErrNotFound is returned when a run isn't in the store
+	ErrNotFound = errors.New("run not found")
+)
+
+// Store keeps every test run in a local bbolt database. The database is only
+// opened for the duration of each operation so that the history command can
+// read it while an agent in timer mode is running.
+type Store struct {
+	Path string
+}
+
+// Query selects runs from the store. Zero values mean no limit.
+type Query struct {
+	// Since only includes runs started after this time
+	Since time.Time
+	// LastRuns only includes the most recent N runs
+	LastRuns int
+	// Provider only includes results from this provider
+	Provider string
+}
+
+// DefaultPath returns the database location used when none is configured
+func DefaultPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "iceperf", "history.db")
+}
+
+func New(path string) *Store {
+	if path == "" {
+		path = DefaultPath()
+	}
+	return &Store{
+		Path: path,
+	}
+}
+
+func (s *Store) open(readOnly bool) (*bolt.DB, error) {
+	if !readOnly {
+		if err := os.MkdirAll(filepath.Dir(s.Path), 0o755); err != nil {
+			return nil, err
+		}
+	} else if _, err := os.Stat(s.Path); err != nil {
+		return nil, err
+	}
+	return bolt.Open(s.Path, 0o644, &bolt.Options{
+		Timeout:  10 * time.Second,
+		ReadOnly: readOnly,
+	})
+}
+
+// runKey sorts runs by start time
+func runKey(run *stats.Run) []byte {
+	return []byte(run.StartedAt.UTC().Format("20060102T150405.000000000Z") + "-" + run.TestRunID)
+}
+
+// Write stores a run with its results, so the Store can be used as a sink
+func (s *Store) Write(ctx context.Context, run *stats.Run, results []*stats.Stats) error {
+	doc := *run
+	doc.Results = results
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	db, err := s.open(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		runs, err := tx.CreateBucketIfNotExists(runsBucket)
+		if err != nil {
+			return err
+		}
+		ids, err := tx.CreateBucketIfNotExists(runIDBucket)
+		if err != nil {
+			return err
+		}
+		key := runKey(run)
+		if err := runs.Put(key, data); err != nil {
+			return err
+		}
+		return ids.Put([]byte(run.TestRunID), key)
+	})
+}
+
+// Run returns a single run by its test run ID
+func (s *Store) Run(testRunID string) (*stats.Run, error) {
+	db, err := s.open(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var run *stats.Run
+	err = db.View(func(tx *bolt.Tx) error {
+		ids := tx.Bucket(runIDBucket)
+		runs := tx.Bucket(runsBucket)
+		if ids == nil || runs == nil {
+			return ErrNotFound
+		}
+		key := ids.Get([]byte(testRunID))
+		if key == nil {
+			return ErrNotFound
+		}
+		run = &stats.Run{}
+		return json.Unmarshal(runs.Get(key), run)
+	})
+	return run, err
+}
+
+// Runs returns the runs matching q, oldest first
+func (s *Store) Runs(q Query) ([]*stats.Run, error) {
+	db, err := s.open(true)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var runs []*stats.Run
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(runsBucket)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		// walk backwards from the newest run
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if q.LastRuns > 0 && len(runs) >= q.LastRuns {
+				break
+			}
+			run := &stats.Run{}
+			if err := json.Unmarshal(v, run); err != nil {
+				return err
+			}
+			if !q.Since.IsZero() && run.StartedAt.Before(q.Since) {
+				break
+			}
+			if q.Provider != "" {
+				filtered := []*stats.Stats{}
+				for _, st := range run.Results {
+					if st.Provider == q.Provider {
+						filtered = append(filtered, st)
+					}
+				}
+				if len(filtered) == 0 {
+					continue
+				}
+				run.Results = filtered
+			}
+			runs = append(runs, run)
+		}
+		return nil
+	})
+
+	// reverse into chronological order
+	for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+		runs[i], runs[j] = runs[j], runs[i]
+	}
+	return runs, err
+}
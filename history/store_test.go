@@ -0,0 +1,81 @@
+package history
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+func result(id string, startedAt time.Time, provider, family string, connected bool, timeToConnected int64) *stats.Stats {
+	st := stats.NewStats(id, startedAt)
+	st.SetProvider(provider)
+	st.SetScheme("turn")
+	st.SetProtocol("udp")
+	st.SetURL("turn:" + provider + ".example.com:3478?transport=udp")
+	st.SetAddressFamily(family)
+	if connected {
+		st.SetTimeToConnectedState(timeToConnected)
+	}
+	return st
+}
+
+func storeRun(t *testing.T, s *Store, id string, startedAt time.Time, provider string, connected bool, timeToConnected int64) {
+	run := stats.NewRun(id, startedAt)
+	run.AddResult(result(id, startedAt, provider, "ipv4", connected, timeToConnected))
+	assert.NoError(t, s.Write(context.Background(), run, run.Results))
+}
+
+func TestStoreQueriesAndTrends(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "history.db"))
+	start := time.Now().Add(-10 * time.Hour)
+
+	storeRun(t, s, "run1", start, "cloudflare", true, 100)
+	storeRun(t, s, "run2", start.Add(time.Hour), "cloudflare", false, 0)
+	storeRun(t, s, "run3", start.Add(2*time.Hour), "cloudflare", true, 300)
+	storeRun(t, s, "run4", start.Add(3*time.Hour), "twilio", true, 50)
+
+	runs, err := s.Runs(Query{})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(runs))
+	assert.Equal(t, "run1", runs[0].TestRunID)
+
+	runs, err = s.Runs(Query{LastRuns: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"run3", "run4"}, []string{runs[0].TestRunID, runs[1].TestRunID})
+
+	runs, err = s.Runs(Query{Since: start.Add(90 * time.Minute), Provider: "cloudflare"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(runs))
+
+	run, err := s.Run("run2")
+	assert.NoError(t, err)
+	assert.False(t, run.Results[0].Connected)
+
+	runs, err = s.Runs(Query{Provider: "cloudflare"})
+	assert.NoError(t, err)
+	trends := Trends(runs)
+	assert.Equal(t, 1, len(trends))
+	assert.Equal(t, 3, trends[0].Tests)
+	assert.Equal(t, 2.0/3.0, trends[0].SuccessRate)
+	assert.Equal(t, 200.0, trends[0].MedianTimeToConnected)
+}
+
+func TestTrendsPerURLAndFamily(t *testing.T) {
+	start := time.Now()
+	run := stats.NewRun("run", start)
+	run.AddResult(result("run", start, "cloudflare", "ipv4", true, 100))
+	run.AddResult(result("run", start, "cloudflare", "ipv6", false, 0))
+	run.AddResult(result("run", start, "cloudflare", "ipv4", true, 300))
+
+	trends := Trends([]*stats.Run{run})
+	assert.Equal(t, 2, len(trends))
+	assert.Equal(t, "ipv4", trends[0].AddressFamily)
+	assert.Equal(t, 2, trends[0].Tests)
+	assert.Equal(t, 1.0, trends[0].SuccessRate)
+	assert.Equal(t, "ipv6", trends[1].AddressFamily)
+	assert.Equal(t, 0.0, trends[1].SuccessRate)
+}
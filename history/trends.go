@@ -0,0 +1,99 @@
+package history
+
+import (
+	"sort"
+
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+// Trend summarises the results for one ICE server URL and address family over
+// a set of runs
+type Trend struct {
+	Provider              string  `json:"provider"`
+	Scheme                string  `json:"scheme"`
+	Protocol              string  `json:"protocol"`
+	URL                   string  `json:"url,omitempty"`
+	AddressFamily         string  `json:"addressFamily,omitempty"`
+	AnswererURL           string  `json:"answererUrl,omitempty"`
+	Tests                 int     `json:"tests"`
+	SuccessRate           float64 `json:"successRate"`
+	MedianTimeToConnected float64 `json:"medianTimeToConnected"`
+	P95TimeToConnected    float64 `json:"p95TimeToConnected"`
+	MedianTimeToCandidate float64 `json:"medianTimeToCandidate"`
+	MedianThroughputMax   float64 `json:"medianThroughputMax"`
+	MedianLatencyFirstPkt float64 `json:"medianLatencyFirstPacket"`
+
+	connected          int
+	timeToConnected    []float64
+	timeToCandidate    []float64
+	throughputMax      []float64
+	latencyFirstPacket []float64
+}
+
+// Trends groups the results of runs by URL and address family, as
+// stats.AggregateResults does. Timing percentiles only include tests that
+// connected.
+func Trends(runs []*stats.Run) []*Trend {
+	byKey := make(map[string]*Trend)
+	for _, run := range runs {
+		for _, st := range run.Results {
+			key := st.Provider + "|" + st.URL + "|" + st.AddressFamily + "|" + st.AnswererURL
+			t, ok := byKey[key]
+			if !ok {
+				t = &Trend{
+					Provider:      st.Endpoints(),
+					Scheme:        st.Scheme,
+					Protocol:      st.Protocol,
+					URL:           st.URL,
+					AddressFamily: st.AddressFamily,
+					AnswererURL:   st.AnswererURL,
+				}
+				byKey[key] = t
+			}
+			t.Tests++
+			if !st.Connected {
+				continue
+			}
+			t.connected++
+			t.timeToConnected = append(t.timeToConnected, float64(st.TimeToConnectedState))
+			t.timeToCandidate = append(t.timeToCandidate, st.OffererTimeToReceiveCandidate)
+			if len(st.Throughput) > 0 {
+				t.throughputMax = append(t.throughputMax, st.ThroughputMax)
+			}
+			if st.LatencyFirstPacket > 0 {
+				t.latencyFirstPacket = append(t.latencyFirstPacket, st.LatencyFirstPacket)
+			}
+		}
+	}
+
+	trends := make([]*Trend, 0, len(byKey))
+	for _, t := range byKey {
+		t.SuccessRate = float64(t.connected) / float64(t.Tests)
+		t.MedianTimeToConnected = stats.Median(t.timeToConnected)
+		t.P95TimeToConnected = stats.Percentile(t.timeToConnected, 95)
+		t.MedianTimeToCandidate = stats.Median(t.timeToCandidate)
+		t.MedianThroughputMax = stats.Median(t.throughputMax)
+		t.MedianLatencyFirstPkt = stats.Median(t.latencyFirstPacket)
+		trends = append(trends, t)
+	}
+
+	sort.Slice(trends, func(i, j int) bool {
+		if trends[i].Provider != trends[j].Provider {
+			return trends[i].Provider < trends[j].Provider
+		}
+		if trends[i].Scheme != trends[j].Scheme {
+			return trends[i].Scheme < trends[j].Scheme
+		}
+		if trends[i].Protocol != trends[j].Protocol {
+			return trends[i].Protocol < trends[j].Protocol
+		}
+		if trends[i].URL != trends[j].URL {
+			return trends[i].URL < trends[j].URL
+		}
+		if trends[i].AddressFamily != trends[j].AddressFamily {
+			return trends[i].AddressFamily < trends[j].AddressFamily
+		}
+		return trends[i].AnswererURL < trends[j].AnswererURL
+	})
+	return trends
+}
@@ -0,0 +1,276 @@
+// Package nat discovers the behavior of the NAT the agent is behind, using
+// the tests from RFC 5780 against a STUN server that supports them, i.e.
+// one that returns OTHER-ADDRESS and honors CHANGE-REQUEST.
+package nat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pion/stun/v2"
+)
+
+// DefaultServer is a public STUN server supporting RFC 5780
+const DefaultServer = "stun.stunprotocol.org:3478"
+
+// Behavior describes how a NAT maps or filters, see RFC 4787
+type Behavior string
+
+const (
+	EndpointIndependent     Behavior = "endpoint-independent"
+	AddressDependent        Behavior = "address-dependent"
+	AddressAndPortDependent Behavior = "address-and-port-dependent"
+	Unknown                 Behavior = "unknown"
+)
+
+// Result is what the probe found out about the NAT
+type Result struct {
+	Server        string `json:"server"`
+	LocalAddress  string `json:"localAddress,omitempty"`
+	PublicAddress string `json:"publicAddress,omitempty"`
+	PublicIP      string `json:"publicIP,omitempty"`
+	// NAT is false when the public address is the local address
+	NAT       bool     `json:"nat"`
+	Mapping   Behavior `json:"mapping"`
+	Filtering Behavior `json:"filtering"`
+	// Hairpinning is whether a packet sent to the agent's own public
+	// address comes back to it, nil when it wasn't tested
+	Hairpinning *bool `json:"hairpinning,omitempty"`
+	// Error is set when the probe couldn't finish, e.g. because the server
+	// doesn't support RFC 5780
+	Error string `json:"error,omitempty"`
+}
+
+var (
+	errTimeout     = errors.New("no response from STUN server")
+	errUnsupported = errors.New("STUN server doesn't support RFC 5780, no OTHER-ADDRESS in its response")
+)
+
+// CHANGE-REQUEST flags, RFC 5780 section 7.2
+const (
+	changeIP   = 0x04
+	changePort = 0x02
+)
+
+// Probe runs the RFC 5780 mapping, filtering and hairpinning tests against
+// server, a host:port. Each request is retried until timeout. The result
+// holds whatever was found before an error.
+func Probe(ctx context.Context, server string, timeout time.Duration) (*Result, error) {
+	if server == "" {
+		server = DefaultServer
+	}
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
+	res := &Result{Server: server, Mapping: Unknown, Filtering: Unknown}
+
+	serverAddr, err := net.ResolveUDPAddr("udp4", server)
+	if err != nil {
+		return res, err
+	}
+	localIP, err := outboundIP(serverAddr)
+	if err != nil {
+		return res, err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		return res, err
+	}
+	defer conn.Close()
+	p := &prober{ctx: ctx, conn: conn, timeout: timeout}
+	res.LocalAddress = conn.LocalAddr().String()
+
+	// test I: the mapped address as seen from the primary address
+	resp, err := p.request(serverAddr, 0)
+	if err != nil {
+		return res, err
+	}
+	mapped1, err := mappedAddress(resp)
+	if err != nil {
+		return res, err
+	}
+	res.PublicAddress = mapped1.String()
+	res.PublicIP = mapped1.IP.String()
+	if mapped1.String() == res.LocalAddress {
+		// no NAT, the mapping and filtering are those of the host
+		res.Mapping = EndpointIndependent
+		res.Filtering = EndpointIndependent
+		return res, nil
+	}
+	res.NAT = true
+
+	var other stun.OtherAddress
+	if err := other.GetFrom(resp); err != nil {
+		return res, errUnsupported
+	}
+	otherAddr := &net.UDPAddr{IP: other.IP, Port: other.Port}
+
+	// mapping test II: the alternate address with the primary port
+	resp, err = p.request(&net.UDPAddr{IP: other.IP, Port: serverAddr.Port}, 0)
+	if err != nil {
+		return res, err
+	}
+	mapped2, err := mappedAddress(resp)
+	if err != nil {
+		return res, err
+	}
+	if mapped2.String() == mapped1.String() {
+		res.Mapping = EndpointIndependent
+	} else {
+		// mapping test III: the alternate address and port
+		resp, err = p.request(otherAddr, 0)
+		if err != nil {
+			return res, err
+		}
+		mapped3, err := mappedAddress(resp)
+		if err != nil {
+			return res, err
+		}
+		if mapped3.String() == mapped2.String() {
+			res.Mapping = AddressDependent
+		} else {
+			res.Mapping = AddressAndPortDependent
+		}
+	}
+
+	// the mapping tests opened the NAT to the alternate address, so the
+	// filtering tests run on a socket that has only sent to the primary one
+	fconn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		return res, err
+	}
+	defer fconn.Close()
+	fp := &prober{ctx: ctx, conn: fconn, timeout: timeout}
+
+	// filtering test II: ask for the response from the alternate address
+	// and port, then test III: from the alternate port only
+	if _, err := fp.request(serverAddr, changeIP|changePort); err == nil {
+		res.Filtering = EndpointIndependent
+	} else if !errors.Is(err, errTimeout) {
+		return res, err
+	} else if _, err := fp.request(serverAddr, changePort); err == nil {
+		res.Filtering = AddressDependent
+	} else if errors.Is(err, errTimeout) {
+		res.Filtering = AddressAndPortDependent
+	} else {
+		return res, err
+	}
+
+	// a hairpinning test that can't be sent leaves it unknown rather than
+	// failing the whole probe
+	if hairpinning, err := p.hairpinning(localIP, mapped1); err == nil {
+		res.Hairpinning = &hairpinning
+	}
+	return res, nil
+}
+
+// outboundIP returns the local address used to reach addr
+func outboundIP(addr *net.UDPAddr) (net.IP, error) {
+	c, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+func mappedAddress(m *stun.Message) (*net.UDPAddr, error) {
+	var xor stun.XORMappedAddress
+	if err := xor.GetFrom(m); err == nil {
+		return &net.UDPAddr{IP: xor.IP, Port: xor.Port}, nil
+	}
+	var mapped stun.MappedAddress
+	if err := mapped.GetFrom(m); err != nil {
+		return nil, fmt.Errorf("no mapped address in STUN response: %w", err)
+	}
+	return &net.UDPAddr{IP: mapped.IP, Port: mapped.Port}, nil
+}
+
+type prober struct {
+	ctx     context.Context
+	conn    *net.UDPConn
+	timeout time.Duration
+}
+
+// request sends a binding request to addr, resending it a few times until
+// a response arrives or the timeout passes
+func (p *prober) request(addr *net.UDPAddr, change byte) (*stun.Message, error) {
+	setters := []stun.Setter{stun.TransactionID, stun.BindingRequest}
+	if change != 0 {
+		setters = append(setters, stun.RawAttribute{Type: stun.AttrChangeRequest, Value: []byte{0, 0, 0, change}})
+	}
+	req, err := stun.Build(setters...)
+	if err != nil {
+		return nil, err
+	}
+
+	const attempts = 3
+	for i := 0; i < attempts; i++ {
+		if _, err := p.conn.WriteToUDP(req.Raw, addr); err != nil {
+			return nil, err
+		}
+		resp, err := p.read(req.TransactionID, p.timeout/attempts)
+		if err == nil || !errors.Is(err, errTimeout) {
+			return resp, err
+		}
+	}
+	return nil, errTimeout
+}
+
+// read waits for a STUN message with the given transaction ID, ignoring
+// anything else
+func (p *prober) read(id [stun.TransactionIDSize]byte, wait time.Duration) (*stun.Message, error) {
+	deadline := time.Now().Add(wait)
+	if d, ok := p.ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := p.conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		if err := p.ctx.Err(); err != nil {
+			return nil, err
+		}
+		n, _, err := p.conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return nil, errTimeout
+			}
+			return nil, err
+		}
+		m := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+		if m.Decode() != nil || m.TransactionID != id {
+			continue
+		}
+		return m, nil
+	}
+}
+
+// hairpinning sends a binding request from a second socket to the public
+// address of the first, and reports whether the NAT loops it back
+func (p *prober) hairpinning(localIP net.IP, public *net.UDPAddr) (bool, error) {
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		return false, err
+	}
+	defer c.Close()
+
+	req, err := stun.Build(stun.TransactionID, stun.BindingRequest)
+	if err != nil {
+		return false, err
+	}
+	if _, err := c.WriteToUDP(req.Raw, public); err != nil {
+		return false, err
+	}
+	_, err = p.read(req.TransactionID, p.timeout)
+	if errors.Is(err, errTimeout) {
+		return false, nil
+	}
+	return err == nil, err
+}
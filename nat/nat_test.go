@@ -0,0 +1,141 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/pion/stun/v2"
+)
+
+// fakeServer answers on two ports of two loopback addresses, reporting the
+// mapped addresses a NAT with the given mapping would. Like the NAT, it only
+// lets a response through when its filtering allows it, given the server
+// addresses and ports the client's socket has sent to.
+type fakeServer struct {
+	conns     [2][2]*net.UDPConn // [address][port]
+	mapping   Behavior
+	filtering Behavior
+
+	mu sync.Mutex
+	// sent holds the server addresses and ports each client has sent to
+	sent map[string]map[[2]int]bool
+}
+
+func newFakeServer(t *testing.T, mapping, filtering Behavior) *fakeServer {
+	t.Helper()
+	s := &fakeServer{mapping: mapping, filtering: filtering, sent: make(map[string]map[[2]int]bool)}
+	var ports [2]int
+	for i, ip := range []string{"127.0.0.1", "127.0.0.2"} {
+		for j := range ports {
+			c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP(ip), Port: ports[j]})
+			if err != nil {
+				t.Skipf("can't listen on %s: %v", ip, err)
+			}
+			ports[j] = c.LocalAddr().(*net.UDPAddr).Port
+			s.conns[i][j] = c
+			t.Cleanup(func() { c.Close() })
+		}
+	}
+	for i := range s.conns {
+		for j := range s.conns[i] {
+			go s.serve(i, j)
+		}
+	}
+	return s
+}
+
+func (s *fakeServer) addr() string {
+	return s.conns[0][0].LocalAddr().String()
+}
+
+func (s *fakeServer) serve(i, j int) {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := s.conns[i][j].ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		req := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+		if req.Decode() != nil {
+			continue
+		}
+
+		ri, rj := i, j
+		if v, err := req.Get(stun.AttrChangeRequest); err == nil && len(v) == 4 {
+			if v[3]&changeIP != 0 {
+				ri = 1 - i
+			}
+			if v[3]&changePort != 0 {
+				rj = 1 - j
+			}
+		}
+		if !s.allowed(from, i, j, ri, rj) {
+			continue
+		}
+
+		port := 40000
+		switch s.mapping {
+		case AddressDependent:
+			port += i
+		case AddressAndPortDependent:
+			port += 2*i + j
+		}
+		other := s.conns[1][1].LocalAddr().(*net.UDPAddr)
+		resp := stun.MustBuild(req, stun.BindingSuccess,
+			&stun.XORMappedAddress{IP: net.ParseIP("127.0.0.3"), Port: port},
+			&stun.OtherAddress{IP: other.IP, Port: other.Port},
+		)
+		s.conns[ri][rj].WriteToUDP(resp.Raw, from)
+	}
+}
+
+// allowed records that from sent to the server's address i and port j, and
+// reports whether the NAT would let a response from address ri and port rj
+// through
+func (s *fakeServer) allowed(from *net.UDPAddr, i, j, ri, rj int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sent, ok := s.sent[from.String()]
+	if !ok {
+		sent = make(map[[2]int]bool)
+		s.sent[from.String()] = sent
+	}
+	sent[[2]int{i, j}] = true
+
+	switch s.filtering {
+	case AddressDependent:
+		return sent[[2]int{ri, 0}] || sent[[2]int{ri, 1}]
+	case AddressAndPortDependent:
+		return sent[[2]int{ri, rj}]
+	}
+	return true
+}
+
+func TestProbe(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		mapping, filtering Behavior
+	}{
+		{"full cone", EndpointIndependent, EndpointIndependent},
+		{"restricted cone", EndpointIndependent, AddressDependent},
+		{"port restricted cone", EndpointIndependent, AddressAndPortDependent},
+		{"address dependent", AddressDependent, AddressDependent},
+		{"symmetric", AddressAndPortDependent, AddressAndPortDependent},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newFakeServer(t, tc.mapping, tc.filtering)
+			res, err := Probe(context.Background(), s.addr(), 300*time.Millisecond)
+			assert.NoError(t, err)
+			assert.True(t, res.NAT)
+			assert.Equal(t, "127.0.0.3", res.PublicIP)
+			assert.Equal(t, tc.mapping, res.Mapping)
+			assert.Equal(t, tc.filtering, res.Filtering)
+			assert.NotZero(t, res.Hairpinning)
+			assert.False(t, *res.Hairpinning)
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+// Event kinds
+const (
+	EventFailing   = "failing"
+	EventRecovered = "recovered"
+	EventThreshold = "threshold"
+)
+
+// DefaultTemplate is used for targets without their own template
+const DefaultTemplate = `ICEPerf: {{.Provider}} {{.Transport}}{{with .URL}} {{.}}{{end}}{{with .Address}} ({{.}}){{end}} {{if eq .Event "failing"}}is failing on {{.Node}} ({{.Failures}} failed tests){{else if eq .Event "recovered"}}has recovered on {{.Node}}{{else}}{{.Metric}} was {{printf "%.1f" .Value}}, threshold {{printf "%.1f" .Threshold}} on {{.Node}}{{end}}`
+
+// Notification is the data available to message templates
+type Notification struct {
+	Event     string    `json:"event"`
+	Provider  string    `json:"provider"`
+	Scheme    string    `json:"scheme"`
+	Protocol  string    `json:"protocol"`
+	Transport string    `json:"transport"`
+	URL       string    `json:"url,omitempty"`
+	Address   string    `json:"address,omitempty"`
+	Family    string    `json:"family,omitempty"`
+	Answerer  string    `json:"answerer,omitempty"`
+	Node      string    `json:"node"`
+	TestRunID string    `json:"testRunID"`
+	Failures  int       `json:"failures,omitempty"`
+	Metric    string    `json:"metric,omitempty"`
+	Value     float64   `json:"value,omitempty"`
+	Threshold float64   `json:"threshold,omitempty"`
+	Time      time.Time `json:"time"`
+	Message   string    `json:"message"`
+
+	// set when the notification is due, so the send can be undone if no
+	// target receives it
+	dedupKey string
+	prevSent time.Time
+	state    *state
+}
+
+type state struct {
+	failing  bool
+	failures int
+	// notified is set when the failing notification was sent, so recovering
+	// is only notified after it
+	notified bool
+}
+
+// Notifier tracks the health of each tested URL and address across test runs and
+// posts to the configured targets when it changes or a threshold is crossed
+type Notifier struct {
+	config    *config.NotificationsConfig
+	logger    *slog.Logger
+	client    *http.Client
+	templates []*template.Template
+
+	mu       sync.Mutex
+	states   map[string]*state
+	lastSent map[string]time.Time
+	now      func() time.Time
+}
+
+// New creates a Notifier, parsing every target's template up front
+func New(cfg *config.NotificationsConfig, logger *slog.Logger) (*Notifier, error) {
+	n := &Notifier{
+		config:   cfg,
+		logger:   logger.With("component", "notify"),
+		client:   &http.Client{Timeout: 30 * time.Second},
+		states:   make(map[string]*state),
+		lastSent: make(map[string]time.Time),
+		now:      time.Now,
+	}
+
+	for i, t := range cfg.Targets {
+		switch t.Type {
+		case "webhook", "slack", "teams":
+		default:
+			return nil, fmt.Errorf("unknown notification target type %q", t.Type)
+		}
+		text := t.Template
+		if text == "" {
+			text = DefaultTemplate
+		}
+		tmpl, err := template.New(fmt.Sprintf("target%d", i)).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("notification target %d template: %w", i, err)
+		}
+		n.templates = append(n.templates, tmpl)
+	}
+
+	return n, nil
+}
+
+func (n *Notifier) failureThreshold() int {
+	if n.config.FailureThreshold > 0 {
+		return n.config.FailureThreshold
+	}
+	return 1
+}
+
+func (n *Notifier) cooldown() time.Duration {
+	if n.config.Cooldown > 0 {
+		return time.Duration(n.config.Cooldown) * time.Second
+	}
+	return time.Hour
+}
+
+// Write evaluates a run's results, so the Notifier can be used as a sink
+func (n *Notifier) Write(ctx context.Context, run *stats.Run, results []*stats.Stats) error {
+	var errs []error
+	for _, ev := range n.Evaluate(run, results) {
+		delivered, err := n.send(ctx, ev)
+		if !delivered && len(n.config.Targets) > 0 {
+			n.unsend(ev)
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Evaluate updates the state of each URL and address from the results and
+// returns the notifications that are due, after de-duplication and cool-down
+func (n *Notifier) Evaluate(run *stats.Run, results []*stats.Stats) []*Notification {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var due []*Notification
+	for _, st := range results {
+		key := st.URL + "|" + st.ResolvedIP + "|" + st.AddressFamily + "|" + st.AnswererURL
+		s, ok := n.states[key]
+		if !ok {
+			s = &state{}
+			n.states[key] = s
+		}
+
+		base := Notification{
+			Provider:  st.Endpoints(),
+			Scheme:    st.Scheme,
+			Protocol:  st.Protocol,
+			Transport: st.Scheme + "/" + st.Protocol,
+			URL:       st.URL,
+			Address:   st.ResolvedIP,
+			Family:    st.AddressFamily,
+			Answerer:  st.AnswererURL,
+			Node:      st.Node,
+			TestRunID: run.TestRunID,
+			Time:      n.now(),
+		}
+
+		if !st.Connected {
+			s.failures++
+			if !s.failing && s.failures >= n.failureThreshold() {
+				s.failing = true
+				ev := base
+				ev.Event = EventFailing
+				ev.Failures = s.failures
+				ev.state = s
+				sent := len(due)
+				due = n.appendIfDue(due, key, &ev)
+				s.notified = len(due) > sent
+			}
+			continue
+		}
+
+		s.failures = 0
+		if s.failing {
+			s.failing = false
+			if s.notified {
+				s.notified = false
+				ev := base
+				ev.Event = EventRecovered
+				ev.state = s
+				due = n.appendIfDue(due, key, &ev)
+			}
+		}
+
+		for _, v := range n.violations(st) {
+			ev := base
+			ev.Event = EventThreshold
+			ev.Metric = v.metric
+			ev.Value = v.value
+			ev.Threshold = v.threshold
+			due = n.appendIfDue(due, key+"|"+v.metric, &ev)
+		}
+	}
+	return due
+}
+
+// appendIfDue adds ev unless the same kind of notification was sent for key
+// within the cool-down window. Callers must hold n.mu.
+func (n *Notifier) appendIfDue(due []*Notification, key string, ev *Notification) []*Notification {
+	dedupKey := key + "|" + ev.Event
+	if last, ok := n.lastSent[dedupKey]; ok && n.now().Sub(last) < n.cooldown() {
+		n.logger.Debug("Suppressing notification in cool-down", "key", dedupKey)
+		return due
+	}
+	ev.dedupKey = dedupKey
+	ev.prevSent = n.lastSent[dedupKey]
+	n.lastSent[dedupKey] = n.now()
+	return append(due, ev)
+}
+
+// unsend restores the state from before ev was due when no target received
+// it, so it isn't held back by the cool-down and a failing notification is
+// sent again on the next failure rather than followed by a recovery
+func (n *Notifier) unsend(ev *Notification) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if ev.prevSent.IsZero() {
+		delete(n.lastSent, ev.dedupKey)
+	} else {
+		n.lastSent[ev.dedupKey] = ev.prevSent
+	}
+
+	switch ev.Event {
+	case EventFailing:
+		ev.state.failing = false
+		ev.state.notified = false
+	case EventRecovered:
+		ev.state.failing = true
+		ev.state.notified = true
+	}
+}
+
+type violation struct {
+	metric    string
+	value     float64
+	threshold float64
+}
+
+func (n *Notifier) violations(st *stats.Stats) []violation {
+	t := n.config.Thresholds
+	var v []violation
+	if t.TimeToConnectedMs > 0 && float64(st.TimeToConnectedState) > t.TimeToConnectedMs {
+		v = append(v, violation{"time_to_connected_ms", float64(st.TimeToConnectedState), t.TimeToConnectedMs})
+	}
+	if t.TimeToCandidateMs > 0 && st.OffererTimeToReceiveCandidate > t.TimeToCandidateMs {
+		v = append(v, violation{"time_to_candidate_ms", st.OffererTimeToReceiveCandidate, t.TimeToCandidateMs})
+	}
+	if t.LatencyFirstPacketMs > 0 && st.LatencyFirstPacket > t.LatencyFirstPacketMs {
+		v = append(v, violation{"latency_first_packet_ms", st.LatencyFirstPacket, t.LatencyFirstPacketMs})
+	}
+	// throughput is only measured on providers with do_throughput set
+	if t.ThroughputMinMbps > 0 && len(st.Throughput) > 0 && st.ThroughputMax < t.ThroughputMinMbps {
+		v = append(v, violation{"throughput_max_mbps", st.ThroughputMax, t.ThroughputMinMbps})
+	}
+	return v
+}
+
+// send posts ev to every target and reports whether any of them received it
+func (n *Notifier) send(ctx context.Context, ev *Notification) (bool, error) {
+	var errs []error
+	delivered := false
+	for i, target := range n.config.Targets {
+		var msg bytes.Buffer
+		if err := n.templates[i].Execute(&msg, ev); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		e := *ev
+		e.Message = msg.String()
+
+		if err := n.post(ctx, &target, &e); err != nil {
+			n.logger.Error("Error sending notification", "target", target.Type, "err", err)
+			errs = append(errs, err)
+			continue
+		}
+		delivered = true
+		n.logger.Info("Sent notification", "target", target.Type, "event", e.Event, "provider", e.Provider, "transport", e.Transport, "url", e.URL, "address", e.Address)
+	}
+	return delivered, errors.Join(errs...)
+}
+
+func (n *Notifier) post(ctx context.Context, target *config.NotifyTarget, ev *Notification) error {
+	var body any
+	switch target.Type {
+	case "slack":
+		body = map[string]string{"text": ev.Message}
+	case "teams":
+		color := "d9534f"
+		if ev.Event == EventRecovered {
+			color = "5cb85c"
+		}
+		body = map[string]string{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    ev.Message,
+			"text":       ev.Message,
+			"themeColor": color,
+		}
+	default:
+		body = ev
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", target.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range target.Headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("%s returned status code %d", target.Type, res.StatusCode)
+	}
+	return nil
+}
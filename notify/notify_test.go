@@ -0,0 +1,145 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+func result(connected bool, timeToConnected int64) *stats.Stats {
+	st := stats.NewStats("run", time.Now())
+	st.SetProvider("cloudflare")
+	st.SetScheme("turn")
+	st.SetProtocol("udp")
+	if connected {
+		st.SetTimeToConnectedState(timeToConnected)
+	}
+	return st
+}
+
+func events(ns []*Notification) []string {
+	out := []string{}
+	for _, n := range ns {
+		out = append(out, n.Event)
+	}
+	return out
+}
+
+func TestNotifierStateChangesAndCooldown(t *testing.T) {
+	n, err := New(&config.NotificationsConfig{
+		FailureThreshold: 2,
+		Cooldown:         600,
+		Thresholds:       config.NotifyThresholds{TimeToConnectedMs: 1000},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	assert.NoError(t, err)
+
+	now := time.Now()
+	n.now = func() time.Time { return now }
+	run := stats.NewRun("run", now)
+
+	assert.Equal(t, []string{}, events(n.Evaluate(run, []*stats.Stats{result(true, 200)})))
+	// a single failure is below the failure threshold
+	assert.Equal(t, []string{}, events(n.Evaluate(run, []*stats.Stats{result(false, 0)})))
+	assert.Equal(t, []string{EventFailing}, events(n.Evaluate(run, []*stats.Stats{result(false, 0)})))
+	// still failing, no repeat
+	assert.Equal(t, []string{}, events(n.Evaluate(run, []*stats.Stats{result(false, 0)})))
+	assert.Equal(t, []string{EventRecovered}, events(n.Evaluate(run, []*stats.Stats{result(true, 200)})))
+
+	assert.Equal(t, []string{EventThreshold}, events(n.Evaluate(run, []*stats.Stats{result(true, 2000)})))
+	// the same violation inside the cool-down is suppressed
+	assert.Equal(t, []string{}, events(n.Evaluate(run, []*stats.Stats{result(true, 2000)})))
+
+	now = now.Add(11 * time.Minute)
+	assert.Equal(t, []string{EventThreshold}, events(n.Evaluate(run, []*stats.Stats{result(true, 2000)})))
+}
+
+func TestNotifierTracksEachAddress(t *testing.T) {
+	n, err := New(&config.NotificationsConfig{Cooldown: 600}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	assert.NoError(t, err)
+
+	now := time.Now()
+	n.now = func() time.Time { return now }
+	run := stats.NewRun("run", now)
+
+	at := func(connected bool, ip string) *stats.Stats {
+		st := result(connected, 200)
+		st.SetURL("turn:turn.example.com:3478?transport=udp")
+		st.SetResolvedIP(ip)
+		return st
+	}
+
+	// one address failing doesn't hide the other
+	assert.Equal(t, []string{EventFailing}, events(n.Evaluate(run, []*stats.Stats{at(false, "192.0.2.1"), at(true, "192.0.2.2")})))
+	now = now.Add(time.Minute)
+	assert.Equal(t, []string{EventRecovered, EventFailing}, events(n.Evaluate(run, []*stats.Stats{at(true, "192.0.2.1"), at(false, "192.0.2.2")})))
+
+	// failing again inside the cool-down isn't sent, so neither is recovering
+	now = now.Add(4 * time.Minute)
+	assert.Equal(t, []string{}, events(n.Evaluate(run, []*stats.Stats{at(false, "192.0.2.1")})))
+	now = now.Add(7 * time.Minute)
+	assert.Equal(t, []string{}, events(n.Evaluate(run, []*stats.Stats{at(true, "192.0.2.1")})))
+}
+
+func TestNotifierPostsToSlack(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	n, err := New(&config.NotificationsConfig{
+		Targets: []config.NotifyTarget{{Type: "slack", URL: srv.URL}},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	assert.NoError(t, err)
+
+	st := result(false, 0)
+	st.SetNode("london")
+	st.SetURL("turn:turn.example.com:3478?transport=udp")
+	st.SetResolvedIP("192.0.2.1")
+	err = n.Write(context.Background(), stats.NewRun("run", time.Now()), []*stats.Stats{st})
+	assert.NoError(t, err)
+	assert.Equal(t, "ICEPerf: cloudflare turn/udp turn:turn.example.com:3478?transport=udp (192.0.2.1) is failing on london (1 failed tests)", got["text"])
+}
+
+func TestNotifierRetriesFailedPosts(t *testing.T) {
+	status := http.StatusInternalServerError
+	var posted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev Notification
+		json.NewDecoder(r.Body).Decode(&ev)
+		posted = append(posted, ev.Event)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	n, err := New(&config.NotificationsConfig{
+		Cooldown: 600,
+		Targets:  []config.NotifyTarget{{Type: "webhook", URL: srv.URL}},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	assert.NoError(t, err)
+	run := stats.NewRun("run", time.Now())
+	write := func(connected bool) error {
+		return n.Write(context.Background(), run, []*stats.Stats{result(connected, 200)})
+	}
+
+	assert.Error(t, write(false))
+	// nobody was told it failed, so there's nothing to recover from
+	assert.NoError(t, write(true))
+	assert.Equal(t, []string{EventFailing}, posted)
+
+	// the failed post doesn't start the cool-down
+	assert.Error(t, write(false))
+	status = http.StatusOK
+	assert.NoError(t, write(false))
+	assert.NoError(t, write(true))
+	assert.Equal(t, []string{EventFailing, EventFailing, EventFailing, EventRecovered}, posted)
+}
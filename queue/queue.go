@@ -0,0 +1,188 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+const fileSuffix = ".json"
+
+// ErrEmpty is returned by Oldest when there is nothing queued
+var ErrEmpty = errors.New("queue is empty")
+
+// Queue is a durable FIFO of JSON payloads stored as one file per entry in a
+// directory, so that queued results survive agent restarts.
+type Queue struct {
+	dir      string
+	maxBytes int64
+	mu       sync.Mutex
+	dropped  int64
+}
+
+// Entry is a single queued payload
+type Entry struct {
+	Name string
+	Data []byte
+}
+
+// New opens (creating if needed) a queue in dir. When maxBytes is greater
+// than zero the oldest entries are dropped to keep the directory under it.
+func New(dir string, maxBytes int64) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Queue{
+		dir:      dir,
+		maxBytes: maxBytes,
+	}, nil
+}
+
+// Dir returns the directory the queue is stored in
+func (q *Queue) Dir() string {
+	return q.dir
+}
+
+// Enqueue durably writes payload to the end of the queue
+func (q *Queue) Enqueue(payload []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// names sort by enqueue time, the xid keeps them unique
+	name := fmt.Sprintf("%020d-%s%s", time.Now().UnixNano(), xid.New().String(), fileSuffix)
+	tmp := filepath.Join(q.dir, "."+name+".tmp")
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(payload); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(q.dir, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return q.enforceLimit()
+}
+
+// Oldest returns the entry at the head of the queue without removing it
+func (q *Queue) Oldest() (*Entry, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	files, err := q.list()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, ErrEmpty
+	}
+	data, err := os.ReadFile(filepath.Join(q.dir, files[0].Name()))
+	if err != nil {
+		return nil, err
+	}
+	return &Entry{Name: files[0].Name(), Data: data}, nil
+}
+
+// Remove deletes an entry once it has been delivered
+func (q *Queue) Remove(name string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	err := os.Remove(filepath.Join(q.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Depth returns the number of entries waiting to be sent
+func (q *Queue) Depth() (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	files, err := q.list()
+	if err != nil {
+		return 0, err
+	}
+	return len(files), nil
+}
+
+// Dropped returns how many entries have been discarded to stay under the size cap
+func (q *Queue) Dropped() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// list returns the queued files oldest first. Callers must hold q.mu.
+func (q *Queue) list() ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+	files := entries[:0]
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") || !strings.HasSuffix(e.Name(), fileSuffix) {
+			continue
+		}
+		files = append(files, e)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+	return files, nil
+}
+
+// enforceLimit drops the oldest entries until the queue fits in maxBytes.
+// Callers must hold q.mu.
+func (q *Queue) enforceLimit() error {
+	if q.maxBytes <= 0 {
+		return nil
+	}
+	files, err := q.list()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	sizes := make([]int64, len(files))
+	for i, f := range files {
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		sizes[i] = info.Size()
+		total += sizes[i]
+	}
+
+	// never drop the entry we have just written
+	for i := 0; total > q.maxBytes && i < len(files)-1; i++ {
+		if err := os.Remove(filepath.Join(q.dir, files[i].Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		total -= sizes[i]
+		q.dropped++
+	}
+	return nil
+}
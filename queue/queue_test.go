@@ -0,0 +1,123 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestQueueIsFIFOAndSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := New(dir, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, q.Enqueue([]byte(`{"n":1}`)))
+	assert.NoError(t, q.Enqueue([]byte(`{"n":2}`)))
+
+	// a new agent process picks up where the old one left off
+	q, err = New(dir, 0)
+	assert.NoError(t, err)
+
+	depth, err := q.Depth()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, depth)
+
+	e, err := q.Oldest()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"n":1}`, string(e.Data))
+	assert.NoError(t, q.Remove(e.Name))
+
+	e, err = q.Oldest()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"n":2}`, string(e.Data))
+}
+
+func TestQueueDropsOldestOverLimit(t *testing.T) {
+	q, err := New(t.TempDir(), 20)
+	assert.NoError(t, err)
+
+	assert.NoError(t, q.Enqueue([]byte(`{"n":"aaaaaa"}`)))
+	assert.NoError(t, q.Enqueue([]byte(`{"n":"bbbbbb"}`)))
+
+	depth, err := q.Depth()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, depth)
+	assert.Equal(t, int64(1), q.Dropped())
+
+	e, err := q.Oldest()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"n":"bbbbbb"}`, string(e.Data))
+}
+
+func TestSenderFlush(t *testing.T) {
+	q, err := New(t.TempDir(), 0)
+	assert.NoError(t, err)
+	assert.NoError(t, q.Enqueue([]byte("ok")))
+	assert.NoError(t, q.Enqueue([]byte("rejected")))
+	assert.NoError(t, q.Enqueue([]byte("fail")))
+	assert.NoError(t, q.Enqueue([]byte("later")))
+
+	var sent []string
+	s := NewSender(q, func(ctx context.Context, payload []byte) error {
+		switch string(payload) {
+		case "rejected":
+			return Permanent(errors.New("bad request"))
+		case "fail":
+			return errors.New("network down")
+		}
+		sent = append(sent, string(payload))
+		return nil
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	err = s.Flush(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, []string{"ok"}, sent)
+
+	// the failed entry stays at the head of the queue to be retried
+	depth, err := q.Depth()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, depth)
+	e, err := q.Oldest()
+	assert.NoError(t, err)
+	assert.Equal(t, "fail", string(e.Data))
+}
+
+func TestSenderDoneAfterSendInProgress(t *testing.T) {
+	q, err := New(t.TempDir(), 0)
+	assert.NoError(t, err)
+
+	sending := make(chan struct{})
+	release := make(chan struct{})
+	sends := 0
+	s := NewSender(q, func(ctx context.Context, payload []byte) error {
+		sends++
+		if sends == 1 {
+			close(sending)
+			<-release
+		}
+		return nil
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.Run(ctx)
+	assert.NoError(t, s.Enqueue([]byte("entry")))
+	<-sending
+	cancel()
+
+	select {
+	case <-s.Done():
+		t.Fatal("Run returned while sending")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	<-s.Done()
+
+	// the entry Run was sending isn't flushed again
+	assert.NoError(t, s.Flush(context.Background()))
+	assert.Equal(t, 1, sends)
+}
@@ -0,0 +1,183 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// SendFunc delivers a single payload. Returning an error wrapped with
+// Permanent drops the payload instead of retrying it.
+type SendFunc func(ctx context.Context, payload []byte) error
+
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string {
+	return p.err.Error()
+}
+
+func (p *permanentError) Unwrap() error {
+	return p.err
+}
+
+// Permanent marks err as not worth retrying, e.g. a 4xx from the API
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// Sender ships queued payloads in the background, retrying failures with
+// exponential backoff.
+type Sender struct {
+	Queue      *Queue
+	Send       SendFunc
+	Logger     *slog.Logger
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// PollInterval is how often an empty queue is checked for new entries
+	// written by something other than Notify's caller
+	PollInterval time.Duration
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+// NewSender creates a Sender with the default backoff settings
+func NewSender(q *Queue, send SendFunc, logger *slog.Logger) *Sender {
+	return &Sender{
+		Queue:        q,
+		Send:         send,
+		Logger:       logger,
+		MinBackoff:   1 * time.Second,
+		MaxBackoff:   5 * time.Minute,
+		PollInterval: 30 * time.Second,
+		wake:         make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+}
+
+// Enqueue adds payload to the queue and wakes the sender
+func (s *Sender) Enqueue(payload []byte) error {
+	if err := s.Queue.Enqueue(payload); err != nil {
+		return err
+	}
+	s.Notify()
+	return nil
+}
+
+// Notify wakes the sender after something has been enqueued
+func (s *Sender) Notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends queued payloads until ctx is cancelled. It must only be called
+// once.
+func (s *Sender) Run(ctx context.Context) {
+	defer close(s.done)
+
+	backoff := s.MinBackoff
+	for {
+		sent, err := s.sendOldest(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		var wait time.Duration
+		switch {
+		case err != nil:
+			wait = jitter(backoff)
+			s.Logger.Warn("Failed to send queued result, will retry", "err", err, "retryIn", wait)
+			backoff *= 2
+			if backoff > s.MaxBackoff {
+				backoff = s.MaxBackoff
+			}
+		case sent:
+			backoff = s.MinBackoff
+			continue
+		default:
+			wait = s.PollInterval
+		}
+
+		// new entries only cut an idle wait short, never a retry backoff
+		wake := s.wake
+		if err != nil {
+			wake = nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Done is closed once Run has returned, after the send it was in the middle
+// of when ctx was cancelled
+func (s *Sender) Done() <-chan struct{} {
+	return s.done
+}
+
+// Flush tries to send everything currently queued, giving up on the first
+// failure or when ctx is done. Anything left stays on disk for the next run.
+// Run must have returned, or the entry it is sending is sent twice.
+func (s *Sender) Flush(ctx context.Context) error {
+	for {
+		sent, err := s.sendOldest(ctx)
+		if err != nil {
+			return err
+		}
+		if !sent {
+			return nil
+		}
+	}
+}
+
+// sendOldest sends the head of the queue, returning false if it was empty
+func (s *Sender) sendOldest(ctx context.Context) (bool, error) {
+	entry, err := s.Queue.Oldest()
+	if errors.Is(err, ErrEmpty) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := s.Send(ctx, entry.Data); err != nil {
+		if !IsPermanent(err) {
+			return false, err
+		}
+		s.Logger.Error("Dropping queued result that was rejected", "err", err, "entry", entry.Name)
+	}
+
+	if err := s.Queue.Remove(entry.Name); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
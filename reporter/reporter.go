@@ -0,0 +1,189 @@
+package reporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/history"
+	"github.com/nimbleape/iceperf-agent/notify"
+	"github.com/nimbleape/iceperf-agent/sinks"
+	"github.com/nimbleape/iceperf-agent/sinks/api"
+	"github.com/nimbleape/iceperf-agent/sinks/file"
+	"github.com/nimbleape/iceperf-agent/sinks/logger"
+	"github.com/nimbleape/iceperf-agent/sinks/loki"
+	"github.com/nimbleape/iceperf-agent/sinks/promwrite"
+	"github.com/nimbleape/iceperf-agent/sinks/stdout"
+	"github.com/nimbleape/iceperf-agent/sinks/webhook"
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+// Reporter delivers results to every configured sink
+type Reporter struct {
+	sinks  []sinks.Sink
+	logger *slog.Logger
+}
+
+// New creates the sinks listed in the config. When none are listed results
+// are logged and printed as a table, as they always have been. The results
+// API sink is added whenever logging.api is enabled, unless it is listed.
+func New(ctx context.Context, cfg *config.Config, logg *slog.Logger) (*Reporter, error) {
+	r := &Reporter{
+		logger: logg.With("component", "reporter"),
+	}
+
+	sinkConfigs := cfg.Sinks
+	if len(sinkConfigs) == 0 {
+		sinkConfigs = []config.SinkConfig{
+			{Type: "log"},
+			{Type: "stdout", Format: "table"},
+		}
+	}
+
+	listedAPI := false
+	apiURLs := make(map[string]bool)
+	for i := range sinkConfigs {
+		if sinkConfigs[i].Type == "api" {
+			// two sinks for the same API would share its queue
+			url := sinkConfigs[i].URL
+			if url == "" {
+				url = cfg.Logging.API.URI
+			}
+			if apiURLs[url] {
+				return nil, fmt.Errorf("api sink for %s is listed more than once", url)
+			}
+			apiURLs[url] = true
+		}
+		s, err := newSink(ctx, &sinkConfigs[i], cfg, logg)
+		if err != nil {
+			return nil, err
+		}
+		r.sinks = append(r.sinks, s)
+		listedAPI = listedAPI || sinkConfigs[i].Type == "api"
+	}
+
+	if cfg.History.Enabled {
+		r.sinks = append(r.sinks, history.New(cfg.History.Path))
+	}
+
+	if cfg.Notifications.Enabled {
+		n, err := notify.New(&cfg.Notifications, logg)
+		if err != nil {
+			return nil, err
+		}
+		r.sinks = append(r.sinks, n)
+	}
+
+	if cfg.Logging.API.Enabled && !listedAPI {
+		s, err := api.New(ctx, &cfg.Logging.API, logg)
+		if err != nil {
+			// carry on without the queue, results are then sent once directly
+			r.logger.Error("Error opening result queue, results will not be retried", "err", err)
+		}
+		r.sinks = append(r.sinks, s)
+	}
+
+	return r, nil
+}
+
+func newSink(ctx context.Context, sc *config.SinkConfig, cfg *config.Config, logg *slog.Logger) (sinks.Sink, error) {
+	switch sc.Type {
+	case "api":
+		// set up by logging.api, with url overriding its uri
+		apiConfig := cfg.Logging.API
+		if sc.URL != "" {
+			apiConfig.URI = sc.URL
+		}
+		if apiConfig.URI == "" || apiConfig.ApiKey == "" {
+			return nil, errors.New("api sink needs logging.api.api_key and a uri or url")
+		}
+		s, err := api.New(ctx, &apiConfig, logg)
+		if err != nil {
+			// carry on without the queue, results are then sent once directly
+			logg.Error("Error opening result queue, results will not be retried", "err", err)
+		}
+		return s, nil
+	case "file":
+		if sc.Path == "" {
+			return nil, errors.New("file sink needs a path")
+		}
+		return file.New(sc), nil
+	case "stdout":
+		return stdout.New(sc), nil
+	case "log":
+		return logger.New(logg), nil
+	case "loki":
+		if sc.URL == "" {
+			return nil, errors.New("loki sink needs a url")
+		}
+		return loki.New(sc), nil
+	case "prometheus":
+		if sc.URL == "" {
+			return nil, errors.New("prometheus sink needs a url")
+		}
+		return promwrite.New(sc), nil
+	case "webhook":
+		if sc.URL == "" {
+			return nil, errors.New("webhook sink needs a url")
+		}
+		return webhook.New(sc), nil
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", sc.Type)
+	}
+}
+
+// Add appends a sink, for destinations that aren't configured through the
+// sinks list
+func (r *Reporter) Add(s sinks.Sink) {
+	r.sinks = append(r.sinks, s)
+}
+
+func isStreaming(s sinks.Sink) bool {
+	st, ok := s.(sinks.Streamer)
+	return ok && st.Streaming()
+}
+
+// AddResult is called as each ICE server test finishes and writes its stats
+// to the sinks that stream results
+func (r *Reporter) AddResult(ctx context.Context, run *stats.Run, st *stats.Stats) error {
+	var errs []error
+	for _, s := range r.sinks {
+		if !isStreaming(s) {
+			continue
+		}
+		if err := s.Write(ctx, run, []*stats.Stats{st}); err != nil {
+			r.logger.Error("Error writing result to sink", "sink", fmt.Sprintf("%T", s), "err", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Finish is called at the end of a test run and writes all of its stats to
+// the sinks that don't stream results
+func (r *Reporter) Finish(ctx context.Context, run *stats.Run) error {
+	var errs []error
+	for _, s := range r.sinks {
+		if isStreaming(s) {
+			continue
+		}
+		if err := s.Write(ctx, run, run.Results); err != nil {
+			r.logger.Error("Error writing results to sink", "sink", fmt.Sprintf("%T", s), "err", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close flushes and releases every sink that needs it
+func (r *Reporter) Close(ctx context.Context) error {
+	var errs []error
+	for _, s := range r.sinks {
+		if c, ok := s.(sinks.Closer); ok {
+			errs = append(errs, c.Close(ctx))
+		}
+	}
+	return errors.Join(errs...)
+}
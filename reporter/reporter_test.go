@@ -0,0 +1,82 @@
+package reporter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+type fakeSink struct {
+	streaming bool
+	err       error
+	writes    [][]*stats.Stats
+	closed    bool
+}
+
+func (f *fakeSink) Write(ctx context.Context, run *stats.Run, results []*stats.Stats) error {
+	f.writes = append(f.writes, results)
+	return f.err
+}
+
+func (f *fakeSink) Streaming() bool {
+	return f.streaming
+}
+
+func (f *fakeSink) Close(ctx context.Context) error {
+	f.closed = true
+	return nil
+}
+
+func TestReporterFansOutAndSurvivesFailingSink(t *testing.T) {
+	r, err := New(context.Background(), &config.Config{Sinks: []config.SinkConfig{{Type: "file", Path: t.TempDir() + "/"}}},
+		slog.New(slog.NewTextHandler(io.Discard, nil)))
+	assert.NoError(t, err)
+
+	failing := &fakeSink{err: errors.New("unreachable")}
+	batch := &fakeSink{}
+	stream := &fakeSink{streaming: true}
+	r.Add(failing)
+	r.Add(batch)
+	r.Add(stream)
+
+	run := stats.NewRun("run", time.Now())
+	st := stats.NewStats("run", time.Now())
+	run.Results = []*stats.Stats{st, stats.NewStats("run", time.Now())}
+
+	// each test goes to the streaming sinks as it finishes
+	assert.NoError(t, r.AddResult(context.Background(), run, st))
+	assert.Equal(t, [][]*stats.Stats{{st}}, stream.writes)
+	assert.Equal(t, 0, len(batch.writes))
+
+	// the run goes to the rest, even when one of them fails
+	err = r.Finish(context.Background(), run)
+	assert.EqualError(t, err, "unreachable")
+	assert.Equal(t, [][]*stats.Stats{run.Results}, failing.writes)
+	assert.Equal(t, [][]*stats.Stats{run.Results}, batch.writes)
+	assert.Equal(t, 1, len(stream.writes))
+
+	assert.NoError(t, r.Close(context.Background()))
+	assert.True(t, failing.closed && batch.closed && stream.closed)
+}
+
+func TestNewRejectsBadSinks(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, sinks := range [][]config.SinkConfig{
+		{{Type: "carrier-pigeon"}},
+		{{Type: "webhook"}},
+		{{Type: "api", URL: "https://a.example.com"}, {Type: "api", URL: "https://a.example.com"}},
+	} {
+		cfg := &config.Config{Sinks: sinks}
+		cfg.Logging.API.ApiKey = "key"
+		cfg.Logging.API.Queue.Dir = t.TempDir()
+		_, err := New(context.Background(), cfg, logger)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,239 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/robfig/cron/v3"
+)
+
+// defaultInterval is used in timer mode when no schedule is configured
+const defaultInterval = 60 * time.Minute
+
+// Job is a schedule and the tests to run on it
+type Job struct {
+	Name     string
+	Schedule cron.Schedule
+	// RunOnStart runs the job as soon as the scheduler starts, rather than
+	// waiting for the first scheduled time. Interval schedules run on start,
+	// cron schedules don't.
+	RunOnStart bool
+	// Providers limits the run to these providers, all when empty
+	Providers []string
+	// Throughput overrides whether the providers run throughput tests
+	Throughput *bool
+}
+
+// Jobs builds the jobs described by the timer config
+func Jobs(cfg *config.TimerConfig) ([]Job, error) {
+	if len(cfg.Schedules) == 0 {
+		job, err := newJob("default", cfg.Cron, time.Duration(cfg.Every)*time.Second, time.Duration(cfg.Interval)*time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		return []Job{job}, nil
+	}
+
+	jobs := make([]Job, 0, len(cfg.Schedules))
+	for i, sc := range cfg.Schedules {
+		name := sc.Name
+		if name == "" {
+			name = fmt.Sprintf("schedule-%d", i+1)
+		}
+		if sc.Cron == "" && sc.Every <= 0 {
+			return nil, fmt.Errorf("schedule %s needs cron or every", name)
+		}
+		job, err := newJob(name, sc.Cron, time.Duration(sc.Every)*time.Second, 0)
+		if err != nil {
+			return nil, err
+		}
+		job.Providers = sc.Providers
+		job.Throughput = sc.Throughput
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func newJob(name, spec string, every, interval time.Duration) (Job, error) {
+	if spec != "" {
+		schedule, err := cron.ParseStandard(spec)
+		if err != nil {
+			return Job{}, fmt.Errorf("schedule %s: invalid cron expression %q: %w", name, spec, err)
+		}
+		return Job{Name: name, Schedule: schedule}, nil
+	}
+
+	d := every
+	if d <= 0 {
+		d = interval
+	}
+	if d <= 0 {
+		d = defaultInterval
+	}
+	return Job{Name: name, Schedule: cron.Every(d), RunOnStart: true}, nil
+}
+
+// Apply returns a copy of c that only tests the job's providers, with the
+// job's throughput override applied
+func (j Job) Apply(c *config.Config) *config.Config {
+	if len(j.Providers) == 0 && j.Throughput == nil {
+		return c
+	}
+
+	include := make(map[string]bool, len(j.Providers))
+	for _, p := range j.Providers {
+		include[p] = true
+	}
+
+	cc := *c
+	cc.ICEConfig = make(map[string]config.ICEConfig, len(c.ICEConfig))
+	for name, ic := range c.ICEConfig {
+		if len(include) > 0 && !include[name] {
+			continue
+		}
+		if j.Throughput != nil {
+			ic.DoThroughput = *j.Throughput
+		}
+		cc.ICEConfig[name] = ic
+	}
+	return &cc
+}
+
+// Scheduler runs jobs on their schedules. Only one run happens at a time: a
+// job that comes due while another job is running waits for it to finish.
+// Interval schedules keep their cadence from one scheduled start to the
+// next, and a run that takes longer than the interval skips the ticks it
+// missed.
+type Scheduler struct {
+	Jobs   []Job
+	Jitter time.Duration
+	Run    func(ctx context.Context, job Job)
+	Logger *slog.Logger
+
+	running sync.Mutex
+	mu      sync.Mutex
+	rearm   chan struct{}
+}
+
+func New(jobs []Job, jitter time.Duration, run func(ctx context.Context, job Job), logger *slog.Logger) *Scheduler {
+	return &Scheduler{
+		Jobs:   jobs,
+		Jitter: jitter,
+		Run:    run,
+		Logger: logger.With("component", "scheduler"),
+		rearm:  make(chan struct{}, 1),
+	}
+}
+
+// Update replaces the jobs and jitter. The new schedules take over once any
+// run in progress has finished, and interval schedules don't run straight
+// away as they do on start.
+func (s *Scheduler) Update(jobs []Job, jitter time.Duration) {
+	s.mu.Lock()
+	s.Jobs = jobs
+	s.Jitter = jitter
+	s.mu.Unlock()
+
+	select {
+	case s.rearm <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the jobs until ctx is cancelled
+func (s *Scheduler) Start(ctx context.Context) {
+	first := true
+	for {
+		s.mu.Lock()
+		jobs, jitter := s.Jobs, s.Jitter
+		s.mu.Unlock()
+
+		// armed only stops waiting for the schedules, runs get ctx so an
+		// update never interrupts them
+		armed, disarm := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		for _, job := range jobs {
+			wg.Add(1)
+			go func(job Job) {
+				defer wg.Done()
+				s.loop(ctx, armed, job, jitter, first)
+			}(job)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-s.rearm:
+			s.Logger.Info("Schedules changed")
+		}
+		disarm()
+		wg.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+		first = false
+	}
+}
+
+func (s *Scheduler) loop(ctx, armed context.Context, job Job, maxJitter time.Duration, first bool) {
+	logger := s.Logger.With("schedule", job.Name)
+
+	next := time.Now()
+	if !job.RunOnStart || !first {
+		next = job.Schedule.Next(next)
+	}
+
+	for {
+		at := next.Add(jitter(maxJitter))
+		logger.Info("Next run scheduled", "at", at)
+
+		timer := time.NewTimer(time.Until(at))
+		select {
+		case <-armed.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.runJob(ctx, armed, job, logger)
+		next = nextRun(job.Schedule, next, time.Now())
+	}
+}
+
+// runJob runs the job once any run in progress has finished, unless its
+// schedule was replaced while it waited
+func (s *Scheduler) runJob(ctx, armed context.Context, job Job, logger *slog.Logger) {
+	if !s.running.TryLock() {
+		logger.Warn("Waiting for the run in progress to finish")
+		s.running.Lock()
+	}
+	defer s.running.Unlock()
+	if armed.Err() != nil {
+		return
+	}
+
+	started := time.Now()
+	s.Run(ctx, job)
+	logger.Info("Run finished", "duration", time.Since(started))
+}
+
+// nextRun returns the first time of the schedule after prev that is still to
+// come at now
+func nextRun(schedule cron.Schedule, prev, now time.Time) time.Time {
+	next := schedule.Next(prev)
+	for !next.IsZero() && !next.After(now) {
+		next = schedule.Next(next)
+	}
+	return next
+}
+
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
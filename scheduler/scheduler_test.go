@@ -0,0 +1,150 @@
+package scheduler
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/robfig/cron/v3"
+)
+
+func TestJobs(t *testing.T) {
+	jobs, err := Jobs(&config.TimerConfig{Interval: 60})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(jobs))
+	assert.True(t, jobs[0].RunOnStart)
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, now.Add(time.Hour), jobs[0].Schedule.Next(now))
+
+	jobs, err = Jobs(&config.TimerConfig{Interval: 60, Every: 30})
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(30*time.Second), jobs[0].Schedule.Next(now))
+
+	throughput := true
+	jobs, err = Jobs(&config.TimerConfig{Schedules: []config.ScheduleConfig{
+		{Name: "throughput", Cron: "@hourly", Providers: []string{"cloudflare"}, Throughput: &throughput},
+		{Every: 300},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(jobs))
+	assert.False(t, jobs[0].RunOnStart)
+	assert.Equal(t, now.Add(time.Hour), jobs[0].Schedule.Next(now.Add(time.Second)))
+	assert.Equal(t, "schedule-2", jobs[1].Name)
+
+	_, err = Jobs(&config.TimerConfig{Cron: "not a cron"})
+	assert.Error(t, err)
+	_, err = Jobs(&config.TimerConfig{Schedules: []config.ScheduleConfig{{Name: "empty"}}})
+	assert.Error(t, err)
+}
+
+func TestJobApply(t *testing.T) {
+	throughput := true
+	job := Job{Providers: []string{"cloudflare"}, Throughput: &throughput}
+	c := &config.Config{ICEConfig: map[string]config.ICEConfig{
+		"cloudflare": {Enabled: true},
+		"twilio":     {Enabled: true},
+	}}
+
+	applied := job.Apply(c)
+	assert.Equal(t, 1, len(applied.ICEConfig))
+	assert.True(t, applied.ICEConfig["cloudflare"].DoThroughput)
+	// the original config is untouched
+	assert.Equal(t, 2, len(c.ICEConfig))
+	assert.False(t, c.ICEConfig["cloudflare"].DoThroughput)
+}
+
+func TestNextRun(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	every := cron.Every(time.Minute)
+
+	// the cadence is kept from the scheduled start, not the end of the run
+	assert.Equal(t, start.Add(time.Minute), nextRun(every, start, start.Add(20*time.Second)))
+	// a run longer than the interval skips the ticks it missed
+	assert.Equal(t, start.Add(3*time.Minute), nextRun(every, start, start.Add(150*time.Second)))
+	assert.Equal(t, start.Add(3*time.Minute), nextRun(every, start, start.Add(2*time.Minute)))
+
+	hourly, err := cron.ParseStandard("@hourly")
+	assert.NoError(t, err)
+	assert.Equal(t, start.Add(2*time.Hour), nextRun(hourly, start, start.Add(70*time.Minute)))
+}
+
+func TestRunsDontOverlap(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	s := New(nil, 0, func(ctx context.Context, job Job) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runJob(context.Background(), context.Background(), Job{Name: "job"}, s.Logger)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, maxRunning)
+}
+
+type everyMs time.Duration
+
+func (e everyMs) Next(t time.Time) time.Time { return t.Add(time.Duration(e)) }
+
+func TestUpdateRearmsJobs(t *testing.T) {
+	var mu sync.Mutex
+	var ran []string
+	ctx, cancel := context.WithCancel(context.Background())
+	var s *Scheduler
+	s = New([]Job{{Name: "a", Schedule: everyMs(5 * time.Millisecond)}}, 0, func(ctx context.Context, job Job) {
+		mu.Lock()
+		defer mu.Unlock()
+		ran = append(ran, job.Name)
+		if len(ran) == 3 {
+			s.Update([]Job{{Name: "b", Schedule: everyMs(5 * time.Millisecond), RunOnStart: true}}, 0)
+		}
+		if len(ran) == 10 {
+			cancel()
+		}
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	done := make(chan struct{})
+	go func() {
+		s.Start(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduler didn't stop")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// once b has run, a never runs again
+	first := len(ran)
+	for i, name := range ran {
+		if name == "b" {
+			first = i
+			break
+		}
+	}
+	assert.True(t, first >= 3 && first < len(ran))
+	for _, name := range ran[first:] {
+		assert.Equal(t, "b", name)
+	}
+}
@@ -0,0 +1,107 @@
+package signaling
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pollWait is how long the server is asked to hold a poll open
+const pollWait = 25 * time.Second
+
+// Peer is one agent's connection to a room on a signaling server
+type Peer struct {
+	url    string
+	token  string
+	role   string
+	client *http.Client
+
+	mu    sync.Mutex
+	after int
+}
+
+// NewPeer joins room on the signaling server at baseURL as role, sending
+// token if it isn't empty. It receives the messages already in the room,
+// unless Sync is called first.
+func NewPeer(baseURL, token, room, role string) *Peer {
+	return &Peer{
+		url:    strings.TrimSuffix(baseURL, "/") + "/rooms/" + url.PathEscape(room),
+		token:  token,
+		role:   role,
+		client: &http.Client{Timeout: pollWait + 10*time.Second},
+	}
+}
+
+// Send posts a message to the room
+func (p *Peer) Send(ctx context.Context, m Message) error {
+	m.From = p.role
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := p.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("signaling server returned status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Sync skips the messages already in the room, so only the replies to
+// what the peer sends next are received
+func (p *Peer) Sync(ctx context.Context) error {
+	p.mu.Lock()
+	p.after = -1
+	p.mu.Unlock()
+	_, err := p.Receive(ctx)
+	return err
+}
+
+// Receive waits for the next messages from the other agent. It returns no
+// messages if there were none before the server's poll timeout.
+func (p *Peer) Receive(ctx context.Context) ([]Message, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	u := fmt.Sprintf("%s?role=%s&after=%d&wait=%d", p.url, url.QueryEscape(p.role), p.after, int(pollWait.Seconds()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("signaling server returned status code %d", res.StatusCode)
+	}
+
+	var b Batch
+	if err := json.NewDecoder(res.Body).Decode(&b); err != nil {
+		return nil, err
+	}
+	p.after = b.Last
+	return b.Messages, nil
+}
+
+func (p *Peer) do(req *http.Request) (*http.Response, error) {
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	return p.client.Do(req)
+}
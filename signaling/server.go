@@ -0,0 +1,251 @@
+package signaling
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxWait caps how long a poll is held open
+	maxWait = 30 * time.Second
+	// messageTTL is how long messages are kept in a room
+	messageTTL = 10 * time.Minute
+)
+
+// Server keeps the rooms in memory and serves them over HTTP:
+//
+//	POST /rooms/{room}                  post a message
+//	GET  /rooms/{room}?role=&after=     wait for the other role's messages
+//
+// A poll returns as soon as there are messages after the given sequence
+// number that weren't sent by role, or empty after wait seconds (25 by
+// default). after=-1 skips the messages already in the room.
+//
+// Rooms are created when first used and dropped once their messages have
+// expired and no one is polling them. With a token, every request must
+// carry it as a bearer token.
+type Server struct {
+	listen string
+	token  string
+	logger *slog.Logger
+
+	mu    sync.Mutex
+	rooms map[string]*room
+	// seq numbers messages across all rooms, so a room that is dropped
+	// and used again doesn't repeat the numbers its pollers have seen
+	seq int
+
+	srv *http.Server
+}
+
+type room struct {
+	messages []Message
+	posted   []time.Time
+	seq      int
+	// wake is closed and replaced when a message is posted
+	wake    chan struct{}
+	waiters int
+}
+
+func NewServer(listen, token string, logger *slog.Logger) *Server {
+	return &Server{
+		listen: listen,
+		token:  token,
+		logger: logger.With("component", "signaling"),
+		rooms:  make(map[string]*room),
+	}
+}
+
+// Post adds a message to a room and returns its sequence number
+func (s *Server) Post(name string, m Message) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(time.Now().Add(-messageTTL))
+	r := s.room(name)
+	s.seq++
+	r.seq = s.seq
+	m.Seq = s.seq
+	r.messages = append(r.messages, m)
+	r.posted = append(r.posted, time.Now())
+	close(r.wake)
+	r.wake = make(chan struct{})
+	return m.Seq
+}
+
+// Poll waits until there are messages in a room after seq that weren't
+// sent by role, or until ctx is done
+func (s *Server) Poll(ctx context.Context, name, role string, after int) Batch {
+	for {
+		s.mu.Lock()
+		r := s.room(name)
+		b := Batch{Messages: []Message{}, Last: r.seq}
+		if after >= 0 {
+			for _, m := range r.messages {
+				if m.Seq > after && m.From != role {
+					b.Messages = append(b.Messages, m)
+				}
+			}
+		}
+		wake := r.wake
+		if len(b.Messages) > 0 || after < 0 {
+			s.mu.Unlock()
+			return b
+		}
+		r.waiters++
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+		case <-wake:
+		}
+
+		s.mu.Lock()
+		r.waiters--
+		s.mu.Unlock()
+		if ctx.Err() != nil {
+			return b
+		}
+	}
+}
+
+// Rooms returns the number of rooms in use
+func (s *Server) Rooms() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.rooms)
+}
+
+// room returns a room, creating it if needed. Callers must hold s.mu.
+func (s *Server) room(name string) *room {
+	r, ok := s.rooms[name]
+	if !ok {
+		r = &room{seq: s.seq, wake: make(chan struct{})}
+		s.rooms[name] = r
+	}
+	return r
+}
+
+// expire drops the messages posted before t, and the rooms left empty that
+// no one is polling. Callers must hold s.mu.
+func (s *Server) expire(t time.Time) {
+	for name, r := range s.rooms {
+		n := 0
+		for n < len(r.posted) && r.posted[n].Before(t) {
+			n++
+		}
+		if n > 0 {
+			r.messages = append([]Message(nil), r.messages[n:]...)
+			r.posted = append([]time.Time(nil), r.posted[n:]...)
+		}
+		if len(r.messages) == 0 && r.waiters == 0 {
+			delete(s.rooms, name)
+		}
+	}
+}
+
+// Handler returns the signaling routes
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rooms/", s.handleRoom)
+	return mux
+}
+
+func (s *Server) handleRoom(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/rooms/")
+	if name == "" || strings.Contains(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var m Message
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&m); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if m.From != RoleOfferer && m.From != RoleAnswerer {
+			http.Error(w, "from must be offerer or answerer", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]int{"seq": s.Post(name, m)})
+	case http.MethodGet:
+		q := r.URL.Query()
+		after, err := strconv.Atoi(q.Get("after"))
+		if err != nil {
+			http.Error(w, "after must be a number", http.StatusBadRequest)
+			return
+		}
+		wait := 25 * time.Second
+		if v := q.Get("wait"); v != "" {
+			secs, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "wait must be a number", http.StatusBadRequest)
+				return
+			}
+			wait = time.Duration(secs) * time.Second
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), min(wait, maxWait))
+		defer cancel()
+		writeJSON(w, s.Poll(ctx, name, q.Get("role"), after))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// Start listens on the server's address and serves the rooms in the
+// background
+func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return err
+	}
+	s.srv = &http.Server{
+		Addr:              s.listen,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	s.logger.Info("Signaling server listening", "listen", ln.Addr().String())
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("Signaling server stopped", "err", err)
+		}
+	}()
+	return nil
+}
+
+// Close shuts the HTTP server down
+func (s *Server) Close(ctx context.Context) error {
+	if s.srv == nil {
+		return nil
+	}
+	return s.srv.Shutdown(ctx)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
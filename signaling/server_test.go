@@ -0,0 +1,121 @@
+package signaling
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestOfferAndAnswer(t *testing.T) {
+	srv := NewServer("", "", slog.Default())
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	ctx := context.Background()
+
+	// left over from an earlier run, the answerer mustn't see it
+	srv.Post("london-singapore", Message{From: RoleOfferer, Type: TypeOffer, Test: "old"})
+
+	offerer := NewPeer(ts.URL, "", "london-singapore", RoleOfferer)
+	answerer := NewPeer(ts.URL, "", "london-singapore", RoleAnswerer)
+	assert.NoError(t, offerer.Sync(ctx))
+	assert.NoError(t, answerer.Sync(ctx))
+
+	received := make(chan []Message)
+	go func() {
+		msgs, err := answerer.Receive(ctx)
+		assert.NoError(t, err)
+		received <- msgs
+	}()
+
+	offer := &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "v=0"}
+	assert.NoError(t, offerer.Send(ctx, Message{Type: TypeOffer, Test: "t1", SDP: offer,
+		Setup: &Setup{Provider: "metered", URL: "turn:turn.example.com:3478?transport=udp", Relay: true}}))
+
+	select {
+	case msgs := <-received:
+		assert.Equal(t, 1, len(msgs))
+		assert.Equal(t, "t1", msgs[0].Test)
+		assert.Equal(t, RoleOfferer, msgs[0].From)
+		assert.Equal(t, offer.SDP, msgs[0].SDP.SDP)
+		assert.Equal(t, "metered", msgs[0].Setup.Provider)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the answerer didn't receive the offer")
+	}
+
+	assert.NoError(t, answerer.Send(ctx, Message{Type: TypeAnswer, Test: "t1"}))
+	assert.NoError(t, answerer.Send(ctx, Message{Type: TypeCandidate, Test: "t1"}))
+	msgs, err := offerer.Receive(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(msgs))
+	assert.Equal(t, TypeAnswer, msgs[0].Type)
+	assert.Equal(t, TypeCandidate, msgs[1].Type)
+}
+
+func TestPollTimesOut(t *testing.T) {
+	srv := NewServer("", "", slog.Default())
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	srv.Post("room", Message{From: RoleOfferer, Type: TypeOffer})
+	b := srv.Poll(ctx, "room", RoleOfferer, 0)
+	assert.Equal(t, 0, len(b.Messages))
+	assert.Equal(t, 1, b.Last)
+}
+
+func TestToken(t *testing.T) {
+	srv := NewServer("", "s3cret", slog.Default())
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	ctx := context.Background()
+
+	err := NewPeer(ts.URL, "", "room", RoleOfferer).Send(ctx, Message{Type: TypeRun})
+	assert.EqualError(t, err, "signaling server returned status code 401")
+	err = NewPeer(ts.URL, "wrong", "room", RoleOfferer).Send(ctx, Message{Type: TypeRun})
+	assert.Error(t, err)
+
+	assert.NoError(t, NewPeer(ts.URL, "s3cret", "room", RoleOfferer).Send(ctx, Message{Type: TypeRun}))
+	msgs, err := NewPeer(ts.URL, "s3cret", "room", RoleAnswerer).Receive(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(msgs))
+
+	res, err := http.Get(ts.URL + "/rooms/room?after=0")
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestExpiredRoomsAreDropped(t *testing.T) {
+	srv := NewServer("", "", slog.Default())
+	srv.Post("run1", Message{From: RoleOfferer, Type: TypeOffer})
+	srv.Post("run2", Message{From: RoleOfferer, Type: TypeOffer})
+	assert.Equal(t, 2, srv.Rooms())
+
+	srv.mu.Lock()
+	srv.expire(time.Now().Add(time.Second))
+	srv.mu.Unlock()
+	assert.Equal(t, 0, srv.Rooms())
+
+	// numbers keep increasing when a dropped room is used again
+	assert.Equal(t, 3, srv.Post("run1", Message{From: RoleOfferer, Type: TypeOffer}))
+}
+
+func TestStartFailsWhenAddressIsTaken(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+
+	s := NewServer(ln.Addr().String(), "", slog.Default())
+	assert.Error(t, s.Start())
+	assert.NoError(t, s.Close(context.Background()))
+
+	s = NewServer("127.0.0.1:0", "", slog.Default())
+	assert.NoError(t, s.Start())
+	assert.NoError(t, s.Close(context.Background()))
+}
@@ -0,0 +1,100 @@
+// Package signaling carries the offers, answers and candidates of a test
+// between two agents, so its peers can run on different hosts. Agents meet
+// in a room on a signaling server and post messages to it, and read the
+// other agent's messages with HTTP long-polling.
+//
+// A run starts in the room the two agents share: the offerer announces the
+// run with the ICE servers it wants to test, and the answerer accepts the
+// ones it agrees to. The tests of the run then use a room named after the
+// run's ID, which the offerer ends when the run is over.
+package signaling
+
+import (
+	"github.com/nimbleape/iceperf-agent/stats"
+	"github.com/pion/stun/v2"
+	"github.com/pion/webrtc/v4"
+)
+
+// Roles of the two agents in a room
+const (
+	RoleOfferer  = "offerer"
+	RoleAnswerer = "answerer"
+)
+
+// Message types
+const (
+	// TypeRun announces a run, with the ICE servers the offerer wants to test
+	TypeRun = "run"
+	// TypeAccept is the answerer's reply to a run, with the ICE servers it
+	// agrees to test
+	TypeAccept = "accept"
+	// TypeEnd tells the answerer a run is over
+	TypeEnd = "end"
+	// TypeOffer starts a test, with the offer and the answerer's setup
+	TypeOffer = "offer"
+	// TypeAnswer is the answerer's reply to an offer
+	TypeAnswer    = "answer"
+	TypeCandidate = "candidate"
+	// TypeBye ends a test, the answerer replies with TypeStats
+	TypeBye = "bye"
+	// TypeStats carries the answerer's side of the results
+	TypeStats = "stats"
+	// TypeError tells the offerer the answerer couldn't take part
+	TypeError = "error"
+)
+
+// Message is posted to a room by one agent for the other
+type Message struct {
+	// Seq orders the messages of a room, it is set by the server
+	Seq  int    `json:"seq,omitempty"`
+	From string `json:"from"`
+	Type string `json:"type"`
+	// Test is the ID of the ICE server test the message belongs to
+	Test      string                     `json:"test,omitempty"`
+	SDP       *webrtc.SessionDescription `json:"sdp,omitempty"`
+	Candidate *webrtc.ICECandidateInit   `json:"candidate,omitempty"`
+	Setup     *Setup                     `json:"setup,omitempty"`
+	Run       *Run                       `json:"run,omitempty"`
+	Stats     *stats.Stats               `json:"stats,omitempty"`
+	Error     string                     `json:"error,omitempty"`
+}
+
+// Setup is what the answering agent needs to take part in a test. The
+// offerer decides it, so the answerer needs no ICE server config of its own.
+type Setup struct {
+	Provider string `json:"provider"`
+	// URL is the ICE server under test
+	URL        string             `json:"url"`
+	ICEServers []webrtc.ICEServer `json:"iceServers,omitempty"`
+	Relay      bool               `json:"relay,omitempty"`
+	Throughput bool               `json:"throughput,omitempty"`
+}
+
+// Run is a test run of the offering agent
+type Run struct {
+	ID         string      `json:"id"`
+	ICEServers []ICEServer `json:"iceServers"`
+}
+
+// ICEServer is an ICE server URL of a provider, without credentials
+type ICEServer struct {
+	Provider string `json:"provider"`
+	URL      string `json:"url"`
+}
+
+// NormalizeURL returns an ICE server URL in the form it is offered and
+// accepted in, so the same server matches however its URL was written
+func NormalizeURL(u string) string {
+	parsed, err := stun.ParseURI(u)
+	if err != nil {
+		return u
+	}
+	return parsed.String()
+}
+
+// Batch is the reply to a poll: the new messages from the other agent, and
+// the sequence number to poll after next
+type Batch struct {
+	Messages []Message `json:"messages"`
+	Last     int       `json:"last"`
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/queue"
+)
+
+// Send POSTs a JSON encoded payload to the results API, gzipped if
+// configured. Client errors other than timeouts and rate limiting are marked
+// as permanent so that the queue drops them instead of retrying forever.
+func Send(ctx context.Context, apiConfig *config.ApiConfig, payload []byte) error {
+	body := payload
+	if apiConfig.Gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(payload); err != nil {
+			return queue.Permanent(err)
+		}
+		if err := zw.Close(); err != nil {
+			return queue.Permanent(err)
+		}
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", apiConfig.URI, bytes.NewReader(body))
+	if err != nil {
+		return queue.Permanent(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if apiConfig.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	req.Header.Add("Authorization", "Bearer "+apiConfig.ApiKey)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	err = fmt.Errorf("failed to send data, status code: %d", resp.StatusCode)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return queue.Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/queue"
+	"github.com/nimbleape/iceperf-agent/stats"
+	"github.com/nimbleape/iceperf-agent/telemetry"
+)
+
+const (
+	// ModeBatch uploads one document per test run
+	ModeBatch = "batch"
+	// ModeStream uploads each ICE server test's stats as soon as it finishes
+	ModeStream = "stream"
+)
+
+// Sink uploads results to the ICEPerf results API, via the on-disk queue
+// when it could be opened
+type Sink struct {
+	config *config.ApiConfig
+	logger *slog.Logger
+	sender *queue.Sender
+	cancel context.CancelFunc
+}
+
+// New creates a Sink for the results API and starts its background sender.
+// A Sink is returned even when the queue can't be opened, it then sends
+// results once directly.
+func New(ctx context.Context, apiConfig *config.ApiConfig, logger *slog.Logger) (*Sink, error) {
+	s := &Sink{
+		config: apiConfig,
+		logger: logger.With("sink", "api"),
+	}
+
+	dir := apiConfig.Queue.Dir
+	if dir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			cacheDir = os.TempDir()
+		}
+		dir = filepath.Join(cacheDir, "iceperf", "queue")
+	}
+	// each API URL gets its own queue, so the senders of two api sinks never
+	// post each other's results
+	dir = filepath.Join(dir, queueName(apiConfig.URI))
+
+	maxSizeMB := apiConfig.Queue.MaxSizeMB
+	if maxSizeMB == 0 {
+		maxSizeMB = 100
+	}
+
+	q, err := queue.New(dir, int64(maxSizeMB)*1024*1024)
+	if err != nil {
+		return s, err
+	}
+
+	s.sender = queue.NewSender(q, func(ctx context.Context, payload []byte) error {
+		return Send(ctx, apiConfig, payload)
+	}, s.logger)
+	if apiConfig.Queue.MaxBackoff > 0 {
+		s.sender.MaxBackoff = time.Duration(apiConfig.Queue.MaxBackoff) * time.Second
+	}
+	if err := telemetry.ObserveQueueDepth(q); err != nil {
+		s.logger.Error("Error registering result queue metrics", "err", err)
+	}
+
+	ctx, s.cancel = context.WithCancel(ctx)
+	go s.sender.Run(ctx)
+
+	s.logger.Info("Result queue opened", "dir", dir)
+	return s, nil
+}
+
+// queueName is the directory, under the queue dir, of the queue for the API
+// at uri
+func queueName(uri string) string {
+	sum := sha256.Sum256([]byte(uri))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Streaming reports whether each ICE server test is uploaded on its own
+func (s *Sink) Streaming() bool {
+	return s.config.Mode == ModeStream
+}
+
+// Write uploads the stats from a single ICE server test in stream mode, or
+// the whole run document in batch mode
+func (s *Sink) Write(ctx context.Context, run *stats.Run, results []*stats.Stats) error {
+	for _, st := range results {
+		st.CreateLabels()
+	}
+
+	if s.Streaming() {
+		for _, st := range results {
+			if err := s.upload(ctx, st); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return s.upload(ctx, run)
+}
+
+// Close stops the background sender and gives anything still queued a last
+// chance to go out. The rest stays queued until the agent next starts.
+func (s *Sink) Close(ctx context.Context) error {
+	if s.sender == nil {
+		return nil
+	}
+	s.cancel()
+	// the background sender may be sending the head of the queue, wait for
+	// it rather than flushing it a second time
+	select {
+	case <-s.sender.Done():
+	case <-ctx.Done():
+		s.logger.Warn("Results left in queue", "err", ctx.Err(), "dir", s.sender.Queue.Dir())
+		return ctx.Err()
+	}
+	if err := s.sender.Flush(ctx); err != nil {
+		s.logger.Warn("Results left in queue", "err", err, "dir", s.sender.Queue.Dir())
+		return err
+	}
+	return nil
+}
+
+func (s *Sink) upload(ctx context.Context, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if s.sender != nil {
+		// the background sender delivers it, retrying if the API is unreachable
+		return s.sender.Enqueue(payload)
+	}
+
+	if err := Send(ctx, s.config, payload); err != nil {
+		return err
+	}
+	s.logger.Info("Data sent successfully!")
+	return nil
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/queue"
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// receiver is an API that passes every payload it gets to a channel
+func receiver(t *testing.T) (*httptest.Server, chan map[string]any) {
+	got := make(chan map[string]any, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			assert.NoError(t, err)
+			body = zr
+		}
+		var v map[string]any
+		assert.NoError(t, json.NewDecoder(body).Decode(&v))
+		got <- v
+	}))
+	t.Cleanup(srv.Close)
+	return srv, got
+}
+
+func next(t *testing.T, got chan map[string]any) map[string]any {
+	t.Helper()
+	select {
+	case v := <-got:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was sent")
+		return nil
+	}
+}
+
+func TestSendGzipped(t *testing.T) {
+	srv, got := receiver(t)
+	err := Send(context.Background(), &config.ApiConfig{URI: srv.URL, ApiKey: "key", Gzip: true}, []byte(`{"testRunID":"run"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "run", next(t, got)["testRunID"])
+}
+
+func TestSendMarksClientErrorsPermanent(t *testing.T) {
+	for status, permanent := range map[int]bool{
+		http.StatusBadRequest:          true,
+		http.StatusTooManyRequests:     false,
+		http.StatusInternalServerError: false,
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		err := Send(context.Background(), &config.ApiConfig{URI: srv.URL}, []byte(`{}`))
+		srv.Close()
+		assert.Error(t, err)
+		assert.Equal(t, permanent, queue.IsPermanent(err), "status %d", status)
+	}
+}
+
+func TestWriteUploadsRunOrEachTest(t *testing.T) {
+	srv, got := receiver(t)
+	dir := t.TempDir()
+
+	run := stats.NewRun("run", time.Now())
+	st := stats.NewStats("run", time.Now())
+	st.SetProvider("cloudflare")
+	run.Results = []*stats.Stats{st}
+
+	batch, err := New(context.Background(), &config.ApiConfig{URI: srv.URL, ApiKey: "key", Queue: config.QueueConfig{Dir: dir}}, discard)
+	assert.NoError(t, err)
+	defer batch.Close(context.Background())
+	assert.NoError(t, batch.Write(context.Background(), run, run.Results))
+	v := next(t, got)
+	assert.Equal(t, "run", v["testRunID"])
+	assert.Equal(t, "cloudflare", v["results"].([]any)[0].(map[string]any)["provider"])
+
+	stream, err := New(context.Background(), &config.ApiConfig{URI: srv.URL, ApiKey: "key", Mode: ModeStream, Queue: config.QueueConfig{Dir: t.TempDir()}}, discard)
+	assert.NoError(t, err)
+	defer stream.Close(context.Background())
+	assert.True(t, stream.Streaming())
+	assert.NoError(t, stream.Write(context.Background(), run, run.Results))
+	v = next(t, got)
+	assert.Equal(t, "cloudflare", v["provider"])
+	assert.Equal(t, "cloudflare", v["labels"].(map[string]any)["provider"])
+}
+
+func TestSinksKeepTheirOwnQueue(t *testing.T) {
+	dir := t.TempDir()
+	srvA, gotA := receiver(t)
+	srvB, gotB := receiver(t)
+
+	a, err := New(context.Background(), &config.ApiConfig{URI: srvA.URL, ApiKey: "key", Queue: config.QueueConfig{Dir: dir}}, discard)
+	assert.NoError(t, err)
+	defer a.Close(context.Background())
+	b, err := New(context.Background(), &config.ApiConfig{URI: srvB.URL, ApiKey: "key", Queue: config.QueueConfig{Dir: dir}}, discard)
+	assert.NoError(t, err)
+	defer b.Close(context.Background())
+	assert.NotEqual(t, a.sender.Queue.Dir(), b.sender.Queue.Dir())
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, a.Write(context.Background(), stats.NewRun("a", time.Now()), nil))
+		assert.NoError(t, b.Write(context.Background(), stats.NewRun("b", time.Now()), nil))
+	}
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, "a", next(t, gotA)["testRunID"])
+		assert.Equal(t, "b", next(t, gotB)["testRunID"])
+	}
+}
@@ -0,0 +1,73 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+// Sink writes run documents to disk. If Path is a directory (or ends in a
+// path separator) each run is written to its own <testRunID>.json file,
+// otherwise runs are appended to Path as JSON lines.
+type Sink struct {
+	Config *config.SinkConfig
+	mu     sync.Mutex
+}
+
+func New(cfg *config.SinkConfig) *Sink {
+	return &Sink{
+		Config: cfg,
+	}
+}
+
+func (s *Sink) Write(ctx context.Context, run *stats.Run, results []*stats.Stats) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc := *run
+	doc.Results = results
+
+	if s.isDir() {
+		if err := os.MkdirAll(s.Config.Path, 0o755); err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(s.Config.Path, run.TestRunID+".json"), data, 0o644)
+	}
+
+	if dir := filepath.Dir(s.Config.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.Config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *Sink) isDir() bool {
+	if strings.HasSuffix(s.Config.Path, string(os.PathSeparator)) || strings.HasSuffix(s.Config.Path, "/") {
+		return true
+	}
+	info, err := os.Stat(s.Config.Path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,51 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+func TestWriteAppendsJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results", "runs.jsonl")
+	s := New(&config.SinkConfig{Path: path})
+
+	for _, id := range []string{"a", "b"} {
+		assert.NoError(t, s.Write(context.Background(), stats.NewRun(id, time.Now()), nil))
+	}
+
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var run stats.Run
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &run))
+		ids = append(ids, run.TestRunID)
+	}
+	assert.Equal(t, []string{"a", "b"}, ids)
+}
+
+func TestWriteFilePerRunInDirectory(t *testing.T) {
+	dir := t.TempDir() + string(os.PathSeparator)
+	s := New(&config.SinkConfig{Path: dir})
+
+	st := stats.NewStats("a", time.Now())
+	st.SetProvider("cloudflare")
+	assert.NoError(t, s.Write(context.Background(), stats.NewRun("a", time.Now()), []*stats.Stats{st}))
+
+	data, err := os.ReadFile(filepath.Join(dir, "a.json"))
+	assert.NoError(t, err)
+	var run stats.Run
+	assert.NoError(t, json.Unmarshal(data, &run))
+	assert.Equal(t, "cloudflare", run.Results[0].Provider)
+}
@@ -0,0 +1,35 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+// Sink writes each ICE server test's stats as a JSON log line, which is how
+// results reach Loki when the Loki log handler is enabled
+type Sink struct {
+	Logger *slog.Logger
+}
+
+func New(logger *slog.Logger) *Sink {
+	return &Sink{
+		Logger: logger,
+	}
+}
+
+func (s *Sink) Streaming() bool {
+	return true
+}
+
+func (s *Sink) Write(ctx context.Context, run *stats.Run, results []*stats.Stats) error {
+	for _, st := range results {
+		j, err := st.ToJSON()
+		if err != nil {
+			return err
+		}
+		s.Logger.Info(j, "individual_test_completed", "true", "testRunId", run.TestRunID, "Provider", st.Provider)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+func TestWriteLogsEachTest(t *testing.T) {
+	var out bytes.Buffer
+	s := New(slog.New(slog.NewJSONHandler(&out, nil)))
+
+	st := stats.NewStats("run", time.Now())
+	st.SetProvider("cloudflare")
+	assert.NoError(t, s.Write(context.Background(), stats.NewRun("run", time.Now()), []*stats.Stats{st}))
+
+	var line map[string]any
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &line))
+	assert.Equal(t, "run", line["testRunId"])
+	assert.Equal(t, "cloudflare", line["Provider"])
+
+	var logged stats.Stats
+	assert.NoError(t, json.Unmarshal([]byte(line["msg"].(string)), &logged))
+	assert.Equal(t, "cloudflare", logged.Provider)
+}
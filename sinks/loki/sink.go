@@ -0,0 +1,94 @@
+package loki
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+// Sink pushes each ICE server test's stats to Loki as a JSON log line, in a
+// stream labelled with the provider, scheme and protocol
+type Sink struct {
+	Config *config.SinkConfig
+	Client *http.Client
+}
+
+type pushStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+type pushRequest struct {
+	Streams []pushStream `json:"streams"`
+}
+
+func New(cfg *config.SinkConfig) *Sink {
+	return &Sink{
+		Config: cfg,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *Sink) Write(ctx context.Context, run *stats.Run, results []*stats.Stats) error {
+	push := pushRequest{}
+	ts := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	for _, st := range results {
+		st.CreateLabels()
+		line, err := st.ToJSON()
+		if err != nil {
+			return err
+		}
+
+		labels := map[string]string{
+			"app":      "iceperf",
+			"provider": st.Provider,
+			"scheme":   st.Scheme,
+			"protocol": st.Protocol,
+			"node":     st.Node,
+		}
+		for k, v := range s.Config.Labels {
+			labels[k] = v
+		}
+
+		push.Streams = append(push.Streams, pushStream{
+			Stream: labels,
+			Values: [][2]string{{ts, line}},
+		})
+	}
+
+	payload, err := json.Marshal(push)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.Config.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.Config.Headers {
+		req.Header.Set(k, v)
+	}
+	if s.Config.Username != "" {
+		req.SetBasicAuth(s.Config.Username, s.Config.Password)
+	}
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("loki returned status code %d", res.StatusCode)
+	}
+	return nil
+}
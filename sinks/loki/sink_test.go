@@ -0,0 +1,70 @@
+package loki
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+func TestWritePushesAStreamPerTest(t *testing.T) {
+	var got pushRequest
+	var user, pass string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "tenant", r.Header.Get("X-Scope-OrgID"))
+		user, pass, _ = r.BasicAuth()
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s := New(&config.SinkConfig{
+		URL:      srv.URL,
+		Headers:  map[string]string{"X-Scope-OrgID": "tenant"},
+		Username: "user",
+		Password: "pass",
+		Labels:   map[string]string{"env": "test"},
+	})
+
+	st := stats.NewStats("run", time.Now())
+	st.SetProvider("cloudflare")
+	st.SetScheme("turn")
+	st.SetProtocol("udp")
+	st.SetNode("london")
+	err := s.Write(context.Background(), stats.NewRun("run", time.Now()), []*stats.Stats{st})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "user", user)
+	assert.Equal(t, "pass", pass)
+	assert.Equal(t, 1, len(got.Streams))
+	assert.Equal(t, map[string]string{
+		"app":      "iceperf",
+		"provider": "cloudflare",
+		"scheme":   "turn",
+		"protocol": "udp",
+		"node":     "london",
+		"env":      "test",
+	}, got.Streams[0].Stream)
+
+	var line map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(got.Streams[0].Values[0][1]), &line))
+	assert.Equal(t, "cloudflare", line["provider"])
+	assert.Equal(t, "london", line["labels"].(map[string]any)["location"])
+}
+
+func TestWriteFailsOnErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	err := New(&config.SinkConfig{URL: srv.URL}).Write(context.Background(), stats.NewRun("run", time.Now()), nil)
+	assert.EqualError(t, err, "loki returned status code 400")
+}
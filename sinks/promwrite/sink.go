@@ -0,0 +1,164 @@
+package promwrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/stats"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Sink sends each ICE server test's stats as samples to a Prometheus
+// remote write endpoint
+type Sink struct {
+	Config *config.SinkConfig
+	Client *http.Client
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+type series struct {
+	labels    []label
+	value     float64
+	timestamp int64
+}
+
+func New(cfg *config.SinkConfig) *Sink {
+	return &Sink{
+		Config: cfg,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *Sink) Write(ctx context.Context, run *stats.Run, results []*stats.Stats) error {
+	ts := time.Now().UnixMilli()
+
+	var all []series
+	for _, st := range results {
+		connected := 0.0
+		if st.Connected {
+			connected = 1
+		}
+		values := map[string]float64{
+			"iceperf_connected":                     connected,
+			"iceperf_time_to_connected_ms":          float64(st.TimeToConnectedState),
+			"iceperf_offerer_time_to_candidate_ms":  st.OffererTimeToReceiveCandidate,
+			"iceperf_answerer_time_to_candidate_ms": st.AnswererTimeToReceiveCandidate,
+			"iceperf_latency_first_packet_ms":       st.LatencyFirstPacket,
+			"iceperf_throughput_max_mbps":           st.ThroughputMax,
+			"iceperf_offerer_dc_bytes_sent":         st.OffererDcBytesSentTotal,
+			"iceperf_answerer_dc_bytes_received":    st.AnswererDcBytesReceivedTotal,
+		}
+		for name, v := range values {
+			labels := []label{
+				{"__name__", name},
+				{"provider", st.Provider},
+				{"scheme", st.Scheme},
+				{"protocol", st.Protocol},
+				{"port", st.Port},
+				{"node", st.Node},
+			}
+			// one series per test of the run, as the tests of a provider
+			// otherwise share the labels above and the same timestamp
+			for _, l := range []label{
+				{"url", st.URL},
+				{"address", st.ResolvedIP},
+				{"family", st.AddressFamily},
+				{"answerer", st.AnswererURL},
+				{"sample", sampleLabel(st.Sample)},
+			} {
+				if l.value != "" {
+					labels = append(labels, l)
+				}
+			}
+			for k, v := range s.Config.Labels {
+				labels = append(labels, label{k, v})
+			}
+			sort.Slice(labels, func(i, j int) bool {
+				return labels[i].name < labels[j].name
+			})
+			all = append(all, series{labels: labels, value: v, timestamp: ts})
+		}
+	}
+
+	body := snappy.Encode(nil, encodeWriteRequest(all))
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.Config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for k, v := range s.Config.Headers {
+		req.Header.Set(k, v)
+	}
+	if s.Config.Username != "" {
+		req.SetBasicAuth(s.Config.Username, s.Config.Password)
+	}
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("prometheus remote write returned status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+func sampleLabel(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// encodeWriteRequest encodes a prometheus.WriteRequest protobuf message:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(all []series) []byte {
+	var b []byte
+	for _, s := range all {
+		var ts []byte
+		for _, l := range s.labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.value)
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, lb)
+		}
+
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(s.value))
+		sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(s.timestamp))
+
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sb)
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts)
+	}
+	return b
+}
@@ -0,0 +1,125 @@
+package promwrite
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/golang/snappy"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/stats"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeWriteRequest is the inverse of encodeWriteRequest, keyed on each
+// series' labels
+func decodeWriteRequest(t *testing.T, b []byte) map[string]series {
+	t.Helper()
+	fields := func(b []byte, each func(num protowire.Number, typ protowire.Type, v []byte, x uint64)) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			assert.True(t, n > 0)
+			b = b[n:]
+			switch typ {
+			case protowire.BytesType:
+				v, n := protowire.ConsumeBytes(b)
+				assert.True(t, n > 0)
+				each(num, typ, v, 0)
+				b = b[n:]
+			case protowire.Fixed64Type:
+				x, n := protowire.ConsumeFixed64(b)
+				assert.True(t, n > 0)
+				each(num, typ, nil, x)
+				b = b[n:]
+			case protowire.VarintType:
+				x, n := protowire.ConsumeVarint(b)
+				assert.True(t, n > 0)
+				each(num, typ, nil, x)
+				b = b[n:]
+			default:
+				t.Fatalf("unexpected wire type %d", typ)
+			}
+		}
+	}
+
+	out := map[string]series{}
+	fields(b, func(num protowire.Number, _ protowire.Type, ts []byte, _ uint64) {
+		assert.Equal(t, protowire.Number(1), num)
+		var s series
+		fields(ts, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) {
+			switch num {
+			case 1:
+				var l label
+				fields(v, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) {
+					if num == 1 {
+						l.name = string(v)
+					} else {
+						l.value = string(v)
+					}
+				})
+				s.labels = append(s.labels, l)
+			case 2:
+				fields(v, func(num protowire.Number, _ protowire.Type, _ []byte, x uint64) {
+					if num == 1 {
+						s.value = math.Float64frombits(x)
+					} else {
+						s.timestamp = int64(x)
+					}
+				})
+			}
+		})
+		key := ""
+		for _, l := range s.labels {
+			key += l.name + "=" + l.value + ","
+		}
+		out[key] = s
+	})
+	return out
+}
+
+func TestWriteSendsRemoteWriteRequest(t *testing.T) {
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "0.1.0", r.Header.Get("X-Prometheus-Remote-Write-Version"))
+		compressed, _ := io.ReadAll(r.Body)
+		var err error
+		body, err = snappy.Decode(nil, compressed)
+		assert.NoError(t, err)
+	}))
+	defer srv.Close()
+
+	st := stats.NewStats("run", time.Now())
+	st.SetProvider("cloudflare")
+	st.SetScheme("turn")
+	st.SetProtocol("udp")
+	st.SetPort("3478")
+	st.SetNode("london")
+	st.SetURL("turn:turn.example.com:3478?transport=udp")
+	st.SetTimeToConnectedState(120)
+	st.Sample = 2
+
+	before := time.Now().UnixMilli()
+	err := New(&config.SinkConfig{URL: srv.URL, Labels: map[string]string{"env": "test"}}).
+		Write(context.Background(), stats.NewRun("run", time.Now()), []*stats.Stats{st})
+	assert.NoError(t, err)
+
+	all := decodeWriteRequest(t, body)
+	assert.Equal(t, 8, len(all))
+
+	labels := ",env=test,node=london,port=3478,protocol=udp,provider=cloudflare,sample=2,scheme=turn,url=turn:turn.example.com:3478?transport=udp,"
+	s, ok := all["__name__=iceperf_time_to_connected_ms"+labels]
+	assert.True(t, ok)
+	assert.Equal(t, 120.0, s.value)
+	assert.True(t, s.timestamp >= before && s.timestamp <= time.Now().UnixMilli())
+
+	s, ok = all["__name__=iceperf_connected"+labels]
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, s.value)
+}
@@ -0,0 +1,156 @@
+package stdout
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/fatih/color"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/stats"
+	"github.com/rodaine/table"
+)
+
+// Sink prints the results of a run to stdout, as a table or as JSON
+type Sink struct {
+	Config *config.SinkConfig
+	Out    io.Writer
+}
+
+func New(cfg *config.SinkConfig) *Sink {
+	return &Sink{
+		Config: cfg,
+		Out:    os.Stdout,
+	}
+}
+
+func (s *Sink) Write(ctx context.Context, run *stats.Run, results []*stats.Stats) error {
+	if s.Config.Format == "json" {
+		enc := json.NewEncoder(s.Out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(run)
+	}
+
+	headerFmt := color.New(color.FgGreen, color.Underline).SprintfFunc()
+	columnFmt := color.New(color.FgYellow).SprintfFunc()
+
+	tbl := table.New("Provider", "Scheme", "Protocol", "Family", "Address", "DNS Lookup", "Time to candidate", "Time to Connected State", "Max Throughput", "TURN Transfer Latency")
+	tbl.WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt).WithWriter(s.Out)
+
+	for _, st := range results {
+		address := st.ResolvedIP
+		if st.AddressMismatch {
+			address += " (mismatch)"
+		}
+		tbl.AddRow(st.Endpoints(), st.Scheme, st.Protocol, st.AddressFamily, address, st.DNSLookupTime, st.OffererTimeToReceiveCandidate, st.TimeToConnectedState, st.ThroughputMax, st.LatencyFirstPacket)
+	}
+
+	tbl.Print()
+
+	if env := run.Environment; env != nil && env.NAT != nil {
+		n := env.NAT
+		fmt.Fprintf(s.Out, "\nNAT: public IP %s, mapping %s, filtering %s", n.PublicIP, n.Mapping, n.Filtering)
+		if n.Hairpinning != nil {
+			fmt.Fprintf(s.Out, ", hairpinning %t", *n.Hairpinning)
+		}
+		if n.Error != "" {
+			fmt.Fprintf(s.Out, " (%s)", n.Error)
+		}
+		fmt.Fprintln(s.Out)
+	}
+
+	s.writeFamilies(results)
+
+	if len(run.Aggregates) > 0 {
+		s.writeAggregates(run.Aggregates)
+	}
+	return nil
+}
+
+// writeFamilies prints how many tests of each provider connected over IPv4
+// and over IPv6, when the results were tested on both
+func (s *Sink) writeFamilies(results []*stats.Stats) {
+	type count struct{ tests, connected int }
+	counts := map[string]map[string]*count{}
+	families := map[string]bool{}
+	var providers []string
+	for _, st := range results {
+		if st.AddressFamily == "" {
+			continue
+		}
+		families[st.AddressFamily] = true
+		if _, ok := counts[st.Provider]; !ok {
+			counts[st.Provider] = map[string]*count{}
+			providers = append(providers, st.Provider)
+		}
+		c, ok := counts[st.Provider][st.AddressFamily]
+		if !ok {
+			c = &count{}
+			counts[st.Provider][st.AddressFamily] = c
+		}
+		c.tests++
+		if st.Connected {
+			c.connected++
+		}
+	}
+	if len(families) < 2 {
+		return
+	}
+
+	headerFmt := color.New(color.FgGreen, color.Underline).SprintfFunc()
+	columnFmt := color.New(color.FgYellow).SprintfFunc()
+
+	fmt.Fprintln(s.Out)
+	tbl := table.New("Provider", "IPv4 Connected", "IPv6 Connected")
+	tbl.WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt).WithWriter(s.Out)
+	for _, provider := range providers {
+		row := []interface{}{provider}
+		for _, family := range []string{"ipv4", "ipv6"} {
+			c, ok := counts[provider][family]
+			if !ok {
+				row = append(row, "-")
+				continue
+			}
+			row = append(row, fmt.Sprintf("%d/%d", c.connected, c.tests))
+		}
+		tbl.AddRow(row...)
+	}
+	tbl.Print()
+}
+
+// writeAggregates prints the success ratio of each ICE server URL, then the
+// summary of every metric over its samples
+func (s *Sink) writeAggregates(aggregates []*stats.Aggregate) {
+	headerFmt := color.New(color.FgGreen, color.Underline).SprintfFunc()
+	columnFmt := color.New(color.FgYellow).SprintfFunc()
+
+	fmt.Fprintln(s.Out)
+	tbl := table.New("Provider", "URL", "Samples", "Successes", "Success Ratio")
+	tbl.WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt).WithWriter(s.Out)
+	for _, a := range aggregates {
+		tbl.AddRow(a.Provider, a.URL, a.Samples, a.Successes, fmt.Sprintf("%.1f%%", a.SuccessRatio*100))
+	}
+	tbl.Print()
+
+	fmt.Fprintln(s.Out)
+	tbl = table.New("Provider", "URL", "Metric", "Mean", "Median", "StdDev", "p90", "p95", "Min", "Max")
+	tbl.WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt).WithWriter(s.Out)
+	for _, a := range aggregates {
+		for _, name := range stats.MetricNames() {
+			m, ok := a.Metrics[name]
+			if !ok {
+				continue
+			}
+			tbl.AddRow(a.Provider, a.URL, name, format(m.Mean), format(m.Median), format(m.StdDev),
+				format(m.P90), format(m.P95), format(m.Min), format(m.Max))
+		}
+	}
+	tbl.Print()
+}
+
+func format(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
@@ -0,0 +1,60 @@
+package stdout
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+func results() []*stats.Stats {
+	var out []*stats.Stats
+	for _, family := range []string{"ipv4", "ipv6"} {
+		st := stats.NewStats("run", time.Now())
+		st.SetProvider("cloudflare")
+		st.SetScheme("turn")
+		st.SetProtocol("udp")
+		st.SetAddressFamily(family)
+		st.Connected = family == "ipv4"
+		out = append(out, st)
+	}
+	return out
+}
+
+func TestWriteTable(t *testing.T) {
+	var out bytes.Buffer
+	s := New(&config.SinkConfig{Format: "table"})
+	s.Out = &out
+
+	run := stats.NewRun("run", time.Now())
+	run.Results = results()
+	assert.NoError(t, s.Write(context.Background(), run, run.Results))
+
+	text := out.String()
+	assert.Contains(t, text, "Time to Connected State")
+	assert.Contains(t, text, "cloudflare")
+	// both families were tested, so the per family table is printed
+	assert.Contains(t, text, "IPv4 Connected")
+	assert.True(t, strings.Contains(text, "1/1") && strings.Contains(text, "0/1"))
+}
+
+func TestWriteJSON(t *testing.T) {
+	var out bytes.Buffer
+	s := New(&config.SinkConfig{Format: "json"})
+	s.Out = &out
+
+	run := stats.NewRun("run", time.Now())
+	run.Results = results()
+	assert.NoError(t, s.Write(context.Background(), run, run.Results))
+
+	var got stats.Run
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &got))
+	assert.Equal(t, "run", got.TestRunID)
+	assert.Equal(t, 2, len(got.Results))
+}
@@ -0,0 +1,24 @@
+package sinks
+
+import (
+	"context"
+
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+// Sink is a destination for test results
+type Sink interface {
+	Write(ctx context.Context, run *stats.Run, results []*stats.Stats) error
+}
+
+// Streamer is implemented by sinks that want each ICE server test's stats as
+// soon as it finishes, rather than all of them once the run has finished
+type Streamer interface {
+	Streaming() bool
+}
+
+// Closer is implemented by sinks that need to flush or release resources
+// when the agent exits
+type Closer interface {
+	Close(ctx context.Context) error
+}
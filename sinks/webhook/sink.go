@@ -0,0 +1,59 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+// Sink POSTs the run document to a generic webhook
+type Sink struct {
+	Config *config.SinkConfig
+	Client *http.Client
+}
+
+func New(cfg *config.SinkConfig) *Sink {
+	return &Sink{
+		Config: cfg,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *Sink) Write(ctx context.Context, run *stats.Run, results []*stats.Stats) error {
+	doc := *run
+	doc.Results = results
+
+	payload, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.Config.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.Config.Headers {
+		req.Header.Set(k, v)
+	}
+	if s.Config.Username != "" {
+		req.SetBasicAuth(s.Config.Username, s.Config.Password)
+	}
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status code %d", res.StatusCode)
+	}
+	return nil
+}
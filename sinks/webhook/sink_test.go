@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/stats"
+)
+
+func TestWritePostsRunDocument(t *testing.T) {
+	var got stats.Run
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	run := stats.NewRun("run", time.Now())
+	run.Node = "london"
+	st := stats.NewStats("run", time.Now())
+	st.SetProvider("cloudflare")
+
+	s := New(&config.SinkConfig{URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer token"}})
+	assert.NoError(t, s.Write(context.Background(), run, []*stats.Stats{st}))
+
+	assert.Equal(t, "Bearer token", auth)
+	assert.Equal(t, "run", got.TestRunID)
+	assert.Equal(t, "london", got.Node)
+	assert.Equal(t, 1, len(got.Results))
+	assert.Equal(t, "cloudflare", got.Results[0].Provider)
+	// the run itself is left alone
+	assert.Equal(t, 0, len(run.Results))
+}
+
+func TestWriteFailsOnErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	err := New(&config.SinkConfig{URL: srv.URL}).Write(context.Background(), stats.NewRun("run", time.Now()), nil)
+	assert.EqualError(t, err, "webhook returned status code 502")
+}
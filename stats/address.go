@@ -0,0 +1,43 @@
+package stats
+
+import "fmt"
+
+// FlagAddressMismatches marks the failed tests of hosts that were tested on
+// each of their addresses separately, when another address of the same host
+// connected on the same transport and port, e.g. an AAAA record pointing at
+// a server that doesn't serve TURN. It returns the flagged stats.
+func FlagAddressMismatches(results []*Stats) []*Stats {
+	groups := map[string][]*Stats{}
+	var keys []string
+	for _, s := range results {
+		if s.Host == "" || s.ResolvedIP == "" {
+			continue
+		}
+		key := fmt.Sprintf("%s|%s|%s|%s|%s|%d|%s", s.Provider, s.Scheme, s.Protocol, s.Host, s.Port, s.Sample, s.AnswererURL)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], s)
+	}
+
+	var flagged []*Stats
+	for _, key := range keys {
+		group := groups[key]
+		addresses := map[string]bool{}
+		connected := false
+		for _, s := range group {
+			addresses[s.ResolvedIP] = true
+			connected = connected || s.Connected
+		}
+		if len(addresses) < 2 || !connected {
+			continue
+		}
+		for _, s := range group {
+			if !s.Connected {
+				s.AddressMismatch = true
+				flagged = append(flagged, s)
+			}
+		}
+	}
+	return flagged
+}
@@ -0,0 +1,36 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestFlagAddressMismatches(t *testing.T) {
+	result := func(provider, host, ip string, connected bool) *Stats {
+		s := NewStats("run", time.Now())
+		s.SetProvider(provider)
+		s.SetScheme("turn")
+		s.SetProtocol("udp")
+		s.SetPort("3478")
+		s.SetHost(host)
+		s.SetResolvedIP(ip)
+		s.Connected = connected
+		return s
+	}
+	v4 := result("coturn", "turn.example.com", "192.0.2.1", true)
+	v6 := result("coturn", "turn.example.com", "2001:db8::1", false)
+	// both addresses failed, so the server is down rather than mismatched
+	downV4 := result("other", "turn.example.net", "192.0.2.2", false)
+	downV6 := result("other", "turn.example.net", "2001:db8::2", false)
+	// a single address isn't compared with anything
+	single := result("single", "turn.example.org", "192.0.2.3", false)
+
+	flagged := FlagAddressMismatches([]*Stats{v4, v6, downV4, downV6, single})
+	assert.Equal(t, []*Stats{v6}, flagged)
+	assert.True(t, v6.AddressMismatch)
+	assert.False(t, v4.AddressMismatch)
+	assert.False(t, downV6.AddressMismatch)
+	assert.False(t, single.AddressMismatch)
+}
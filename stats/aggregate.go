@@ -0,0 +1,142 @@
+package stats
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Summary describes the distribution of one metric over repeated samples
+type Summary struct {
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	StdDev float64 `json:"stddev"`
+	P90    float64 `json:"p90"`
+	P95    float64 `json:"p95"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+}
+
+// Summarize returns the Summary of values
+func Summarize(values []float64) Summary {
+	if len(values) == 0 {
+		return Summary{}
+	}
+	return Summary{
+		Mean:   Mean(values),
+		Median: Median(values),
+		StdDev: StdDev(values),
+		P90:    Percentile(values, 90),
+		P95:    Percentile(values, 95),
+		Min:    Percentile(values, 0),
+		Max:    Percentile(values, 100),
+	}
+}
+
+// Aggregate is the summary of every sample of a single ICE server URL. The
+// metrics only include samples that connected, failures are counted in the
+// success ratio.
+type Aggregate struct {
+	Provider      string             `json:"provider"`
+	Scheme        string             `json:"scheme"`
+	Protocol      string             `json:"protocol"`
+	Port          string             `json:"port"`
+	URL           string             `json:"url"`
+	AddressFamily string             `json:"addressFamily,omitempty"`
+	AnswererURL   string             `json:"answererUrl,omitempty"`
+	Samples       int                `json:"samples"`
+	Successes     int                `json:"successes"`
+	SuccessRatio  float64            `json:"successRatio"`
+	Metrics       map[string]Summary `json:"metrics"`
+}
+
+// MetricNames returns the JSON names of the numeric Stats fields that are
+// aggregated, in the order they are declared
+func MetricNames() []string {
+	var names []string
+	t := reflect.TypeOf(Stats{})
+	for i := 0; i < t.NumField(); i++ {
+		if name, ok := metricName(t.Field(i)); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func metricName(f reflect.StructField) (string, bool) {
+	switch f.Type.Kind() {
+	case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int32, reflect.Int64:
+	default:
+		return "", false
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" || name == "-" || name == "sample" {
+		return "", false
+	}
+	return name, true
+}
+
+// numericMetrics returns every aggregated metric of s by JSON name
+func numericMetrics(s *Stats) map[string]float64 {
+	metrics := make(map[string]float64)
+	v := reflect.ValueOf(s).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := metricName(t.Field(i))
+		if !ok {
+			continue
+		}
+		f := v.Field(i)
+		if f.CanFloat() {
+			metrics[name] = f.Float()
+		} else {
+			metrics[name] = float64(f.Int())
+		}
+	}
+	return metrics
+}
+
+// AggregateResults groups results by ICE server URL and address family and
+// summarizes every numeric metric, keeping the order each URL was first
+// tested in
+func AggregateResults(results []*Stats) []*Aggregate {
+	var aggregates []*Aggregate
+	byURL := make(map[string]*Aggregate)
+	samples := make(map[string]map[string][]float64)
+
+	for _, s := range results {
+		key := s.Provider + "|" + s.URL + "|" + s.AddressFamily + "|" + s.AnswererURL
+		a, ok := byURL[key]
+		if !ok {
+			a = &Aggregate{
+				Provider:      s.Endpoints(),
+				Scheme:        s.Scheme,
+				Protocol:      s.Protocol,
+				Port:          s.Port,
+				URL:           s.URL,
+				AddressFamily: s.AddressFamily,
+				AnswererURL:   s.AnswererURL,
+				Metrics:       make(map[string]Summary),
+			}
+			byURL[key] = a
+			samples[key] = make(map[string][]float64)
+			aggregates = append(aggregates, a)
+		}
+
+		a.Samples++
+		if !s.Connected {
+			continue
+		}
+		a.Successes++
+		for name, value := range numericMetrics(s) {
+			samples[key][name] = append(samples[key][name], value)
+		}
+	}
+
+	for key, a := range byURL {
+		a.SuccessRatio = float64(a.Successes) / float64(a.Samples)
+		for name, values := range samples[key] {
+			a.Metrics[name] = Summarize(values)
+		}
+	}
+	return aggregates
+}
@@ -0,0 +1,41 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestAggregateResults(t *testing.T) {
+	var results []*Stats
+	for i, ms := range []int64{100, 200, 300, 0} {
+		s := NewStats("run", time.Now())
+		s.SetProvider("cloudflare")
+		s.SetURL("turn:turn.example.com:3478?transport=udp")
+		s.SetSample(i + 1)
+		if ms > 0 {
+			s.SetTimeToConnectedState(ms)
+		}
+		results = append(results, s)
+	}
+
+	aggregates := AggregateResults(results)
+	assert.Equal(t, 1, len(aggregates))
+
+	a := aggregates[0]
+	assert.Equal(t, 4, a.Samples)
+	assert.Equal(t, 3, a.Successes)
+	assert.Equal(t, 0.75, a.SuccessRatio)
+
+	connected := a.Metrics["timeToConnectedState"]
+	assert.Equal(t, 200.0, connected.Mean)
+	assert.Equal(t, 200.0, connected.Median)
+	assert.Equal(t, 100.0, connected.StdDev)
+	assert.Equal(t, 100.0, connected.Min)
+	assert.Equal(t, 300.0, connected.Max)
+	assert.Equal(t, 280.0, connected.P90)
+
+	_, ok := a.Metrics["sample"]
+	assert.False(t, ok)
+}
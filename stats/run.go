@@ -0,0 +1,107 @@
+package stats
+
+import (
+	"encoding/json"
+	"runtime"
+	"time"
+
+	"github.com/nimbleape/iceperf-agent/nat"
+	"github.com/nimbleape/iceperf-agent/version"
+)
+
+// Environment describes the agent that produced a run's results
+type Environment struct {
+	AgentVersion string `json:"agentVersion,omitempty"`
+	OS           string `json:"os"`
+	Arch         string `json:"arch"`
+	GoVersion    string `json:"goVersion"`
+	// PublicIP and ASN come from the configured lookup, or the NAT probe
+	PublicIP string `json:"publicIP,omitempty"`
+	ASN      string `json:"asn,omitempty"`
+	ASOrg    string `json:"asOrg,omitempty"`
+	// Interfaces lists the network interfaces that are up, and MTU is the
+	// MTU of the one used to reach the internet
+	Interfaces []Interface `json:"interfaces,omitempty"`
+	MTU        int         `json:"mtu,omitempty"`
+	// Container is the container runtime the agent runs in, if any, e.g.
+	// docker, podman or kubernetes
+	Container string `json:"container,omitempty"`
+	// ClockOffset is how far the local clock is ahead of the NTP server,
+	// in milliseconds
+	ClockOffset float64 `json:"clockOffset,omitempty"`
+	NTPServer   string  `json:"ntpServer,omitempty"`
+	// NAT is the behavior of the NAT the agent is behind, when probed
+	NAT *nat.Result `json:"nat,omitempty"`
+}
+
+// Interface is a network interface of the agent's host
+type Interface struct {
+	Name string `json:"name"`
+	// Type is ethernet, wifi, cellular, tunnel, virtual or unknown
+	Type string `json:"type"`
+	MTU  int    `json:"mtu"`
+}
+
+// Run is the document describing a whole test run, with the stats from every
+// ICE server tested during it
+type Run struct {
+	TestRunID    string       `json:"testRunID"`
+	Node         string       `json:"node"`
+	AgentVersion string       `json:"agentVersion"`
+	StartedAt    time.Time    `json:"startedAt"`
+	EndedAt      time.Time    `json:"endedAt"`
+	Environment  *Environment `json:"environment,omitempty"`
+	Results      []*Stats     `json:"results"`
+	// Aggregates summarizes the results per ICE server URL when each was
+	// tested more than once
+	Aggregates []*Aggregate `json:"aggregates,omitempty"`
+}
+
+// NewRun creates a new Run for the agent this is running on
+func NewRun(testRunID string, startedAt time.Time) *Run {
+	return &Run{
+		TestRunID:    testRunID,
+		AgentVersion: version.Version,
+		StartedAt:    startedAt,
+		Environment: &Environment{
+			AgentVersion: version.Version,
+			OS:           runtime.GOOS,
+			Arch:         runtime.GOARCH,
+			GoVersion:    runtime.Version(),
+		},
+		Results: []*Stats{},
+	}
+}
+
+// AddResult appends the stats from a single ICE server test, attaching the
+// run's environment to them
+func (r *Run) AddResult(s *Stats) {
+	s.Environment = r.Environment
+	r.Results = append(r.Results, s)
+}
+
+// Finish marks the run as ended
+func (r *Run) Finish() {
+	r.EndedAt = time.Now()
+}
+
+// Aggregate summarizes the results per ICE server URL
+func (r *Run) Aggregate() {
+	r.Aggregates = AggregateResults(r.Results)
+}
+
+// FlagAddressMismatches marks the failed tests of addresses whose host
+// connected on another address, see FlagAddressMismatches
+func (r *Run) FlagAddressMismatches() []*Stats {
+	return FlagAddressMismatches(r.Results)
+}
+
+// ToJSON returns the run as a JSON string
+func (r *Run) ToJSON() (string, error) {
+	jsonBytes, err := json.Marshal(r)
+
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
@@ -0,0 +1,98 @@
+package stats
+
+import "sync"
+
+// Soak test events
+const (
+	SoakDisconnected   = "disconnected"
+	SoakFailed         = "failed"
+	SoakReconnected    = "reconnected"
+	SoakICERestart     = "ice_restart"
+	SoakRefreshFailure = "refresh_failure"
+)
+
+// Soak holds what happened to the connection of a soak test over time. Its
+// methods are called from pion callbacks so access is locked.
+type Soak struct {
+	// Duration is how long the connection was kept up for, in milliseconds
+	Duration        int64 `json:"duration"`
+	KeepalivesSent  int   `json:"keepalivesSent"`
+	KeepalivesLost  int   `json:"keepalivesLost"`
+	Disconnections  int   `json:"disconnections"`
+	ICERestarts     int   `json:"iceRestarts"`
+	RefreshFailures int   `json:"refreshFailures"`
+	// RTTDrift is the round trip time of the last sample minus the first,
+	// in milliseconds
+	RTTDrift float64      `json:"rttDrift"`
+	Samples  []SoakSample `json:"samples"`
+	Events   []SoakEvent  `json:"events"`
+
+	mu sync.Mutex
+}
+
+// SoakSample is measured every soak interval. Times are in milliseconds
+// since the test started.
+type SoakSample struct {
+	Time int64 `json:"time"`
+	// RTT is the mean round trip time of the keepalives echoed since the
+	// last sample, 0 if none were
+	RTT float64 `json:"rtt"`
+	// SentKbps and ReceivedKbps are the offerer's ICE transport throughput
+	// since the last sample
+	SentKbps     float64 `json:"sentKbps"`
+	ReceivedKbps float64 `json:"receivedKbps"`
+	State        string  `json:"state"`
+}
+
+// SoakEvent is a change to the connection during a soak test
+type SoakEvent struct {
+	Time   int64  `json:"time"`
+	Type   string `json:"type"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// AddEvent records an event and counts it
+func (s *Soak) AddEvent(e SoakEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch e.Type {
+	case SoakDisconnected:
+		s.Disconnections++
+	case SoakICERestart:
+		s.ICERestarts++
+	case SoakRefreshFailure:
+		s.RefreshFailures++
+	}
+	s.Events = append(s.Events, e)
+}
+
+func (s *Soak) AddSample(sample SoakSample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Samples = append(s.Samples, sample)
+}
+
+// AddKeepalives counts keepalives sent and the ones never echoed
+func (s *Soak) AddKeepalives(sent, lost int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.KeepalivesSent += sent
+	s.KeepalivesLost += lost
+}
+
+// Finish sets how long the connection was kept up for, and the RTT drift
+// between the first and last samples with keepalives echoed
+func (s *Soak) Finish(duration int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Duration = duration
+	var rtts []float64
+	for _, sample := range s.Samples {
+		if sample.RTT > 0 {
+			rtts = append(rtts, sample.RTT)
+		}
+	}
+	if len(rtts) > 1 {
+		s.RTTDrift = rtts[len(rtts)-1] - rtts[0]
+	}
+}
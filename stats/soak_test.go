@@ -0,0 +1,31 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestSoak(t *testing.T) {
+	s := NewStats("run", time.Now()).StartSoak()
+	s.AddEvent(SoakEvent{Time: 1000, Type: SoakDisconnected})
+	s.AddEvent(SoakEvent{Time: 6000, Type: SoakFailed})
+	s.AddEvent(SoakEvent{Time: 6000, Type: SoakICERestart})
+	s.AddEvent(SoakEvent{Time: 7000, Type: SoakReconnected})
+	s.AddEvent(SoakEvent{Time: 300000, Type: SoakRefreshFailure, Detail: "Failed to refresh permissions"})
+	s.AddSample(SoakSample{Time: 10000, RTT: 40})
+	s.AddSample(SoakSample{Time: 20000})
+	s.AddSample(SoakSample{Time: 30000, RTT: 55.5})
+	s.AddKeepalives(30, 2)
+	s.Finish(30500)
+
+	assert.Equal(t, 1, s.Disconnections)
+	assert.Equal(t, 1, s.ICERestarts)
+	assert.Equal(t, 1, s.RefreshFailures)
+	assert.Equal(t, 5, len(s.Events))
+	assert.Equal(t, 15.5, s.RTTDrift)
+	assert.Equal(t, int64(30500), s.Duration)
+	assert.Equal(t, 30, s.KeepalivesSent)
+	assert.Equal(t, 2, s.KeepalivesLost)
+}
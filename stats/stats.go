@@ -27,7 +27,22 @@ type Stats struct {
 	Scheme                                 string            `json:"scheme"`
 	Protocol                               string            `json:"protocol"`
 	Port                                   string            `json:"port"`
+	URL                                    string            `json:"url,omitempty"`
+	Host                                   string            `json:"host,omitempty"`
+	ResolvedIP                             string            `json:"resolvedIP,omitempty"`
+	AddressFamily                          string            `json:"addressFamily,omitempty"`
+	DNSLookupTime                          float64           `json:"dnsLookupTime,omitempty"`
+	DNSError                               string            `json:"dnsError,omitempty"`
+	AddressMismatch                        bool              `json:"addressMismatch,omitempty"`
+	Sample                                 int               `json:"sample,omitempty"`
+	AnswererProvider                       string            `json:"answererProvider,omitempty"`
+	AnswererScheme                         string            `json:"answererScheme,omitempty"`
+	AnswererProtocol                       string            `json:"answererProtocol,omitempty"`
+	AnswererURL                            string            `json:"answererUrl,omitempty"`
+	AnswererNode                           string            `json:"answererNode,omitempty"`
 	Node                                   string            `json:"node"`
+	Environment                            *Environment      `json:"environment,omitempty"`
+	Soak                                   *Soak             `json:"soak,omitempty"`
 	TimeToConnectedState                   int64             `json:"timeToConnectedState"`
 	Connected                              bool              `json:"connected"`
 }
@@ -66,6 +81,69 @@ func (s *Stats) SetPort(st string) {
 	s.Port = st
 }
 
+func (s *Stats) SetURL(st string) {
+	s.URL = st
+}
+
+func (s *Stats) SetHost(st string) {
+	s.Host = st
+}
+
+// SetResolvedIP sets the address the host resolved to when the test started
+func (s *Stats) SetResolvedIP(st string) {
+	s.ResolvedIP = st
+}
+
+// SetAddressFamily sets the address family tested, ipv4 or ipv6
+func (s *Stats) SetAddressFamily(st string) {
+	s.AddressFamily = st
+}
+
+// SetDNSLookup records how long looking up the host took, in milliseconds,
+// and why it failed if it did
+func (s *Stats) SetDNSLookup(d time.Duration, err error) {
+	s.DNSLookupTime = float64(d.Microseconds()) / 1000
+	if err != nil {
+		s.DNSError = err.Error()
+	}
+}
+
+// SetAnswerer records the TURN server the answerer relayed through in a
+// relay-to-relay test
+func (s *Stats) SetAnswerer(provider, scheme, protocol, url string) {
+	s.AnswererProvider = provider
+	s.AnswererScheme = scheme
+	s.AnswererProtocol = protocol
+	s.AnswererURL = url
+}
+
+// SetAnswererNode sets the node ID of the agent that answered in a
+// distributed test
+func (s *Stats) SetAnswererNode(st string) {
+	s.AnswererNode = st
+}
+
+// StartSoak marks the test as a soak test and returns its record
+func (s *Stats) StartSoak() *Soak {
+	s.Soak = &Soak{Samples: []SoakSample{}, Events: []SoakEvent{}}
+	return s.Soak
+}
+
+// Endpoints names the provider tested, or for relay-to-relay tests the
+// offerer's and answerer's providers, e.g. metered>cloudflare
+func (s *Stats) Endpoints() string {
+	if s.AnswererProvider == "" {
+		return s.Provider
+	}
+	return s.Provider + ">" + s.AnswererProvider
+}
+
+// SetSample sets which of the repeated samples of an ICE server test this is,
+// starting from 1
+func (s *Stats) SetSample(n int) {
+	s.Sample = n
+}
+
 func (s *Stats) SetNode(st string) {
 	s.Node = st
 }
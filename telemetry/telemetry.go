@@ -0,0 +1,228 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/stats"
+	"github.com/nimbleape/iceperf-agent/version"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/nimbleape/iceperf-agent"
+
+// Tracer returns the tracer used for all ICEPerf spans. Until Setup has been
+// called with OTel enabled this is a no-op tracer.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Setup configures the global OTel trace and meter providers to export over
+// OTLP/HTTP. The returned function flushes and stops both providers.
+func Setup(ctx context.Context, cfg *config.OTelConfig, nodeID string) (func(context.Context) error, error) {
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "iceperf-agent"
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+		semconv.ServiceVersion(version.Version),
+		attribute.String("iceperf.node", nodeID),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	traceOpts := []otlptracehttp.Option{}
+	metricOpts := []otlpmetrichttp.Option{}
+	if cfg.Endpoint != "" {
+		traceOpts = append(traceOpts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		metricOpts = append(metricOpts, otlpmetrichttp.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		traceOpts = append(traceOpts, otlptracehttp.WithInsecure())
+		metricOpts = append(metricOpts, otlpmetrichttp.WithInsecure())
+	}
+	if len(cfg.Headers) > 0 {
+		traceOpts = append(traceOpts, otlptracehttp.WithHeaders(cfg.Headers))
+		metricOpts = append(metricOpts, otlpmetrichttp.WithHeaders(cfg.Headers))
+	}
+
+	traceExporter, err := otlptracehttp.New(ctx, traceOpts...)
+	if err != nil {
+		return nil, err
+	}
+	metricExporter, err := otlpmetrichttp.New(ctx, metricOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := 60 * time.Second
+	if cfg.MetricsInterval > 0 {
+		interval = time.Duration(cfg.MetricsInterval) * time.Second
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(traceExporter),
+		sdktrace.WithResource(res),
+	)
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter, sdkmetric.WithInterval(interval))),
+		sdkmetric.WithResource(res),
+	)
+
+	otel.SetTracerProvider(tp)
+	otel.SetMeterProvider(mp)
+
+	return func(ctx context.Context) error {
+		return errors.Join(tp.Shutdown(ctx), mp.Shutdown(ctx))
+	}, nil
+}
+
+// StatsAttributes returns the span attributes describing a single ICE server test result
+func StatsAttributes(st *stats.Stats) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("iceperf.test_run_id", st.TestRunID),
+		attribute.String("iceperf.provider", st.Provider),
+		attribute.String("iceperf.scheme", st.Scheme),
+		attribute.String("iceperf.protocol", st.Protocol),
+		attribute.String("iceperf.port", st.Port),
+		attribute.String("iceperf.node", st.Node),
+		attribute.Bool("iceperf.connected", st.Connected),
+		attribute.Int64("iceperf.time_to_connected_ms", st.TimeToConnectedState),
+		attribute.Float64("iceperf.offerer_time_to_candidate_ms", st.OffererTimeToReceiveCandidate),
+		attribute.Float64("iceperf.answerer_time_to_candidate_ms", st.AnswererTimeToReceiveCandidate),
+		attribute.Float64("iceperf.latency_first_packet_ms", st.LatencyFirstPacket),
+		attribute.Float64("iceperf.throughput_max_mbps", st.ThroughputMax),
+		attribute.Float64("iceperf.offerer_dc_bytes_sent", st.OffererDcBytesSentTotal),
+		attribute.Float64("iceperf.answerer_dc_bytes_received", st.AnswererDcBytesReceivedTotal),
+	}
+}
+
+type instruments struct {
+	tests             metric.Int64Counter
+	timeToCandidate   metric.Float64Histogram
+	timeToConnected   metric.Float64Histogram
+	latencyFirstPkt   metric.Float64Histogram
+	throughputMax     metric.Float64Histogram
+	iceTransportBytes metric.Float64Counter
+}
+
+var (
+	inst     *instruments
+	instOnce sync.Once
+)
+
+// the global meter delegates to the provider installed by Setup, so the
+// instruments can be created once up front
+func getInstruments() *instruments {
+	instOnce.Do(func() {
+		inst = newInstruments()
+	})
+	return inst
+}
+
+func newInstruments() *instruments {
+	meter := otel.Meter(instrumentationName)
+	i := &instruments{}
+	i.tests, _ = meter.Int64Counter("iceperf.tests",
+		metric.WithDescription("Number of ICE server tests run"))
+	i.timeToCandidate, _ = meter.Float64Histogram("iceperf.time_to_candidate",
+		metric.WithDescription("Time for the offerer to gather a srflx or relay candidate"), metric.WithUnit("ms"))
+	i.timeToConnected, _ = meter.Float64Histogram("iceperf.time_to_connected",
+		metric.WithDescription("Time for the offerer to reach the connected state"), metric.WithUnit("ms"))
+	i.latencyFirstPkt, _ = meter.Float64Histogram("iceperf.latency_first_packet",
+		metric.WithDescription("Time for the first data channel packet to reach the answerer"), metric.WithUnit("ms"))
+	i.throughputMax, _ = meter.Float64Histogram("iceperf.throughput_max",
+		metric.WithDescription("Maximum instantaneous throughput"), metric.WithUnit("Mbit/s"))
+	i.iceTransportBytes, _ = meter.Float64Counter("iceperf.ice_transport_bytes_sent",
+		metric.WithDescription("Bytes sent by the offerer ICE transport"), metric.WithUnit("By"))
+	return i
+}
+
+// RecordStats records the metrics for a finished ICE server test
+func RecordStats(ctx context.Context, st *stats.Stats) {
+	i := getInstruments()
+	attrs := metric.WithAttributes(
+		attribute.String("provider", st.Provider),
+		attribute.String("scheme", st.Scheme),
+		attribute.String("protocol", st.Protocol),
+		attribute.String("port", st.Port),
+		attribute.String("node", st.Node),
+	)
+
+	i.tests.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("provider", st.Provider),
+		attribute.String("scheme", st.Scheme),
+		attribute.String("protocol", st.Protocol),
+		attribute.String("port", st.Port),
+		attribute.String("node", st.Node),
+		attribute.Bool("connected", st.Connected),
+	))
+	i.iceTransportBytes.Add(ctx, st.OffererIceTransportBytesSentTotal, attrs)
+
+	if !st.Connected {
+		return
+	}
+	i.timeToCandidate.Record(ctx, st.OffererTimeToReceiveCandidate, attrs)
+	i.timeToConnected.Record(ctx, float64(st.TimeToConnectedState), attrs)
+	if st.LatencyFirstPacket > 0 {
+		i.latencyFirstPkt.Record(ctx, st.LatencyFirstPacket, attrs)
+	}
+	if st.ThroughputMax > 0 {
+		i.throughputMax.Record(ctx, st.ThroughputMax, attrs)
+	}
+}
+
+// Queue is a result queue whose depth is exported as metrics
+type Queue interface {
+	Dir() string
+	Depth() (int, error)
+	Dropped() int64
+}
+
+// ObserveQueueDepth reports the number of results waiting in the on-disk
+// queue, and how many have been dropped to stay under its size cap
+func ObserveQueueDepth(q Queue) error {
+	meter := otel.Meter(instrumentationName)
+	// each api sink has its own queue
+	attrs := metric.WithAttributes(attribute.String("dir", q.Dir()))
+	_, err := meter.Int64ObservableGauge("iceperf.result_queue.depth",
+		metric.WithDescription("Number of results waiting to be sent to the results API"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			depth, err := q.Depth()
+			if err != nil {
+				return err
+			}
+			o.Observe(int64(depth), attrs)
+			return nil
+		}))
+	if err != nil {
+		return err
+	}
+	_, err = meter.Int64ObservableCounter("iceperf.result_queue.dropped",
+		metric.WithDescription("Number of queued results dropped because the queue was full"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			o.Observe(q.Dropped(), attrs)
+			return nil
+		}))
+	return err
+}
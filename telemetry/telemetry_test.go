@@ -0,0 +1,110 @@
+package telemetry
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/nimbleape/iceperf-agent/config"
+	"github.com/nimbleape/iceperf-agent/stats"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+var reader = sdkmetric.NewManualReader()
+
+func TestMain(m *testing.M) {
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	os.Exit(m.Run())
+}
+
+// collect returns the metrics recorded so far by name
+func collect(t *testing.T) map[string]metricdata.Aggregation {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	assert.NoError(t, reader.Collect(context.Background(), &rm))
+	out := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			out[m.Name] = m.Data
+		}
+	}
+	return out
+}
+
+func TestSetupDisabled(t *testing.T) {
+	shutdown, err := Setup(context.Background(), &config.OTelConfig{}, "node")
+	assert.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+}
+
+func TestRecordStatsFromManyTests(t *testing.T) {
+	counts := func() (tests int64, connected uint64) {
+		got := collect(t)
+		if sum, ok := got["iceperf.tests"].(metricdata.Sum[int64]); ok {
+			for _, dp := range sum.DataPoints {
+				tests += dp.Value
+			}
+		}
+		if hist, ok := got["iceperf.time_to_connected"].(metricdata.Histogram[float64]); ok {
+			for _, dp := range hist.DataPoints {
+				connected += dp.Count
+			}
+		}
+		return tests, connected
+	}
+	testsBefore, connectedBefore := counts()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(connected bool) {
+			defer wg.Done()
+			st := stats.NewStats("run", time.Now())
+			st.SetProvider("cloudflare")
+			st.SetTimeToConnectedState(100)
+			st.Connected = connected
+			RecordStats(context.Background(), st)
+		}(i%2 == 0)
+	}
+	wg.Wait()
+
+	// only connected tests have timings
+	tests, connected := counts()
+	assert.Equal(t, int64(10), tests-testsBefore)
+	assert.Equal(t, uint64(5), connected-connectedBefore)
+}
+
+type fakeQueue struct{}
+
+func (fakeQueue) Dir() string         { return "/var/lib/iceperf/queue" }
+func (fakeQueue) Depth() (int, error) { return 3, nil }
+func (fakeQueue) Dropped() int64      { return 1 }
+
+func TestObserveQueueDepth(t *testing.T) {
+	assert.NoError(t, ObserveQueueDepth(fakeQueue{}))
+
+	got := collect(t)
+	depth := got["iceperf.result_queue.depth"].(metricdata.Gauge[int64])
+	assert.Equal(t, int64(3), depth.DataPoints[0].Value)
+	dir, _ := depth.DataPoints[0].Attributes.Value("dir")
+	assert.Equal(t, "/var/lib/iceperf/queue", dir.AsString())
+	dropped := got["iceperf.result_queue.dropped"].(metricdata.Sum[int64])
+	assert.Equal(t, int64(1), dropped.DataPoints[0].Value)
+}
+
+func TestStatsAttributes(t *testing.T) {
+	st := stats.NewStats("run", time.Now())
+	st.SetProvider("cloudflare")
+	attrs := map[string]any{}
+	for _, kv := range StatsAttributes(st) {
+		attrs[string(kv.Key)] = kv.Value.AsInterface()
+	}
+	assert.Equal(t, "run", attrs["iceperf.test_run_id"])
+	assert.Equal(t, "cloudflare", attrs["iceperf.provider"])
+	assert.Equal(t, false, attrs["iceperf.connected"])
+}